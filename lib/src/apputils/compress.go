@@ -0,0 +1,89 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Gzip for responses big enough to be worth it, if the client accepts it.
+// App Engine compresses responses itself when the client says it can take
+// gzip, so this mostly matters to the standalone frontend.
+package apputils
+
+import(
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const(
+	GzipMinSize	= 256	// smaller bodies gain little and cost a gzip header
+)
+
+func AcceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			params := strings.Split(coding, ";")
+			if strings.TrimSpace(params[0]) != "gzip" {
+				continue
+			}
+
+			// "gzip;q=0" means anything but gzip
+			refused := false
+			for _, param := range params[1:] {
+				q := strings.TrimSpace(param)
+				if strings.HasPrefix(q, "q=") && strings.Trim(q[2:], "0.") == "" {
+					refused = true
+				}
+			}
+			if !refused {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//
+// Writes body as a 200 with the given Content-Type, gzipped if that helps.
+//
+func WriteCompressed(w http.ResponseWriter, r *http.Request, contentType string, body []byte) error {
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Add("Vary", "Accept-Encoding")
+
+	if len(body) >= GzipMinSize && AcceptsGzip(r) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(body)
+		if err == nil {
+			err = gz.Close()
+		}
+		if err != nil {
+			return err
+		}
+
+		if buf.Len() < len(body) {
+			header.Set("Content-Encoding", "gzip")
+			body = buf.Bytes()
+		}
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	_, err := w.Write(body)
+
+	return err
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		accept	[]string
+		gzip	bool
+	}{
+		{nil,					false},
+		{[]string{"gzip"},			true},
+		{[]string{"deflate, gzip"},		true},
+		{[]string{"br", "gzip;q=0.5"},		true},
+		{[]string{"gzip; q=1.0"},		true},
+		{[]string{"gzip;q=0"},			false},
+		{[]string{"gzip;q=0.000"},		false},
+		{[]string{"x-gzip, identity"},		false},
+		{[]string{"*"},				false},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header["Accept-Encoding"] = test.accept
+
+		if got := AcceptsGzip(r); got != test.gzip {
+			t.Errorf("%q: got %v, want %v", test.accept, got, test.gzip)
+		}
+	}
+}
+
+func TestWriteCompressed(t *testing.T) {
+	compressible := bytes.Repeat([]byte("hunt "), 200)
+	random := make([]byte, 1000)
+	rand.Read(random)
+
+	tests := []struct {
+		name	string
+		body	[]byte
+		accept	string
+		gzipped	bool
+	}{
+		{"compressible",	compressible,		"gzip",	true},
+		{"not accepted",	compressible,		"",	false},
+		{"small",		compressible[:GzipMinSize-1],	"gzip",	false},
+		{"incompressible",	random,			"gzip",	false},
+		{"empty",		nil,			"gzip",	false},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept-Encoding", test.accept)
+		}
+		w := httptest.NewRecorder()
+
+		err := WriteCompressed(w, r, "application/octet-stream", test.body)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		h := w.Header()
+		if h.Get("Content-Type") != "application/octet-stream" || h.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: headers %v", test.name, h)
+		}
+		if h.Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("%s: Content-Length %s for %d bytes", test.name, h.Get("Content-Length"), w.Body.Len())
+		}
+		if gzipped := h.Get("Content-Encoding") == "gzip"; gzipped != test.gzipped {
+			t.Errorf("%s: gzipped %v, want %v", test.name, gzipped, test.gzipped)
+			continue
+		}
+
+		body := w.Body.Bytes()
+		if test.gzipped {
+			if len(body) >= len(test.body) {
+				t.Errorf("%s: gzipped to %d bytes from %d", test.name, len(body), len(test.body))
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			body, err = ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if !bytes.Equal(body, test.body) {
+			t.Errorf("%s: body doesn't match", test.name)
+		}
+	}
+}
@@ -22,9 +22,6 @@ import(
 	"net/http"
 	"net/url"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-
 	gjson "github.com/gorilla/rpc/json"
 )
 
@@ -32,40 +29,54 @@ const(
 	DEBUG	= false
 )
 
+//
+// Returned by HttpJsonRpc when the server answered, but not with a result,
+// e.g. because the method failed.  Other errors mean the server couldn't be
+// reached or didn't speak JSON-RPC over HTTP.
+//
+type JsonRpcError struct {
+	Err	error
+}
+
+func (e *JsonRpcError) Error() string {
+	return e.Err.Error()
+}
+
 /*
- * This method and the supporting RProxy() function are purpose built for App Engine,
+ * This method and the supporting RProxy() function were purpose built for App Engine,
  * And more specifically for Appengine apps that want to either
  * talk between modules in the same app, or talk between apps.
+ * Outbound requests and logging go through the current Platform.
  */
-func HttpJsonRpc(r *http.Request, u *url.URL, method string, rpoptions uint64, request interface{}, reply interface{}) error {
-	ctx := appengine.NewContext(r)
-
-	cfg := &RProxyConfig{
-		Add:		[]*Header{
-					&Header{Key: "REDACTED", Value: "REDACTED"},
-					&Header{Key: "Content-Type", Value: "application/json;charset=utf-8"},
-					&Header{Key: "Accept", Value: "application/json;charset=utf-8"},
-				},
-		Options:	rpoptions,
-	}
+//
+// base carries the caller's options, signer and transport; the headers needed
+// for JSON-RPC are added to a copy of it.
+//
+func HttpJsonRpc(r *http.Request, u *url.URL, method string, base *RProxyConfig, request interface{}, reply interface{}) error {
+	cfg := *base
+	cfg.Add = append([]*Header{
+			&Header{Key: "REDACTED", Value: "REDACTED"},
+			&Header{Key: "Content-Type", Value: "application/json;charset=utf-8"},
+			&Header{Key: "Accept", Value: "application/json;charset=utf-8"},
+		}, base.Add...)
 
 	buf, err := gjson.EncodeClientRequest(method, request)
 	if err != nil {
-		log.Errorf(ctx, "encode: %v", err)
+		Logf(r, "encode: %v", err)
 		return err
 	}
 
 	var rbuf []byte
-	rbuf, err = RProxy(r, cfg, "POST", u, buf)
+	rbuf, err = RProxy(r, &cfg, "POST", u, buf)
 	if err != nil {
-		log.Errorf(ctx, "RProxy: %v", err)
+		Logf(r, "RProxy: %v", err)
 		return err
 	}
 
 	err = gjson.DecodeClientResponse(bytes.NewBuffer(rbuf), reply)
 	if err != nil {
-		log.Errorf(ctx, "Decode Client Response: %v", err)
-		return err
+		Logf(r, "Decode Client Response: %v", err)
+		return &JsonRpcError{Err: err}
 	}
 
 	return nil
@@ -21,25 +21,21 @@ import(
 	"net/http"
 
 	"github.com/tadhunt/httputils"
-
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 )
 
 func logit(r *http.Request, herr *httputils.HttpError) {
-	ctx := appengine.NewContext(r)
 	if herr.Errs != nil {
-		log.Errorf(ctx, "Binding Error: %s", herr.Errs.Error())
+		Logf(r, "Binding Error: %s", herr.Errs.Error())
 	}
 
 	if herr.LogMsg != "" {
-		log.Errorf(ctx, "%s", herr.LogMsg)
+		Logf(r, "%s", herr.LogMsg)
 	}
 }
 
 /*
  * TODO(tadhunt): replace hacky wrapper around open source http logging library to correctly
- * log appengine errors with something better
+ * log platform errors with something better
  */
 func InternalServerError(w http.ResponseWriter, r *http.Request, msg string, logErr error) {
 	herr := httputils.NewHttpError()
@@ -0,0 +1,211 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Transports which carry keepalives from game servers to the frontend.
+//
+//	pubsub	published to a Cloud Pub/Sub topic, pulled by the frontend from cron
+//	http	POSTed to the frontend, signed with a shared secret
+//	local	delivered to handlers in the same process
+package apputils
+
+import(
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"cloud.google.com/go/pubsub"
+)
+
+const(
+	KeepAliveTimestampHeader	= "X-Hunt-Keepalive-Timestamp"
+	KeepAliveSignatureHeader	= "X-Hunt-Keepalive-Signature"
+	KeepAliveSendTimeout		= 10 * time.Second
+)
+
+type KeepAliveTransport interface {
+	Send(km *KeepAliveMessage) error
+}
+
+type PubSubKeepAliveTransport struct {
+	topic	*pubsub.Topic
+}
+
+type HTTPKeepAliveTransport struct {
+	url	string
+	secret	[]byte
+	client	*http.Client
+}
+
+//
+// Delivers keepalives straight to the registry of a frontend running in the
+// same process as the game server, e.g. a standalone frontend with a
+// co-located game server, or tests.
+//
+type LocalKeepAliveTransport struct {
+	lock		sync.Mutex
+	handlers	[]func(km *KeepAliveMessage) error
+}
+
+var localKeepAlive = &LocalKeepAliveTransport{}
+
+func NewPubSubKeepAliveTransport(project string, topic string) (*PubSubKeepAliveTransport, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("missing keepalive topic")
+	}
+
+	client, err := pubsub.NewClient(context.Background(), project)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := client.CreateTopic(context.Background(), topic)
+	if err != nil {
+		s := fmt.Sprintf("%v", err)
+		if !strings.Contains(s, "Resource already exists in the project") {
+			return nil, err
+		}
+
+		t = client.Topic(topic)
+	}
+
+	return &PubSubKeepAliveTransport{topic: t}, nil
+}
+
+func (t *PubSubKeepAliveTransport) Send(km *KeepAliveMessage) error {
+	_, err := t.topic.Publish(context.Background(), km.PubSubMessage())
+
+	return err
+}
+
+// u is the frontend keepalive endpoint, e.g. https://frontend/api/v1/keepalive/push
+func NewHTTPKeepAliveTransport(u string, secret string) (*HTTPKeepAliveTransport, error) {
+	if u == "" {
+		return nil, fmt.Errorf("missing keepalive url")
+	}
+
+	if secret == "" {
+		return nil, fmt.Errorf("missing keepalive secret")
+	}
+
+	t := &HTTPKeepAliveTransport{
+		url:	u,
+		secret:	[]byte(secret),
+		client:	&http.Client{Timeout: KeepAliveSendTimeout},
+	}
+
+	return t, nil
+}
+
+func (t *HTTPKeepAliveTransport) Send(km *KeepAliveMessage) error {
+	body, err := json.Marshal(km)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := SignatureTimestamp(time.Now())
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	req.Header.Set("Accept", "application/json;charset=utf-8")
+	req.Header.Set(KeepAliveTimestampHeader, timestamp)
+	req.Header.Set(KeepAliveSignatureHeader, Sign(t.secret, timestamp, body))
+
+	rsp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(rsp.Body)
+		return fmt.Errorf("HTTP Error %d: %s", rsp.StatusCode, string(data))
+	}
+
+	return nil
+}
+
+//
+// Checks the signature of a keepalive sent by an HTTPKeepAliveTransport
+// and decodes it.  The timestamp is checked before the body is read, and
+// the body can't be larger than MaxSignedBodySize.
+//
+func ReadSignedKeepAlive(r *http.Request, secret string) (*KeepAliveMessage, error) {
+	timestamp := r.Header.Get(KeepAliveTimestampHeader)
+	signature := r.Header.Get(KeepAliveSignatureHeader)
+
+	now := time.Now()
+	err := CheckSignatureTimestamp(timestamp, now)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := readSignedBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = VerifySignature([]byte(secret), timestamp, body, signature, now)
+	if err != nil {
+		return nil, err
+	}
+
+	km := &KeepAliveMessage{}
+	err = json.Unmarshal(body, km)
+	if err != nil {
+		return nil, err
+	}
+
+	return km, nil
+}
+
+// The process wide in-process transport
+func LocalKeepAlive() *LocalKeepAliveTransport {
+	return localKeepAlive
+}
+
+func (t *LocalKeepAliveTransport) Subscribe(handler func(km *KeepAliveMessage) error) {
+	t.lock.Lock()
+	t.handlers = append(t.handlers, handler)
+	t.lock.Unlock()
+}
+
+func (t *LocalKeepAliveTransport) Send(km *KeepAliveMessage) error {
+	t.lock.Lock()
+	handlers := t.handlers
+	t.lock.Unlock()
+
+	if len(handlers) == 0 {
+		return fmt.Errorf("no local keepalive subscribers")
+	}
+
+	for _, handler := range handlers {
+		err := handler(km)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
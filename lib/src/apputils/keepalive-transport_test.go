@@ -0,0 +1,119 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPKeepAliveTransport(t *testing.T) {
+	var got *KeepAliveMessage
+	var gotErr error
+
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, gotErr = ReadSignedKeepAlive(r, "secret")
+		if gotErr != nil {
+			http.Error(w, gotErr.Error(), http.StatusUnauthorized)
+		}
+	}))
+	defer frontend.Close()
+
+	u, _ := url.Parse("http://game:12345/jsonrpc")
+	km := &KeepAliveMessage{Hostname: "host", Instance: "0", Seq: 7, URL: u}
+
+	tr, err := NewHTTPKeepAliveTransport(frontend.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Send(km); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got == nil || got.Instance != "0" || got.Seq != 7 || got.URL.String() != u.String() {
+		t.Fatalf("received %+v", got)
+	}
+
+	tr, _ = NewHTTPKeepAliveTransport(frontend.URL, "wrong")
+	if err := tr.Send(km); err == nil || gotErr == nil {
+		t.Fatalf("keepalive signed with the wrong secret was accepted")
+	}
+}
+
+func TestReadSignedKeepAliveLimits(t *testing.T) {
+	now := time.Now()
+
+	large := []byte(strings.Repeat("x", MaxSignedBodySize + 1))
+	timestamp := SignatureTimestamp(now)
+	r := httptest.NewRequest("POST", "/api/v1/keepalive/push", bytes.NewReader(large))
+	r.Header.Set(KeepAliveTimestampHeader, timestamp)
+	r.Header.Set(KeepAliveSignatureHeader, Sign([]byte("secret"), timestamp, large))
+	if _, err := ReadSignedKeepAlive(r, "secret"); err != ErrBodyTooLarge {
+		t.Errorf("large keepalive: got %v, want ErrBodyTooLarge", err)
+	}
+
+	// a stale keepalive is turned away before its body is read
+	body := &watchedBody{}
+	r = httptest.NewRequest("POST", "/api/v1/keepalive/push", nil)
+	r.Body = body
+	r.Header.Set(KeepAliveTimestampHeader, SignatureTimestamp(now.Add(-SignatureMaxSkew - time.Minute)))
+	if _, err := ReadSignedKeepAlive(r, "secret"); err != ErrStaleSignature || body.read {
+		t.Errorf("stale keepalive: got %v, body read %v", err, body.read)
+	}
+}
+
+func TestHTTPKeepAliveTransportConfig(t *testing.T) {
+	if _, err := NewHTTPKeepAliveTransport("", "secret"); err == nil {
+		t.Errorf("missing url accepted")
+	}
+	if _, err := NewHTTPKeepAliveTransport("http://frontend", ""); err == nil {
+		t.Errorf("missing secret accepted")
+	}
+}
+
+func TestLocalKeepAliveTransport(t *testing.T) {
+	tr := &LocalKeepAliveTransport{}
+	km := &KeepAliveMessage{Instance: "0", Seq: 1}
+
+	if err := tr.Send(km); err == nil {
+		t.Errorf("keepalive without subscribers accepted")
+	}
+
+	var got []*KeepAliveMessage
+	tr.Subscribe(func(km *KeepAliveMessage) error {
+		got = append(got, km)
+		return nil
+	})
+	if err := tr.Send(km); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(got) != 1 || got[0] != km {
+		t.Fatalf("delivered %v", got)
+	}
+
+	tr.Subscribe(func(km *KeepAliveMessage) error {
+		return fmt.Errorf("registry full")
+	})
+	if err := tr.Send(km); err == nil {
+		t.Errorf("subscriber error not returned")
+	}
+}
@@ -18,6 +18,7 @@
 package apputils
 
 import(
+	"encoding/json"
 	"fmt"
 	"io"
 	"errors"
@@ -28,34 +29,120 @@ import(
 	"time"
 
 	"golang.org/x/net/context"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 	"cloud.google.com/go/pubsub"
 )
 
 var ErrKeepaliveTimeout = errors.New("Keepalive timeout")
 
+type RoomStatus struct {
+	RoomID		string
+	Players		int
+	MaxPlayers	int
+	Teams		map[string]int	// players per team, "none" for players without a team
+}
+
+// Capacity, load and version of a game server, as of its last keepalive
+type InstanceStatus struct {
+	Players		int
+	MaxPlayers	int
+	Rooms		[]*RoomStatus
+	Version		string		// server-game build version
+	Protocol	string		// huntd protocol variant
+	Uptime		int64		// seconds since the game server started
+	Draining	bool		// not accepting new players
+	TeamPolicy	*TeamPolicy
+}
+
 type KeepAliveMessage struct {
 	Hostname	string
 	Instance	string
 	Seq		uint64
 	URL		*url.URL
+	Status		*InstanceStatus	// nil if sent by an older game server
+}
+
+// JSON representation, used by transports other than Pub/Sub
+type keepAliveJSON struct {
+	Hostname	string
+	Instance	string
+	Seq		uint64
+	URL		string
+	Status		*InstanceStatus
 }
 
 func (m *KeepAliveMessage) String() string {
 	return fmt.Sprintf("KeepAliveMessage{Hostname: %s, Instance: %s, Seq: %d, URL: %s}", m.Hostname, m.Instance, m.Seq, m.URL.String())
 }
 
+func (m *KeepAliveMessage) MarshalJSON() ([]byte, error) {
+	km := &keepAliveJSON{
+		Hostname:	m.Hostname,
+		Instance:	m.Instance,
+		Seq:		m.Seq,
+		URL:		m.URL.String(),
+		Status:		m.Status,
+	}
+
+	return json.Marshal(km)
+}
+
+func (m *KeepAliveMessage) UnmarshalJSON(data []byte) error {
+	var km keepAliveJSON
+
+	err := json.Unmarshal(data, &km)
+	if err != nil {
+		return err
+	}
+
+	if km.Instance == "" {
+		return fmt.Errorf("missing Instance")
+	}
+
+	u, err := url.Parse(km.URL)
+	if err != nil {
+		return err
+	}
+
+	m.Hostname = km.Hostname
+	m.Instance = km.Instance
+	m.Seq = km.Seq
+	m.URL = u
+	m.Status = km.Status
+
+	return nil
+}
+
+func (m *KeepAliveMessage) PubSubMessage() *pubsub.Message {
+	msg := &pubsub.Message{
+		Attributes: map[string]string{
+			"hostname":	m.Hostname,
+			"instance":	m.Instance,
+			"seq":		fmt.Sprintf("%d", m.Seq),
+		},
+		Data: []byte(m.URL.String()),
+	}
+
+	if m.Status != nil {
+		status, err := json.Marshal(m.Status)
+		if err == nil {
+			msg.Attributes["status"] = string(status)
+		}
+	}
+
+	return msg
+}
+
 type KeepAlive struct {
+	r		*http.Request
 	ctx		context.Context
 	client		*pubsub.Client
 	subscription	*pubsub.Subscription
 }
 
 func NewKeepAlive(r *http.Request, topic string, timeout time.Duration) (*KeepAlive, error) {
-	ctx, _ := context.WithTimeout(appengine.NewContext(r), timeout)
+	ctx, _ := context.WithTimeout(Context(r), timeout)
 
-	appid := appengine.AppID(ctx)
+	appid := AppID(r)
 
 	client, err := pubsub.NewClient(ctx, appid)
 	if err != nil {
@@ -72,6 +159,7 @@ func NewKeepAlive(r *http.Request, topic string, timeout time.Duration) (*KeepAl
 	}
 
 	keepalive := &KeepAlive {
+		r:		r,
 		ctx:		ctx,
 		client:		client,
 		subscription:	sub,
@@ -99,6 +187,15 @@ func ParseKeepAliveMessage(m *pubsub.Message) (*KeepAliveMessage, error) {
 		return nil, err
 	}
 
+	status, found := m.Attributes["status"]
+	if found {
+		km.Status = &InstanceStatus{}
+		err = json.Unmarshal([]byte(status), km.Status)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return km, nil
 }
 
@@ -106,21 +203,21 @@ func ParseKeepAliveMessage(m *pubsub.Message) (*KeepAliveMessage, error) {
 // Fetch and process (waiting if necessary) messages for up to the timeout given  when the keepalive was created
 //
 func (keepalive *KeepAlive) Pull(msgHandler func(msg *KeepAliveMessage) error) error {
-	log.Errorf(keepalive.ctx, "keepalive.Pull: start")
+	Logf(keepalive.r, "keepalive.Pull: start")
 
 	it, err := keepalive.subscription.Pull(keepalive.ctx, pubsub.MaxPrefetch(1), pubsub.MaxExtension(10*time.Second))
 	if err != nil {
 		if err == context.DeadlineExceeded || strings.Contains(err.Error(), "deadline exceeded") {
-			log.Errorf(keepalive.ctx, "keepalive.Pull: timed out")
+			Logf(keepalive.r, "keepalive.Pull: timed out")
 			return nil
 		}
 
-		log.Errorf(keepalive.ctx, "keepalive.Pull: %v", err)
+		Logf(keepalive.r, "keepalive.Pull: %v", err)
 		return err
 	}
 	defer it.Stop()
 
-	log.Errorf(keepalive.ctx, "keepalive.Pull: iterate")
+	Logf(keepalive.r, "keepalive.Pull: iterate")
 
 	n := 0
 	for n = 0; ; n++ {
@@ -131,11 +228,11 @@ func (keepalive *KeepAlive) Pull(msgHandler func(msg *KeepAliveMessage) error) e
 		if err != nil {
 			if err == context.DeadlineExceeded || strings.Contains(err.Error(), "Deadline exceeded") {
 				// XXX(tad): strings.Contains is necessary because it's not returning the original error, it's returning an extended one
-				log.Errorf(keepalive.ctx, "keepalive.Pull[%d]: %v", n, err)
+				Logf(keepalive.r, "keepalive.Pull[%d]: %v", n, err)
 				break
 			}
 
-			log.Errorf(keepalive.ctx, "keepalive.Pull[%d]: %v", n, err)
+			Logf(keepalive.r, "keepalive.Pull[%d]: %v", n, err)
 			return err
 		}
 
@@ -143,7 +240,7 @@ func (keepalive *KeepAlive) Pull(msgHandler func(msg *KeepAliveMessage) error) e
 
 		km, err := ParseKeepAliveMessage(m)
 		if err != nil {
-			log.Errorf(keepalive.ctx, "keepalive.Pull[%d]: %v", n, err)
+			Logf(keepalive.r, "keepalive.Pull[%d]: %v", n, err)
 			return err
 		}
 
@@ -153,7 +250,7 @@ func (keepalive *KeepAlive) Pull(msgHandler func(msg *KeepAliveMessage) error) e
 		}
 	}
 
-	log.Errorf(keepalive.ctx, "keepalive.Pull: complete after processing %d messages\n", n)
+	Logf(keepalive.r, "keepalive.Pull: complete after processing %d messages\n", n)
 
 	return nil
 }
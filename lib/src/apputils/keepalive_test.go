@@ -0,0 +1,96 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func testKeepAliveMessage(status *InstanceStatus) *KeepAliveMessage {
+	u, _ := url.Parse("https://3-dot-server-game.example.com/jsonrpc")
+
+	return &KeepAliveMessage{Hostname: "game-3", Instance: "3", Seq: 42, URL: u, Status: status}
+}
+
+func testInstanceStatus() *InstanceStatus {
+	return &InstanceStatus{
+		Players:	3,
+		MaxPlayers:	25,
+		Rooms:		[]*RoomStatus{
+					{RoomID: "0", Players: 3, MaxPlayers: 25, Teams: map[string]int{"0": 2, "none": 1}},
+				},
+		Version:	"v1.2",
+		Protocol:	"debian",
+		Uptime:		3600,
+		Draining:	true,
+		TeamPolicy:	&TeamPolicy{Teams: 2, MaxTeamSize: 5, NoTeam: NO_TEAM_AUTO},
+	}
+}
+
+func checkKeepAliveMessage(t *testing.T, how string, got *KeepAliveMessage, want *KeepAliveMessage) {
+	if got.Hostname != want.Hostname || got.Instance != want.Instance || got.Seq != want.Seq || got.URL.String() != want.URL.String() {
+		t.Errorf("%s: got %v, want %v", how, got, want)
+	}
+	if !reflect.DeepEqual(got.Status, want.Status) {
+		t.Errorf("%s: got status %+v, want %+v", how, got.Status, want.Status)
+	}
+}
+
+func TestKeepAliveMessageJSON(t *testing.T) {
+	for _, status := range []*InstanceStatus{testInstanceStatus(), nil} {
+		km := testKeepAliveMessage(status)
+
+		data, err := json.Marshal(km)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := &KeepAliveMessage{}
+		err = json.Unmarshal(data, got)
+		if err != nil {
+			t.Fatalf("Unmarshal %s: %v", data, err)
+		}
+		checkKeepAliveMessage(t, "JSON", got, km)
+	}
+
+	err := json.Unmarshal([]byte(`{"Seq": 1, "URL": "http://game/jsonrpc"}`), &KeepAliveMessage{})
+	if err == nil {
+		t.Errorf("keepalive without an Instance accepted")
+	}
+}
+
+func TestKeepAliveMessagePubSub(t *testing.T) {
+	for _, status := range []*InstanceStatus{testInstanceStatus(), nil} {
+		km := testKeepAliveMessage(status)
+
+		got, err := ParseKeepAliveMessage(km.PubSubMessage())
+		if err != nil {
+			t.Fatalf("ParseKeepAliveMessage: %v", err)
+		}
+		checkKeepAliveMessage(t, "Pub/Sub", got, km)
+	}
+
+	msg := testKeepAliveMessage(nil).PubSubMessage()
+	msg.Attributes["seq"] = "x"
+	_, err := ParseKeepAliveMessage(msg)
+	if err == nil {
+		t.Errorf("bad seq accepted")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+// +build appengine
+
+//
+// App Engine Standard implementation of the Platform services:
+// the GAE log API, urlfetch, memcache and datastore.
+package apputils
+
+import(
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/urlfetch"
+
+	"golang.org/x/net/context"
+)
+
+type appEngineRuntime struct{}
+type appEngineLogger struct{}
+type appEngineFetcher struct{}
+type appEngineCache struct{}
+type appEngineStore struct{}
+
+func defaultPlatform() *Platform {
+	return NewAppEnginePlatform()
+}
+
+func NewAppEnginePlatform() *Platform {
+	return &Platform{
+		Name:		"appengine",
+		Runtime:	appEngineRuntime{},
+		Log:		appEngineLogger{},
+		Fetch:		appEngineFetcher{},
+		Cache:		appEngineCache{},
+		Store:		appEngineStore{},
+	}
+}
+
+func (appEngineRuntime) Context(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
+
+func (appEngineRuntime) AppID(r *http.Request) string {
+	return appengine.AppID(appengine.NewContext(r))
+}
+
+func (appEngineRuntime) Hostname(r *http.Request) string {
+	return appengine.DefaultVersionHostname(appengine.NewContext(r))
+}
+
+func (appEngineLogger) Logf(r *http.Request, format string, v ...interface{}) {
+	log.Errorf(appengine.NewContext(r), format, v...)
+}
+
+func (appEngineFetcher) Client(r *http.Request) *http.Client {
+	return urlfetch.Client(appengine.NewContext(r))
+}
+
+func (appEngineCache) Get(r *http.Request, key string, v interface{}) error {
+	_, err := memcache.JSON.Get(appengine.NewContext(r), key, v)
+	if err == memcache.ErrCacheMiss {
+		return ErrCacheMiss
+	}
+
+	return err
+}
+
+func (appEngineCache) Set(r *http.Request, key string, v interface{}, expiration time.Duration) error {
+	item := &memcache.Item {
+		Key:		key,
+		Object:		v,
+		Expiration:	expiration,
+	}
+
+	return memcache.JSON.Set(appengine.NewContext(r), item)
+}
+
+func (appEngineCache) Add(r *http.Request, key string, v interface{}, expiration time.Duration) error {
+	item := &memcache.Item {
+		Key:		key,
+		Object:		v,
+		Expiration:	expiration,
+	}
+
+	err := memcache.JSON.Add(appengine.NewContext(r), item)
+	if err == memcache.ErrNotStored {
+		return ErrNotStored
+	}
+
+	return err
+}
+
+func (appEngineCache) Delete(r *http.Request, key string) error {
+	err := memcache.Delete(appengine.NewContext(r), key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+
+	return err
+}
+
+func (appEngineStore) Get(r *http.Request, kind string, key string, v interface{}) error {
+	ctx := appengine.NewContext(r)
+
+	err := datastore.Get(ctx, datastore.NewKey(ctx, kind, key, 0, nil), v)
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchEntity
+	}
+
+	return err
+}
+
+func (appEngineStore) Put(r *http.Request, kind string, key string, v interface{}) error {
+	ctx := appengine.NewContext(r)
+
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, kind, key, 0, nil), v)
+
+	return err
+}
+
+func (appEngineStore) Delete(r *http.Request, kind string, key string) error {
+	ctx := appengine.NewContext(r)
+
+	return datastore.Delete(ctx, datastore.NewKey(ctx, kind, key, 0, nil))
+}
+
+func (appEngineStore) GetAll(r *http.Request, kind string, dst interface{}) ([]string, error) {
+	ctx := appengine.NewContext(r)
+
+	keys, err := datastore.NewQuery(kind).GetAll(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = key.StringID()
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+// +build !appengine
+
+//
+// Builds without App Engine start out on the standalone platform, with an
+// in-memory store, and don't link the App Engine SDK at all.
+package apputils
+
+func defaultPlatform() *Platform {
+	p, err := NewStandalonePlatform("", "")
+	if err != nil {
+		panic(err)	// can't happen without a store directory
+	}
+
+	return p
+}
@@ -0,0 +1,332 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Platform services for running as a plain net/http server outside of
+// App Engine: the standard library logger and HTTP client, an in-process
+// cache, and a store that is either in-memory or backed by JSON files.
+package apputils
+
+import(
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const(
+	StandaloneFetchTimeout	= 30 * time.Second
+)
+
+type standaloneRuntime struct {
+	appID	string
+}
+
+type standaloneLogger struct{}
+
+type standaloneFetcher struct {
+	client	*http.Client
+}
+
+type cacheEntry struct {
+	data	[]byte
+	expires	time.Time
+}
+
+type MemoryCache struct {
+	lock	sync.Mutex
+	entries	map[string]*cacheEntry
+}
+
+//
+// Entities are kept JSON encoded, one map per kind.  If dir is not empty
+// each kind is also persisted to dir/<kind>.json and reloaded on startup.
+//
+type FileStore struct {
+	lock	sync.Mutex
+	dir	string
+	kinds	map[string]map[string]json.RawMessage
+}
+
+//
+// appID is reported to code that needs a project (e.g. Cloud Pub/Sub),
+// storeDir selects where the store persists entities; "" keeps them in memory.
+//
+func NewStandalonePlatform(appID string, storeDir string) (*Platform, error) {
+	store, err := NewFileStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Platform{
+		Name:		"standalone",
+		Runtime:	&standaloneRuntime{appID: appID},
+		Log:		standaloneLogger{},
+		Fetch:		&standaloneFetcher{client: &http.Client{Timeout: StandaloneFetchTimeout}},
+		Cache:		NewMemoryCache(),
+		Store:		store,
+	}
+
+	return p, nil
+}
+
+func (rt *standaloneRuntime) Context(r *http.Request) context.Context {
+	return r.Context()
+}
+
+func (rt *standaloneRuntime) AppID(r *http.Request) string {
+	return rt.appID
+}
+
+func (rt *standaloneRuntime) Hostname(r *http.Request) string {
+	return r.Host
+}
+
+func (standaloneLogger) Logf(r *http.Request, format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (f *standaloneFetcher) Client(r *http.Request) *http.Client {
+	// copy so that callers can safely modify things like CheckRedirect
+	client := *f.client
+	return &client
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:	make(map[string]*cacheEntry),
+	}
+}
+
+// the unexpired entry for key, called with the lock held
+func (c *MemoryCache) lookup(key string) (*cacheEntry, bool) {
+	e, found := c.entries[key]
+	if found && !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		found = false
+	}
+
+	return e, found
+}
+
+func (c *MemoryCache) Get(r *http.Request, key string, v interface{}) error {
+	c.lock.Lock()
+	e, found := c.lookup(key)
+	c.lock.Unlock()
+
+	if !found {
+		return ErrCacheMiss
+	}
+
+	return json.Unmarshal(e.data, v)
+}
+
+func newCacheEntry(v interface{}, expiration time.Duration) (*cacheEntry, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &cacheEntry{data: data}
+	if expiration > 0 {
+		e.expires = time.Now().Add(expiration)
+	}
+
+	return e, nil
+}
+
+func (c *MemoryCache) Set(r *http.Request, key string, v interface{}, expiration time.Duration) error {
+	e, err := newCacheEntry(v, expiration)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.entries[key] = e
+	c.lock.Unlock()
+
+	return nil
+}
+
+func (c *MemoryCache) Add(r *http.Request, key string, v interface{}, expiration time.Duration) error {
+	e, err := newCacheEntry(v, expiration)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, found := c.lookup(key)
+	if found {
+		return ErrNotStored
+	}
+
+	c.entries[key] = e
+
+	return nil
+}
+
+func (c *MemoryCache) Delete(r *http.Request, key string) error {
+	c.lock.Lock()
+	delete(c.entries, key)
+	c.lock.Unlock()
+
+	return nil
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	s := &FileStore{
+		dir:	dir,
+		kinds:	make(map[string]map[string]json.RawMessage),
+	}
+
+	if dir == "" {
+		return s, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		entities := make(map[string]json.RawMessage)
+		err = json.Unmarshal(data, &entities)
+		if err != nil {
+			return nil, err
+		}
+
+		kind := filepath.Base(path)
+		kind = kind[:len(kind)-len(".json")]
+		s.kinds[kind] = entities
+	}
+
+	return s, nil
+}
+
+// must be called with the lock held
+func (s *FileStore) sync(kind string) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.kinds[kind])
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, kind + ".json")
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) Get(r *http.Request, kind string, key string, v interface{}) error {
+	s.lock.Lock()
+	data, found := s.kinds[kind][key]
+	s.lock.Unlock()
+
+	if !found {
+		return ErrNoSuchEntity
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func (s *FileStore) Put(r *http.Request, kind string, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entities, found := s.kinds[kind]
+	if !found {
+		entities = make(map[string]json.RawMessage)
+		s.kinds[kind] = entities
+	}
+	entities[key] = data
+
+	return s.sync(kind)
+}
+
+func (s *FileStore) Delete(r *http.Request, kind string, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entities, found := s.kinds[kind]
+	if !found {
+		return nil
+	}
+	delete(entities, key)
+
+	return s.sync(kind)
+}
+
+func (s *FileStore) GetAll(r *http.Request, kind string, dst interface{}) ([]string, error) {
+	s.lock.Lock()
+	entities := s.kinds[kind]
+
+	keys := make([]string, 0, len(entities))
+	for key := range entities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// decode everything at once by presenting the entities as a JSON array
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(entities[key])
+	}
+	buf.WriteByte(']')
+	s.lock.Unlock()
+
+	err := json.Unmarshal(buf.Bytes(), dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
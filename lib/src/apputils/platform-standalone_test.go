@@ -0,0 +1,123 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type testEntity struct {
+	Name	string
+	N	int
+}
+
+func TestDefaultPlatformIsStandalone(t *testing.T) {
+	if p := defaultPlatform(); p.Name != "standalone" {
+		t.Fatalf("defaultPlatform without the appengine tag: got %s", p.Name)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemoryCache()
+
+	var v testEntity
+	if err := c.Get(nil, "k", &v); err != ErrCacheMiss {
+		t.Fatalf("Get missing: got %v want ErrCacheMiss", err)
+	}
+
+	if err := c.Set(nil, "k", &testEntity{Name: "a", N: 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(nil, "k", &v); err != nil || v.Name != "a" || v.N != 1 {
+		t.Fatalf("Get: got %+v %v", v, err)
+	}
+
+	if err := c.Set(nil, "short", &testEntity{}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Get(nil, "short", &v); err != ErrCacheMiss {
+		t.Fatalf("Get expired: got %v want ErrCacheMiss", err)
+	}
+
+	c.Delete(nil, "k")
+	if err := c.Get(nil, "k", &v); err != ErrCacheMiss {
+		t.Fatalf("Get deleted: got %v want ErrCacheMiss", err)
+	}
+}
+
+func TestMemoryCacheAdd(t *testing.T) {
+	c := NewMemoryCache()
+
+	if err := c.Add(nil, "k", &testEntity{Name: "a"}, 0); err != nil {
+		t.Fatalf("Add missing: %v", err)
+	}
+	if err := c.Add(nil, "k", &testEntity{Name: "b"}, 0); err != ErrNotStored {
+		t.Fatalf("Add present: got %v want ErrNotStored", err)
+	}
+
+	var v testEntity
+	if err := c.Get(nil, "k", &v); err != nil || v.Name != "a" {
+		t.Fatalf("Get after Add: got %+v %v", v, err)
+	}
+
+	c.Set(nil, "short", &testEntity{Name: "a"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Add(nil, "short", &testEntity{Name: "b"}, 0); err != nil {
+		t.Fatalf("Add over expired entry: %v", err)
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(nil, "Thing", "b", &testEntity{Name: "b", N: 2})
+	s.Put(nil, "Thing", "a", &testEntity{Name: "a", N: 1})
+	s.Put(nil, "Thing", "c", &testEntity{Name: "c", N: 3})
+	s.Delete(nil, "Thing", "c")
+
+	// a new store reads back what the first one wrote
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v testEntity
+	if err := s.Get(nil, "Thing", "c", &v); err != ErrNoSuchEntity {
+		t.Fatalf("Get deleted: got %v want ErrNoSuchEntity", err)
+	}
+
+	var all []testEntity
+	keys, err := s.GetAll(nil, "Thing", &all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" || all[0].N != 1 || all[1].N != 2 {
+		t.Fatalf("GetAll: got %v %+v", keys, all)
+	}
+}
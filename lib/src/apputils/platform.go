@@ -0,0 +1,130 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Pluggable hosting environment services.  Everything in apputils that used
+// to call directly into the App Engine SDK goes through the current Platform,
+// so the same code can run under App Engine or as a plain net/http server.
+package apputils
+
+import(
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+var ErrNotStored = errors.New("cache entry already present")
+var ErrNoSuchEntity = errors.New("no such entity")
+
+// Per request context, application identity and hostname
+type Runtime interface {
+	Context(r *http.Request) context.Context
+	AppID(r *http.Request) string
+	Hostname(r *http.Request) string
+}
+
+type Logger interface {
+	Logf(r *http.Request, format string, v ...interface{})
+}
+
+// Outbound HTTP
+type Fetcher interface {
+	Client(r *http.Request) *http.Client
+}
+
+// Expiring key/value cache.  Values are JSON encoded.
+// Get returns ErrCacheMiss if the key isn't present.
+// Add only stores v if the key isn't present, and returns ErrNotStored if it is.
+type Cache interface {
+	Get(r *http.Request, key string, v interface{}) error
+	Set(r *http.Request, key string, v interface{}, expiration time.Duration) error
+	Add(r *http.Request, key string, v interface{}, expiration time.Duration) error
+	Delete(r *http.Request, key string) error
+}
+
+//
+// Persistent storage of entities grouped by kind and identified by a string key.
+// Get returns ErrNoSuchEntity if the key isn't present.
+// GetAll loads every entity of the given kind into dst, which must be a
+// pointer to a slice of structs or struct pointers, and returns the matching keys.
+//
+type Store interface {
+	Get(r *http.Request, kind string, key string, v interface{}) error
+	Put(r *http.Request, kind string, key string, v interface{}) error
+	Delete(r *http.Request, kind string, key string) error
+	GetAll(r *http.Request, kind string, dst interface{}) ([]string, error)
+}
+
+type Platform struct {
+	Name	string
+	Runtime	Runtime
+	Log	Logger
+	Fetch	Fetcher
+	Cache	Cache
+	Store	Store
+}
+
+// App Engine when built for it (the appengine build tag), otherwise standalone
+var platform = defaultPlatform()
+
+func SetPlatform(p *Platform) {
+	platform = p
+}
+
+func CurrentPlatform() *Platform {
+	return platform
+}
+
+func Context(r *http.Request) context.Context {
+	return platform.Runtime.Context(r)
+}
+
+func AppID(r *http.Request) string {
+	return platform.Runtime.AppID(r)
+}
+
+func DefaultVersionHostname(r *http.Request) string {
+	return platform.Runtime.Hostname(r)
+}
+
+func HTTPClient(r *http.Request) *http.Client {
+	return platform.Fetch.Client(r)
+}
+
+func Logf(r *http.Request, format string, v ...interface{}) {
+	platform.Log.Logf(r, format, v...)
+}
+
+func Log(r *http.Request, msg string) {
+	Logf(r, "%s", msg)
+}
+
+func LogHeaders(r *http.Request, msg string, headers http.Header) {
+	for header, value := range headers {
+		Logf(r, "%s Header: %v value %v\n", msg, header, value)
+	}
+}
+
+func LogRequestHeaders(r *http.Request, msg string) {
+	LogHeaders(r, msg, r.Header)
+}
+
+func (p *Platform) String() string {
+	return fmt.Sprintf("Platform{Name: %s}", p.Name)
+}
@@ -0,0 +1,242 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Shared secret authentication of HTTP requests between servers.
+//
+// Requests are signed with an HMAC over the method, path, a timestamp, a
+// random nonce and the body.  The verifier rejects stale timestamps and
+// nonces it has already seen.
+//
+// Keys are configured as "id:secret,id:secret,...".  The signer uses the
+// first key, the verifier accepts any of them, so a key is rotated by first
+// adding the new key to the verifiers, then moving it to the front on the
+// signers, and finally removing the old key everywhere.
+package apputils
+
+import(
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const(
+	AuthKeyIDHeader		= "X-Hunt-Key-Id"
+	AuthTimestampHeader	= "X-Hunt-Timestamp"
+	AuthNonceHeader		= "X-Hunt-Nonce"
+	AuthSignatureHeader	= "X-Hunt-Signature"
+)
+
+var ErrUnknownKey = errors.New("unknown key")
+var ErrReplay = errors.New("replayed request")
+
+type AuthKey struct {
+	ID	string
+	Secret	[]byte
+}
+
+type RequestSigner struct {
+	key	*AuthKey
+}
+
+type RequestVerifier struct {
+	keys	map[string]*AuthKey
+
+	lock	sync.Mutex
+	nonces	map[string]time.Time	// nonce -> when it can be forgotten
+}
+
+func ParseAuthKeys(str string) ([]*AuthKey, error) {
+	var keys []*AuthKey
+
+	for n, s := range strings.Split(str, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		i := strings.Index(s, ":")
+		if i < 1 || i == len(s)-1 {
+			return nil, fmt.Errorf("bad key #%d: expected id:secret", n+1)
+		}
+
+		key := &AuthKey{
+			ID:	s[:i],
+			Secret:	[]byte(s[i+1:]),
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// returns nil if no keys are configured
+func NewRequestSigner(keystr string) (*RequestSigner, error) {
+	keys, err := ParseAuthKeys(keystr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return &RequestSigner{key: keys[0]}, nil
+}
+
+// returns nil if no keys are configured
+func NewRequestVerifier(keystr string) (*RequestVerifier, error) {
+	keys, err := ParseAuthKeys(keystr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	v := &RequestVerifier{
+		keys:	make(map[string]*AuthKey),
+		nonces:	make(map[string]time.Time),
+	}
+
+	for _, key := range keys {
+		v.keys[key.ID] = key
+	}
+
+	return v, nil
+}
+
+func requestSignature(secret []byte, method string, path string, timestamp string, nonce string, body []byte) string {
+	return Sign(secret, method + "\n" + path + "\n" + timestamp + "\n" + nonce, body)
+}
+
+func (s *RequestSigner) Sign(req *http.Request, body []byte) error {
+	nb := make([]byte, 16)
+	_, err := rand.Read(nb)
+	if err != nil {
+		return err
+	}
+
+	nonce := hex.EncodeToString(nb)
+	timestamp := SignatureTimestamp(time.Now())
+
+	req.Header.Set(AuthKeyIDHeader, s.key.ID)
+	req.Header.Set(AuthTimestampHeader, timestamp)
+	req.Header.Set(AuthNonceHeader, nonce)
+	req.Header.Set(AuthSignatureHeader, requestSignature(s.key.Secret, req.Method, req.URL.Path, timestamp, nonce, body))
+
+	return nil
+}
+
+// must be called with the lock held
+func (v *RequestVerifier) prune(now time.Time) {
+	for nonce, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, nonce)
+		}
+	}
+}
+
+//
+// Checks the signature on r.  The headers are checked before the body is
+// read, and the body can't be larger than MaxSignedBodySize.  The body is
+// consumed and replaced, so that it can still be read by whatever handles
+// the request.
+//
+func (v *RequestVerifier) Verify(r *http.Request) error {
+	key, found := v.keys[r.Header.Get(AuthKeyIDHeader)]
+	if !found {
+		return ErrUnknownKey
+	}
+
+	timestamp := r.Header.Get(AuthTimestampHeader)
+	nonce := r.Header.Get(AuthNonceHeader)
+	if nonce == "" {
+		return ErrBadSignature
+	}
+
+	now := time.Now()
+	err := CheckSignatureTimestamp(timestamp, now)
+	if err != nil {
+		return err
+	}
+
+	if v.seen(nonce, now) {
+		return ErrReplay
+	}
+
+	body, err := readSignedBody(r)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	signature := r.Header.Get(AuthSignatureHeader)
+	expected := requestSignature(key.Secret, r.Method, r.URL.Path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// a request with the same nonce may have been verified while the body was read
+	_, seen := v.nonces[nonce]
+	if seen {
+		return ErrReplay
+	}
+	v.nonces[nonce] = now.Add(2 * SignatureMaxSkew)
+
+	return nil
+}
+
+// whether nonce has already been used, forgetting ones which have expired
+func (v *RequestVerifier) seen(nonce string, now time.Time) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.prune(now)
+
+	_, seen := v.nonces[nonce]
+
+	return seen
+}
+
+// Wraps handler so that only requests with a valid signature reach it
+func (v *RequestVerifier) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := v.Verify(r)
+		if err == ErrBodyTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
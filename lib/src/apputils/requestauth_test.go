@@ -0,0 +1,226 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedRequest(t *testing.T, keys string, body string) *http.Request {
+	signer, err := NewRequestSigner(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("POST", "http://game/jsonrpc", bytes.NewReader([]byte(body)))
+	err = signer.Sign(r, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func newVerifier(t *testing.T, keys string) *RequestVerifier {
+	v, err := NewRequestVerifier(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestRequestVerifierAccepts(t *testing.T) {
+	v := newVerifier(t, "old:old-secret,new:new-secret")
+
+	for _, keys := range []string{"old:old-secret", "new:new-secret,old:old-secret"} {
+		r := signedRequest(t, keys, `{"method":"x"}`)
+		if err := v.Verify(r); err != nil {
+			t.Fatalf("signed with %s: %v", keys, err)
+		}
+
+		// the body is still there for the handler
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"method":"x"}` {
+			t.Fatalf("body after Verify: got %q", body)
+		}
+	}
+}
+
+func TestRequestVerifierReplay(t *testing.T) {
+	v := newVerifier(t, "k:secret")
+
+	r := signedRequest(t, "k:secret", "body")
+	if err := v.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, _ := http.NewRequest("POST", "http://game/jsonrpc", bytes.NewReader([]byte("body")))
+	replay.Header = r.Header
+	if err := v.Verify(replay); err != ErrReplay {
+		t.Fatalf("replayed nonce: got %v, want ErrReplay", err)
+	}
+}
+
+func TestRequestVerifierExpired(t *testing.T) {
+	v := newVerifier(t, "k:secret")
+
+	r := signedRequest(t, "k:secret", "body")
+
+	// re-sign with a timestamp from before the allowed skew
+	timestamp := SignatureTimestamp(time.Now().Add(-SignatureMaxSkew - time.Minute))
+	nonce := r.Header.Get(AuthNonceHeader)
+	r.Header.Set(AuthTimestampHeader, timestamp)
+	r.Header.Set(AuthSignatureHeader, requestSignature([]byte("secret"), "POST", "/jsonrpc", timestamp, nonce, []byte("body")))
+
+	if err := v.Verify(r); err != ErrStaleSignature {
+		t.Fatalf("expired timestamp: got %v, want ErrStaleSignature", err)
+	}
+}
+
+func TestRequestVerifierWrongKey(t *testing.T) {
+	v := newVerifier(t, "k:secret")
+
+	if err := v.Verify(signedRequest(t, "k:not-the-secret", "body")); err != ErrBadSignature {
+		t.Fatalf("wrong secret: got %v, want ErrBadSignature", err)
+	}
+	if err := v.Verify(signedRequest(t, "other:secret", "body")); err != ErrUnknownKey {
+		t.Fatalf("unknown key id: got %v, want ErrUnknownKey", err)
+	}
+
+	// a signature doesn't carry over to a different body
+	r := signedRequest(t, "k:secret", "body")
+	r.Body = ioutil.NopCloser(bytes.NewReader([]byte("tampered")))
+	if err := v.Verify(r); err != ErrBadSignature {
+		t.Fatalf("tampered body: got %v, want ErrBadSignature", err)
+	}
+}
+
+// a body which notes whether it was read
+type watchedBody struct {
+	read	bool
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return 0, nil
+}
+
+func (b *watchedBody) Close() error {
+	return nil
+}
+
+func TestRequestVerifierChecksHeadersFirst(t *testing.T) {
+	v := newVerifier(t, "k:secret")
+
+	used := signedRequest(t, "k:secret", "body")
+	if err := v.Verify(used); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := signedRequest(t, "k:secret", "body")
+	stale.Header.Set(AuthTimestampHeader, SignatureTimestamp(time.Now().Add(-SignatureMaxSkew - time.Minute)))
+
+	replay := signedRequest(t, "k:secret", "body")
+	replay.Header = used.Header
+
+	tests := []struct {
+		name	string
+		r	*http.Request
+		err	error
+	}{
+		{"unknown key",	signedRequest(t, "other:secret", "body"),	ErrUnknownKey},
+		{"stale",	stale,						ErrStaleSignature},
+		{"replay",	replay,						ErrReplay},
+	}
+
+	for _, test := range tests {
+		body := &watchedBody{}
+		test.r.Body = body
+
+		if err := v.Verify(test.r); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		if body.read {
+			t.Errorf("%s: body read before the headers were rejected", test.name)
+		}
+	}
+}
+
+func TestRequestVerifierBodyTooLarge(t *testing.T) {
+	v := newVerifier(t, "k:secret")
+
+	if err := v.Verify(signedRequest(t, "k:secret", strings.Repeat("x", MaxSignedBodySize))); err != nil {
+		t.Fatalf("largest body: %v", err)
+	}
+
+	large := strings.Repeat("x", MaxSignedBodySize + 1)
+	if err := v.Verify(signedRequest(t, "k:secret", large)); err != ErrBodyTooLarge {
+		t.Fatalf("got %v, want ErrBodyTooLarge", err)
+	}
+
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for a body that's too large")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest(t, "k:secret", large))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestParseAuthKeys(t *testing.T) {
+	keys, err := ParseAuthKeys(" a:1 , b:2,")
+	if err != nil || len(keys) != 2 || keys[0].ID != "a" || string(keys[1].Secret) != "2" {
+		t.Fatalf("got %v %v", keys, err)
+	}
+
+	for _, bad := range []string{"nocolon", ":secret", "id:"} {
+		if _, err := ParseAuthKeys(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+
+	if v, _ := NewRequestVerifier(""); v != nil {
+		t.Errorf("verifier without keys isn't nil")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	timestamp := SignatureTimestamp(now)
+	signature := Sign([]byte("secret"), timestamp, []byte("body"))
+
+	if err := VerifySignature([]byte("secret"), timestamp, []byte("body"), signature, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature([]byte("other"), timestamp, []byte("body"), signature, now); err != ErrBadSignature {
+		t.Errorf("wrong secret: got %v", err)
+	}
+	if err := VerifySignature([]byte("secret"), timestamp, []byte("body"), signature, now.Add(SignatureMaxSkew + time.Minute)); err != ErrStaleSignature {
+		t.Errorf("stale: got %v", err)
+	}
+	if err := VerifySignature([]byte("secret"), "garbage", []byte("body"), signature, now); err != ErrBadSignature {
+		t.Errorf("bad timestamp: got %v", err)
+	}
+}
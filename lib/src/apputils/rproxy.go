@@ -18,6 +18,7 @@
 package apputils
 
 import(
+	"context"
 	"errors"
 	"fmt"
 	"bytes"
@@ -26,37 +27,9 @@ import(
 	"strings"
 	"net/http"
 	"net/url"
-
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/urlfetch"
-	"google.golang.org/appengine/log"
-
-	"golang.org/x/net/context"
+	"time"
 )
 
-func DefaultVersionHostname(r *http.Request) string {
-	ctx := appengine.NewContext(r)
-	hostname := appengine.DefaultVersionHostname(ctx)
-	return hostname
-}
-
-
-func Log(r *http.Request, msg string) {
-	ctx := appengine.NewContext(r)
-	log.Errorf(ctx, "%s", msg)
-}
-
-func LogHeaders(ctx context.Context, msg string, headers http.Header) {
-	for header, value := range headers {
-		log.Errorf(ctx, "%s Header: %v value %v\n", msg, header, value)
-	}
-}
-
-func LogRequestHeaders(r *http.Request, msg string) {
-	ctx := appengine.NewContext(r)
-	LogHeaders(ctx, msg, r.Header)
-}
-
 func CopyHeader(dst http.Header, src http.Header, key string) {
 	vv, found := src[key]
 	if !found {
@@ -70,6 +43,16 @@ func CopyHeader(dst http.Header, src http.Header, key string) {
 
 var ErrRedirect = errors.New("redirect foiled")
 
+// Returned by RProxy when the server answered with a status other than 200
+type HttpStatusError struct {
+	StatusCode	int
+	Body		string
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("HTTP Error %d: %s", e.StatusCode, e.Body)
+}
+
 const (
 	RPROXY_LOG_REQUEST_HEADERS	= 1 << 0
 	RPROXY_LOG_RESPONSE_HEADERS	= 1 << 1
@@ -99,6 +82,18 @@ type RProxyConfig struct {
 	Copy	[]string
 	Add	[]*Header
 	Options	uint64
+	Signer	*RequestSigner	// if non-nil, sign the outbound request
+
+	// if non-nil, used instead of the platform's transport, e.g. for
+	// custom TLS settings.  Only meaningful where the platform allows
+	// direct connections, i.e. not on App Engine classic.
+	Transport	http.RoundTripper
+
+	// if non-zero, limits the time the whole request may take
+	Timeout		time.Duration
+
+	// if non-nil, the request is abandoned once it's done
+	Context		context.Context
 }
 
 func RProxyOptions(str string) uint64 {
@@ -120,13 +115,18 @@ func RProxyOptions(str string) uint64 {
 }
 
 func RProxy(r *http.Request, cfg *RProxyConfig, method string, u *url.URL, data []byte) ([]byte, error) {
-	ctx := appengine.NewContext(r)
-	client := urlfetch.Client(ctx)
+	client := HTTPClient(r)
+	if cfg.Transport != nil {
+		client.Transport = cfg.Transport
+	}
+	if cfg.Timeout != 0 {
+		client.Timeout = cfg.Timeout
+	}
 	var buf io.Reader
 	var httpRsp *http.Response
 
 	if (cfg.Options & RPROXY_LOG_REQUEST_HEADERS) != 0 {
-		LogHeaders(ctx, "Get request", r.Header)
+		LogHeaders(r, "Get request", r.Header)
 	}
 
 	if data != nil {
@@ -137,6 +137,9 @@ func RProxy(r *http.Request, cfg *RProxyConfig, method string, u *url.URL, data
 	if err != nil {
 		goto fail
 	}
+	if cfg.Context != nil {
+		httpReq = httpReq.WithContext(cfg.Context)
+	}
 
 	for _, h := range cfg.Copy {
 		CopyHeader(httpReq.Header, r.Header, h)
@@ -145,12 +148,19 @@ func RProxy(r *http.Request, cfg *RProxyConfig, method string, u *url.URL, data
 		httpReq.Header.Set(h.Key, h.Value)
 	}
 
+	if cfg.Signer != nil {
+		err = cfg.Signer.Sign(httpReq, data)
+		if err != nil {
+			goto fail
+		}
+	}
+
 	if (cfg.Options & RPROXY_LOG_PROXY_REQUEST) != 0 {
-		log.Errorf(ctx, "Proxy Request method %s url %s data %v\n", method, u, data)
+		Logf(r, "Proxy Request method %s url %s data %v\n", method, u, data)
 	}
 
 	if (cfg.Options & RPROXY_LOG_PROXY_HEADERS) != 0 {
-		LogHeaders(ctx, "game server request", httpReq.Header)
+		LogHeaders(r, "game server request", httpReq.Header)
 	}
 
 	if (cfg.Options & RPROXY_DISABLE_REDIRECT) != 0 {
@@ -174,7 +184,7 @@ func RProxy(r *http.Request, cfg *RProxyConfig, method string, u *url.URL, data
 	}
 
 	if (cfg.Options & RPROXY_LOG_RESPONSE_HEADERS) != 0 {
-		LogHeaders(ctx, "Response Headers", httpRsp.Header)
+		LogHeaders(r, "Response Headers", httpRsp.Header)
 	}
 
 	switch httpRsp.StatusCode {
@@ -186,18 +196,18 @@ func RProxy(r *http.Request, cfg *RProxyConfig, method string, u *url.URL, data
 		}
 		fallthrough
 	default:
-		err = fmt.Errorf("HTTP Error %d: %s", httpRsp.StatusCode, string(data))
+		err = &HttpStatusError{StatusCode: httpRsp.StatusCode, Body: string(data)}
 		goto fail
 	}
 
 	if (cfg.Options & RPROXY_LOG_SUCCESS) != 0 {
-		log.Errorf(ctx, "%s", string(data))
+		Logf(r, "%s", string(data))
 	}
 	return data, nil
 
 fail:
 	if (cfg.Options & RPROXY_LOG_ERROR) != 0 {
-		log.Errorf(ctx, "rproxy %s err '%v'", u, err)
+		Logf(r, "rproxy %s err '%v'", u, err)
 	}
 	return nil, err
 }
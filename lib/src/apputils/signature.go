@@ -0,0 +1,95 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// HMAC signatures over a timestamp and a request body, used to authenticate
+// requests between servers which share a secret.
+package apputils
+
+import(
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const(
+	SignatureMaxSkew	= 5 * time.Minute
+	MaxSignedBodySize	= 64 << 10	// larger than any game server RPC or keepalive
+)
+
+var ErrBadSignature = errors.New("bad signature")
+var ErrStaleSignature = errors.New("stale signature")
+var ErrBodyTooLarge = errors.New("body too large")
+
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{0})
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func SignatureTimestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// checks timestamp is within SignatureMaxSkew of now
+func CheckSignatureTimestamp(timestamp string, now time.Time) error {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	skew := now.Sub(time.Unix(secs, 0))
+	if skew > SignatureMaxSkew || skew < -SignatureMaxSkew {
+		return ErrStaleSignature
+	}
+
+	return nil
+}
+
+// checks the signature, and that timestamp is within SignatureMaxSkew of now
+func VerifySignature(secret []byte, timestamp string, body []byte, signature string, now time.Time) error {
+	err := CheckSignatureTimestamp(timestamp, now)
+	if err != nil {
+		return err
+	}
+
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// reads the body of a signed request, which can't be larger than MaxSignedBodySize
+func readSignedBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxSignedBodySize))
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return nil, ErrBodyTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Per-instance rules for which huntd team a joining player ends up on.
+package apputils
+
+import(
+	"fmt"
+	"strconv"
+)
+
+const(
+	TEAM_AUTO	= "auto"	// let the game server pick the smallest team
+	TEAM_NONE	= " "		// huntd's representation of no team
+)
+
+const(
+	NO_TEAM_ALLOW	= "allow"	// players may join without a team (free-for-all)
+	NO_TEAM_AUTO	= "auto"	// players without a team are assigned one, as if they asked for TEAM_AUTO
+	NO_TEAM_REJECT	= "reject"	// players without a team are turned away
+)
+
+type TeamPolicy struct {
+	Teams		int	// TEAM_AUTO chooses among teams "0" .. Teams-1
+	MaxTeamSize	int	// 0 for no limit
+	NoTeam		string	// one of the NO_TEAM_* consts
+}
+
+func NewTeamPolicy(teams int, maxTeamSize int, noTeam string) (*TeamPolicy, error) {
+	if teams < 1 || teams > 10 {
+		return nil, fmt.Errorf("teams must be 1 .. 10, got %d", teams)
+	}
+
+	if maxTeamSize < 0 {
+		return nil, fmt.Errorf("bad max team size %d", maxTeamSize)
+	}
+
+	switch noTeam {
+	case NO_TEAM_ALLOW, NO_TEAM_AUTO, NO_TEAM_REJECT:
+	default:
+		return nil, fmt.Errorf("unknown no-team policy '%s'", noTeam)
+	}
+
+	policy := &TeamPolicy{
+		Teams:		teams,
+		MaxTeamSize:	maxTeamSize,
+		NoTeam:		noTeam,
+	}
+
+	return policy, nil
+}
+
+func (policy *TeamPolicy) full(count int) bool {
+	return policy.MaxTeamSize > 0 && count >= policy.MaxTeamSize
+}
+
+// whether team is one of 0 .. Teams-1, any team will do if Teams isn't set
+func (policy *TeamPolicy) valid(team string) bool {
+	if policy.Teams <= 0 {
+		return true
+	}
+
+	n, err := strconv.Atoi(team)
+
+	return err == nil && n >= 0 && n < policy.Teams
+}
+
+//
+// Resolves the team a player asked for into a huntd team ("0" .. "9" or TEAM_NONE).
+// counts holds the number of players currently on each team, keyed by "0" .. "9" or "none".
+//
+func (policy *TeamPolicy) Assign(requested string, counts map[string]int) (string, error) {
+	if requested == TEAM_NONE {
+		switch policy.NoTeam {
+		case NO_TEAM_ALLOW:
+			return TEAM_NONE, nil
+		case NO_TEAM_REJECT:
+			return "", fmt.Errorf("this game requires a team")
+		}
+		requested = TEAM_AUTO
+	}
+
+	if requested != TEAM_AUTO {
+		if !policy.valid(requested) {
+			return "", fmt.Errorf("no team %s, this game has teams 0 .. %d", requested, policy.Teams - 1)
+		}
+		if policy.full(counts[requested]) {
+			return "", fmt.Errorf("team %s is full", requested)
+		}
+		return requested, nil
+	}
+
+	best := ""
+	for i := 0; i < policy.Teams; i++ {
+		team := strconv.Itoa(i)
+		if policy.full(counts[team]) {
+			continue
+		}
+		if best == "" || counts[team] < counts[best] {
+			best = team
+		}
+	}
+
+	if best == "" {
+		return "", fmt.Errorf("all teams are full")
+	}
+
+	return best, nil
+}
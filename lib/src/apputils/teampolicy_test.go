@@ -0,0 +1,81 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"testing"
+)
+
+func TestTeamPolicyAssign(t *testing.T) {
+	counts := map[string]int{"0": 3, "1": 1, "2": 2, "none": 4}
+
+	tests := []struct {
+		teams		int
+		maxTeamSize	int
+		noTeam		string
+		requested	string
+		want		string	// "" for an error
+	}{
+		{3, 0, NO_TEAM_ALLOW, TEAM_AUTO, "1"},
+		{3, 0, NO_TEAM_ALLOW, TEAM_NONE, TEAM_NONE},
+		{3, 0, NO_TEAM_AUTO, TEAM_NONE, "1"},
+		{3, 0, NO_TEAM_REJECT, TEAM_NONE, ""},
+		{3, 0, NO_TEAM_ALLOW, "0", "0"},
+		{3, 3, NO_TEAM_ALLOW, "0", ""},			// full
+		{3, 1, NO_TEAM_ALLOW, TEAM_AUTO, ""},		// every team full
+		{4, 0, NO_TEAM_ALLOW, TEAM_AUTO, "3"},		// an empty team wins
+		{2, 2, NO_TEAM_ALLOW, TEAM_AUTO, "1"},
+		{3, 0, NO_TEAM_ALLOW, "2", "2"},
+		{3, 0, NO_TEAM_ALLOW, "3", ""},			// no such team
+		{3, 0, NO_TEAM_ALLOW, "9", ""},
+		{3, 0, NO_TEAM_ALLOW, "-1", ""},
+		{3, 0, NO_TEAM_ALLOW, "x", ""},
+	}
+
+	for _, test := range tests {
+		policy, err := NewTeamPolicy(test.teams, test.maxTeamSize, test.noTeam)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := policy.Assign(test.requested, counts)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%+v: got team '%s', want an error", test, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%+v: got '%s' %v, want '%s'", test, got, err, test.want)
+		}
+	}
+}
+
+func TestNewTeamPolicyValidates(t *testing.T) {
+	if _, err := NewTeamPolicy(0, 0, NO_TEAM_ALLOW); err == nil {
+		t.Errorf("0 teams accepted")
+	}
+	if _, err := NewTeamPolicy(11, 0, NO_TEAM_ALLOW); err == nil {
+		t.Errorf("11 teams accepted")
+	}
+	if _, err := NewTeamPolicy(2, -1, NO_TEAM_ALLOW); err == nil {
+		t.Errorf("negative team size accepted")
+	}
+	if _, err := NewTeamPolicy(2, 0, "maybe"); err == nil {
+		t.Errorf("unknown no-team policy accepted")
+	}
+}
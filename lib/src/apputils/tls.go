@@ -0,0 +1,107 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// TLS configuration for talking between servers, optionally with client
+// certificates (mutual TLS).  All files are PEM encoded.
+package apputils
+
+import(
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+
+	return pool, nil
+}
+
+//
+// Returns the config for a server presenting certFile/keyFile.  If
+// clientCAFile is set, clients must present a certificate signed by one of
+// the CAs in it.  Returns nil if certFile is empty, i.e. TLS isn't configured.
+//
+func NewServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("client certificates require a server certificate")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates:	[]tls.Certificate{cert},
+		MinVersion:	tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		cfg.ClientCAs, err = loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+//
+// Returns the config for a client which verifies servers against the CAs in
+// caFile (the system roots if empty), and presents certFile/keyFile if set.
+// Returns nil if nothing is set, i.e. the defaults are fine.
+//
+func NewClientTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:	tls.VersionTLS12,
+	}
+
+	var err error
+	if caFile != "" {
+		cfg.RootCAs, err = loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package apputils
+
+import(
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert		*x509.Certificate
+	key		*ecdsa.PrivateKey
+	certFile	string
+	keyFile		string
+}
+
+// signs a certificate for name with parent, or self-signs a CA if parent is nil
+func newTestCert(t *testing.T, dir string, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:	big.NewInt(time.Now().UnixNano()),
+		Subject:	pkix.Name{CommonName: name},
+		NotBefore:	time.Now().Add(-time.Hour),
+		NotAfter:	time.Now().Add(time.Hour),
+		KeyUsage:	x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:	[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:	[]net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:		cert,
+		key:		key,
+		certFile:	filepath.Join(dir, name + ".crt"),
+		keyFile:	filepath.Join(dir, name + ".key"),
+	}
+	ioutil.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return tc
+}
+
+func TestTLSConfigUnset(t *testing.T) {
+	cfg, err := NewServerTLSConfig("", "", "")
+	if cfg != nil || err != nil {
+		t.Errorf("server without a certificate: got %v, %v", cfg, err)
+	}
+
+	cfg, err = NewClientTLSConfig("", "", "")
+	if cfg != nil || err != nil {
+		t.Errorf("client without anything set: got %v, %v", cfg, err)
+	}
+
+	_, err = NewServerTLSConfig("", "", "ca.crt")
+	if err == nil {
+		t.Errorf("client CA without a server certificate accepted")
+	}
+
+	dir := t.TempDir()
+	_, err = NewClientTLSConfig(filepath.Join(dir, "missing.crt"), "", "")
+	if err == nil {
+		t.Errorf("missing CA file accepted")
+	}
+
+	notPEM := filepath.Join(dir, "empty.crt")
+	ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	_, err = NewClientTLSConfig(notPEM, "", "")
+	if err == nil {
+		t.Errorf("CA file without certificates accepted")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	client := newTestCert(t, dir, "client", ca)
+	other := newTestCert(t, dir, "other", nil)
+
+	serverCfg, err := NewServerTLSConfig(server.certFile, server.keyFile, ca.certFile)
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = serverCfg
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name		string
+		caFile		string
+		cert		*testCert
+		ok		bool
+	}{
+		{"client certificate",		ca.certFile,	client,	true},
+		{"no client certificate",	ca.certFile,	nil,	false},
+		{"unknown client CA",		ca.certFile,	other,	false},
+		{"unknown server CA",		other.certFile,	client,	false},
+	}
+
+	for _, test := range tests {
+		certFile, keyFile := "", ""
+		if test.cert != nil {
+			certFile, keyFile = test.cert.certFile, test.cert.keyFile
+		}
+
+		clientCfg, err := NewClientTLSConfig(test.caFile, certFile, keyFile)
+		if err != nil {
+			t.Fatalf("%s: NewClientTLSConfig: %v", test.name, err)
+		}
+
+		hc := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		resp, err := hc.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// A circuit breaker for calls to one game server.  After Threshold calls in a
+// row fail to reach the server, the circuit opens and calls fail immediately
+// with ErrCircuitOpen.  Once Cooldown has passed a single trial call is let
+// through: if it reaches the server the circuit closes again, otherwise it
+// stays open for another Cooldown.
+package gamerpc
+
+import(
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const(
+	DefaultBreakerThreshold	= 3
+	DefaultBreakerCooldown	= 30 * time.Second
+)
+
+var ErrCircuitOpen = errors.New("game server unavailable")
+
+type Breaker struct {
+	Threshold	int		// consecutive failures which open the circuit
+	Cooldown	time.Duration	// how long the circuit stays open before a trial call
+
+	//
+	// If non-nil, called whenever the circuit opens, stays open after a
+	// failed trial call, or closes.  until is when the next trial call will
+	// be let through, the zero time when closing.
+	// r is the request whose call changed the circuit.
+	//
+	OnChange	func(r *http.Request, open bool, until time.Time)
+
+	lock		sync.Mutex
+	failures	int
+	open		bool
+	openUntil	time.Time
+	trial		bool	// a trial call is in flight
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Threshold:	threshold,
+		Cooldown:	cooldown,
+	}
+}
+
+//
+// Returns ErrCircuitOpen if the call shouldn't be made.  Otherwise the
+// caller must report how the call went with Done, passing on trial, which
+// says whether the call is the circuit's trial call.
+//
+func (b *Breaker) Allow() (trial bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.open {
+		return false, nil
+	}
+
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false, ErrCircuitOpen
+	}
+
+	b.trial = true
+
+	return true, nil
+}
+
+//
+// Records the result of a call let through by Allow, with the trial flag
+// Allow returned for it.  Calls let through before the circuit opened can
+// finish during a trial call, and mustn't end it.  err should only be
+// non-nil if the server couldn't be reached, see Unreachable; errors
+// returned by a server which answered don't count against it.
+//
+func (b *Breaker) Done(r *http.Request, trial bool, err error) {
+	b.lock.Lock()
+
+	wasOpen := b.open
+	if trial {
+		b.trial = false
+	}
+
+	if err == nil {
+		b.failures = 0
+		b.open = false
+		b.openUntil = time.Time{}
+	} else {
+		b.failures++
+		if b.open || b.failures >= b.Threshold {
+			b.open = true
+			b.openUntil = time.Now().Add(b.Cooldown)
+		}
+	}
+
+	open := b.open
+	until := b.openUntil
+	onChange := b.OnChange
+
+	b.lock.Unlock()
+
+	// a failed trial call extends how long the circuit is open
+	if onChange != nil && (open != wasOpen || (open && trial)) {
+		onChange(r, open, until)
+	}
+}
+
+// whether calls are currently failing fast
+func (b *Breaker) Open() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.open
+}
@@ -0,0 +1,212 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package gamerpc
+
+import(
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errUnreachable = errors.New("connection refused")
+
+type breakerChange struct {
+	open	bool
+	until	time.Time
+}
+
+func testBreaker(cooldown time.Duration) (*Breaker, *[]breakerChange) {
+	var changes []breakerChange
+
+	b := NewBreaker(3, cooldown)
+	b.OnChange = func(r *http.Request, open bool, until time.Time) {
+		changes = append(changes, breakerChange{open, until})
+	}
+
+	return b, &changes
+}
+
+// makes a call through the breaker which ends with err, if it is allowed
+func call(b *Breaker, err error) error {
+	trial, allowErr := b.Allow()
+	if allowErr != nil {
+		return allowErr
+	}
+
+	b.Done(nil, trial, err)
+
+	return nil
+}
+
+func TestBreakerThreshold(t *testing.T) {
+	b, changes := testBreaker(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if err := call(b, errUnreachable); err != nil {
+			t.Fatalf("failure %d: %v", i+1, err)
+		}
+	}
+
+	// a success resets the count
+	call(b, nil)
+	call(b, errUnreachable)
+	call(b, errUnreachable)
+	if b.Open() || len(*changes) != 0 {
+		t.Fatalf("open after 2 failures in a row: open %v changes %v", b.Open(), *changes)
+	}
+
+	call(b, errUnreachable)
+	if !b.Open() {
+		t.Fatalf("still closed after 3 failures in a row")
+	}
+	if len(*changes) != 1 || !(*changes)[0].open || (*changes)[0].until.IsZero() {
+		t.Fatalf("changes = %v, want one open", *changes)
+	}
+
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Errorf("Allow while open: got %v, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestBreakerCooldown(t *testing.T) {
+	cooldown := 50 * time.Millisecond
+	b, changes := testBreaker(cooldown)
+
+	opened := time.Now()
+	for i := 0; i < 3; i++ {
+		call(b, errUnreachable)
+	}
+
+	until := (*changes)[0].until
+	if until.Before(opened.Add(cooldown)) || until.After(time.Now().Add(cooldown)) {
+		t.Errorf("open until %v, want %v after opening", until, cooldown)
+	}
+
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("Allow during cooldown: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	time.Sleep(time.Until(until) + 10 * time.Millisecond)
+
+	trial, err := b.Allow()
+	if err != nil || !trial {
+		t.Fatalf("Allow after cooldown: trial %v %v", trial, err)
+	}
+	b.Done(nil, trial, nil)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	cooldown := 30 * time.Millisecond
+	b, changes := testBreaker(cooldown)
+
+	for i := 0; i < 3; i++ {
+		call(b, errUnreachable)
+	}
+	time.Sleep(cooldown + 10 * time.Millisecond)
+
+	// only one trial call at a time
+	trial, err := b.Allow()
+	if err != nil || !trial {
+		t.Fatalf("trial call: trial %v %v", trial, err)
+	}
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("second call during the trial: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// a failed trial keeps it open for another cooldown
+	b.Done(nil, trial, errUnreachable)
+	if !b.Open() {
+		t.Fatalf("closed after a failed trial")
+	}
+	if len(*changes) != 2 || !(*changes)[1].open || !(*changes)[1].until.After((*changes)[0].until) {
+		t.Fatalf("changes = %v, want open then open again later", *changes)
+	}
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("Allow right after a failed trial: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// a successful trial closes it
+	time.Sleep(cooldown + 10 * time.Millisecond)
+	if err := call(b, nil); err != nil {
+		t.Fatalf("second trial: %v", err)
+	}
+	if b.Open() {
+		t.Fatalf("still open after a successful trial")
+	}
+	if len(*changes) != 3 || (*changes)[2].open || !(*changes)[2].until.IsZero() {
+		t.Fatalf("changes = %v, want a final close", *changes)
+	}
+
+	// and failures count from zero again
+	call(b, errUnreachable)
+	call(b, errUnreachable)
+	if b.Open() {
+		t.Errorf("reopened before the threshold")
+	}
+}
+
+func TestBreakerStragglerDuringTrial(t *testing.T) {
+	cooldown := 30 * time.Millisecond
+	b, _ := testBreaker(cooldown)
+
+	// let through while the circuit was closed, and still running when it opens
+	straggler, err := b.Allow()
+	if err != nil || straggler {
+		t.Fatalf("call while closed: trial %v %v", straggler, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		call(b, errUnreachable)
+	}
+	time.Sleep(cooldown + 10 * time.Millisecond)
+
+	trial, err := b.Allow()
+	if err != nil || !trial {
+		t.Fatalf("trial call: trial %v %v", trial, err)
+	}
+
+	// the straggler finishing doesn't end the trial, so no second trial starts
+	b.Done(nil, straggler, errUnreachable)
+	time.Sleep(cooldown + 10 * time.Millisecond)
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("Allow after the straggler finished: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	b.Done(nil, trial, nil)
+	if b.Open() {
+		t.Fatalf("still open after a successful trial")
+	}
+}
+
+func TestBreakerOnChangeOnlyOnChanges(t *testing.T) {
+	b, changes := testBreaker(time.Hour)
+
+	for i := 0; i < 10; i++ {
+		call(b, nil)
+	}
+	for i := 0; i < 3; i++ {
+		call(b, errUnreachable)
+	}
+	for i := 0; i < 10; i++ {
+		call(b, errUnreachable)	// fail fast, no Done
+	}
+
+	if len(*changes) != 1 {
+		t.Errorf("changes = %v, want just the open", *changes)
+	}
+}
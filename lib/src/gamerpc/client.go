@@ -18,10 +18,15 @@
 package gamerpc
 
 import(
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"log"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"time"
 
 	"apputils"
 )
@@ -30,6 +35,11 @@ type GameClient struct {
 	URL		*url.URL
 	RpcType		int
 	RProxyOptions	uint64
+	Signer		*apputils.RequestSigner	`json:"-"`	// signs requests to game servers that require authentication
+	Transport	http.RoundTripper	`json:"-"`	// nil for the platform default, see SetTLSConfig
+	Timeout		time.Duration		`json:"-"`	// limits each request if non-zero
+	Context		context.Context		`json:"-"`	// if non-nil, requests are abandoned once it's done
+	Breaker		*Breaker		`json:"-"`	// if non-nil, fail fast while the server is unreachable
 }
 
 func NewGameClient(ustr string, rpcTypeStr string, rpOptions uint64) (*GameClient, error) {
@@ -63,16 +73,93 @@ func NewGameClient(ustr string, rpcTypeStr string, rpOptions uint64) (*GameClien
 	return gc, nil
 }
 
+//
+// Talk to the game server with the given TLS settings, e.g. to verify it
+// against a private CA or to present a client certificate.
+//
+func (gc *GameClient) SetTLSConfig(cfg *tls.Config) {
+	if cfg == nil {
+		gc.Transport = nil
+		return
+	}
+
+	gc.Transport = &http.Transport{
+		Proxy:			http.ProxyFromEnvironment,
+		TLSClientConfig:	cfg,
+		TLSHandshakeTimeout:	10 * time.Second,
+	}
+}
+
+// the RProxy settings to use for requests to the game server
+func (gc *GameClient) ProxyConfig() *apputils.RProxyConfig {
+	return &apputils.RProxyConfig{
+		Options:	gc.RProxyOptions,
+		Signer:		gc.Signer,
+		Transport:	gc.Transport,
+		Timeout:	gc.Timeout,
+		Context:	gc.Context,
+	}
+}
+
 func (gc *GameClient) rpc(r *http.Request, service string, method string, request interface{}, reply interface{}) error {
 	switch gc.RpcType {
 	case GR_NETRPC:
 		return fmt.Errorf("GR_NETRPC not supported")
 	case GR_JSONRPC:
-		return apputils.HttpJsonRpc(r, gc.URL, service + ".J" + method, gc.RProxyOptions, request, &reply)
+		if gc.Breaker == nil {
+			return apputils.HttpJsonRpc(r, gc.URL, service + ".J" + method, gc.ProxyConfig(), request, &reply)
+		}
+
+		trial, err := gc.Breaker.Allow()
+		if err != nil {
+			return err
+		}
+
+		err = apputils.HttpJsonRpc(r, gc.URL, service + ".J" + method, gc.ProxyConfig(), request, &reply)
+		if Unreachable(err) {
+			gc.Breaker.Done(r, trial, err)
+		} else {
+			gc.Breaker.Done(r, trial, nil)
+		}
+
+		return err
 	}
 	return fmt.Errorf("uhnandled rpctype %d", gc.RpcType)
 }
 
+//
+// Whether err means the game server couldn't be reached: the connection
+// couldn't be made or broke, or a proxy in front of it said it was down.
+// Errors the server answered with (bad signatures, failed methods), slow
+// answers and requests the caller abandoned, which callers like the stats
+// fan-out give up on early, don't say anything about whether it can be
+// reached.
+//
+func Unreachable(err error) bool {
+	switch e := err.(type) {
+	case nil, *apputils.JsonRpcError:
+		return false
+	case *apputils.HttpStatusError:
+		switch e.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	case *url.Error:
+		if e.Err == context.Canceled {
+			return false
+		}
+		op, ok := e.Err.(*net.OpError)
+		if ok && op.Op == "dial" {
+			return true
+		}
+		return !e.Timeout()
+	}
+
+	// not from the HTTP client, e.g. the request couldn't be signed
+	return false
+}
+
 func (gc *GameClient) Message(r *http.Request, req *MessageRequest) (*MessageReply, error) {
 	var reply MessageReply
 
@@ -117,6 +204,17 @@ func (gc *GameClient) GameData(r *http.Request, req *GameDataRequest) (*GameData
 	return &reply, err
 }
 
+func (gc *GameClient) Exchange(r *http.Request, req *ExchangeRequest) (*ExchangeReply, error) {
+	var reply ExchangeReply
+
+	err := gc.rpc(r, "HuntDaemon", "Exchange", req, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
 func (gc *GameClient) Input(r *http.Request, req *InputRequest) (*InputReply, error) {
 	var reply InputReply
 
@@ -149,3 +247,72 @@ func (gc *GameClient) Ping(r *http.Request, req *PingRequest) (*PingReply, error
 
 	return &reply, nil
 }
+
+func (gc *GameClient) ListPlayers(r *http.Request, req *PlayersRequest) (*PlayersReply, error) {
+	var reply PlayersReply
+
+	err := gc.rpc(r, "HuntDaemon", "ListPlayers", req, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+func (gc *GameClient) Kick(r *http.Request, req *KickRequest) (*KickReply, error) {
+	var reply KickReply
+
+	err := gc.rpc(r, "HuntDaemon", "Kick", req, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+func (gc *GameClient) Drain(r *http.Request, req *DrainRequest) (*DrainReply, error) {
+	var reply DrainReply
+
+	err := gc.rpc(r, "HuntDaemon", "Drain", req, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+func (gc *GameClient) Restart(r *http.Request, req *RestartRequest) (*RestartReply, error) {
+	var reply RestartReply
+
+	err := gc.rpc(r, "HuntDaemon", "Restart", req, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// Fetches the game server's /info
+func (gc *GameClient) Info(r *http.Request) (*ServerInfo, error) {
+	cfg := gc.ProxyConfig()
+	cfg.Add = []*apputils.Header{
+			&apputils.Header{Key: "REDACTED", Value: "REDACTED"},
+			&apputils.Header{Key: "Accept", Value: "application/json;charset=utf-8"},
+		}
+
+	u := *gc.URL
+	u.Path = "/info"
+
+	buf, err := apputils.RProxy(r, cfg, "GET", &u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info ServerInfo
+	err = json.Unmarshal(buf, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
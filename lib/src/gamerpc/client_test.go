@@ -0,0 +1,130 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package gamerpc
+
+import(
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"apputils"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestUnreachable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	dialTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}
+
+	tests := []struct {
+		name		string
+		err		error
+		unreachable	bool
+	}{
+		{"ok",			nil,									false},
+		{"method failed",	&apputils.JsonRpcError{Err: errors.New("no such player")},		false},
+		{"bad signature",	&apputils.HttpStatusError{StatusCode: 401, Body: "unknown key"},	false},
+		{"server error",	&apputils.HttpStatusError{StatusCode: 500},				false},
+		{"bad gateway",		&apputils.HttpStatusError{StatusCode: 502},				true},
+		{"unavailable",		&apputils.HttpStatusError{StatusCode: 503},				true},
+		{"gateway timeout",	&apputils.HttpStatusError{StatusCode: 504},				true},
+		{"refused",		&url.Error{Op: "Post", URL: "http://game", Err: refused},		true},
+		{"dial timeout",	&url.Error{Op: "Post", URL: "http://game", Err: dialTimeout},		true},
+		{"slow answer",		&url.Error{Op: "Post", URL: "http://game", Err: timeoutError{}},	false},
+		{"broken connection",	&url.Error{Op: "Post", URL: "http://game", Err: io.ErrUnexpectedEOF},	true},
+		{"abandoned",		&url.Error{Op: "Post", URL: "http://game", Err: context.Canceled},	false},
+		{"local",		errors.New("couldn't sign"),						false},
+	}
+
+	for _, test := range tests {
+		if got := Unreachable(test.err); got != test.unreachable {
+			t.Errorf("%s: Unreachable(%v) = %v, want %v", test.name, test.err, got, test.unreachable)
+		}
+	}
+}
+
+func testClient(t *testing.T, u string) *GameClient {
+	gc, err := NewGameClient(u, "jsonrpc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.Breaker = NewBreaker(2, time.Hour)
+
+	return gc
+}
+
+// pings the game server enough times to open the breaker if each counts
+func pings(gc *GameClient) {
+	r := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < gc.Breaker.Threshold; i++ {
+		gc.Ping(r, &PingRequest{})
+	}
+}
+
+func TestBreakerIgnoresAnswers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown key", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	gc := testClient(t, srv.URL + "/jsonrpc")
+	pings(gc)
+	if gc.Breaker.Open() {
+		t.Errorf("breaker opened on 401s")
+	}
+}
+
+func TestBreakerIgnoresSlowAnswers(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	gc := testClient(t, srv.URL + "/jsonrpc")
+	gc.Timeout = 20 * time.Millisecond
+	pings(gc)
+	if gc.Breaker.Open() {
+		t.Errorf("breaker opened on timeouts")
+	}
+}
+
+func TestBreakerOpensWhenUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	gc := testClient(t, "http://" + addr + "/jsonrpc")
+	pings(gc)
+	if !gc.Breaker.Open() {
+		t.Errorf("breaker still closed after failing to connect")
+	}
+}
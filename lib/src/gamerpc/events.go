@@ -17,6 +17,10 @@
 // TODO: High-level file comment.
 package gamerpc
 
+import(
+	"fmt"
+	"time"
+)
 
 const(
 	ServerVersion	= 0xFFFFFFFF
@@ -51,9 +55,9 @@ type QuitReply struct {
 }
 
 type JoinRequest struct {
-	Uid		uint32
+	Uid		uint32	// set by the frontend from the user's cookie, see server-frontend/user.go
 	Name		string
-	Team		string	// must be "0" .. "9" or " "
+	Team		string	// must be "0" .. "9", " " or "auto"
 	EnterStatus	uint32	// any of the Q_* consts
 	Ttyname		string
 	ConnectMode	uint32	// must be C_MESSAGE, C_PLAYER, or C_MONITOR
@@ -95,9 +99,18 @@ type MessageReply struct {
 	Token	int
 }
 
+//
+// Waits up to Wait milliseconds for at least MinBytes of game data, returning
+// whatever has arrived by then.  Wait 0 means DefaultGameDataWait, MinBytes 0
+// means 1.  Timeout is set in the reply if no game data arrived at all.
+// Encoding selects how the game data is returned, see GAMEDATA_*.
+//
 type GameDataRequest struct {
 	Token		int
 	PlayerID	string
+	Wait		int64
+	MinBytes	int
+	Encoding	string
 }
 
 type GameDataReply struct {
@@ -105,18 +118,222 @@ type GameDataReply struct {
 	Timeout		bool
 	TimeoutError	string
 	Data		[]uint32
+	Bytes		[]byte	`json:",omitempty"`
+}
+
+// GameDataRequest.Encoding and ExchangeRequest.Encoding
+const(
+	GAMEDATA_UINT32	= ""		// in Data, one JSON number per byte
+	GAMEDATA_BYTES	= "bytes"	// in Bytes, which JSON encodes as base64
+)
+
+const(
+	DefaultGameDataWait	= 1000			// milliseconds
+	MaxGameDataWait		= MaxExchangeWait
+	MaxGameDataBytes	= 2048			// the most game data returned at once
+)
+
+func (req *GameDataRequest) Validate() error {
+	if req.PlayerID == "" {
+		return fmt.Errorf("missing PlayerID")
+	}
+	if req.Wait < 0 || req.Wait > MaxGameDataWait {
+		return fmt.Errorf("Wait must be 0 .. %d", MaxGameDataWait)
+	}
+
+	err := checkMinBytes(req.MinBytes)
+	if err != nil {
+		return err
+	}
+
+	return checkEncoding(req.Encoding)
+}
+
+func checkMinBytes(minBytes int) error {
+	if minBytes < 0 || minBytes > MaxGameDataBytes {
+		return fmt.Errorf("MinBytes must be 0 .. %d", MaxGameDataBytes)
+	}
+
+	return nil
+}
+
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case GAMEDATA_UINT32, GAMEDATA_BYTES:
+		return nil
+	}
+
+	return fmt.Errorf("unknown Encoding '%s'", encoding)
+}
+
+//
+// Puts data in Data or Bytes depending on encoding.  (yuck) Data repacks the
+// 8 bit data into uint32 values because otherwise the handling in javascript
+// out on the client side becomes incredibly complex; newer clients decode
+// Bytes instead, a third to a fifth of the size.
+//
+func PackGameData(encoding string, data []byte) ([]uint32, []byte) {
+	if encoding == GAMEDATA_BYTES {
+		return nil, data
+	}
+
+	packed := make([]uint32, len(data))
+	for i, v := range data {
+		packed[i] = uint32(v)
+	}
+
+	return packed, nil
+}
+
+const(
+	MaxExchangeWait	= 3000	// milliseconds, keys included, well within App Engine's default 5 second fetch deadline
+)
+
+//
+// Sends keys, like InputRequest, and then returns the game data huntd has
+// sent since the last GameData or Exchange, like GameDataRequest.  With less
+// than MinBytes of game data, waits for more until Wait milliseconds after
+// the request arrived; time spent sending Events counts against Wait.
+//
+type ExchangeRequest struct {
+	Token		int
+	PlayerID	string
+	Keys		string
+	Events		[]InputEvent
+	Seq		uint64
+	Wait		int64
+	MinBytes	int
+	Encoding	string
+}
+
+type ExchangeReply struct {
+	Token		int
+	Input		*InputReply	// nil if there were no keys to send
+	Timeout		bool		// no game data within Wait
+	Data		[]uint32
+	Bytes		[]byte		`json:",omitempty"`
+}
+
+// the keys to send, nil if there are none
+func (req *ExchangeRequest) InputRequest() *InputRequest {
+	if req.Keys == "" && len(req.Events) == 0 {
+		return nil
+	}
+
+	return &InputRequest{
+		Token:		req.Token,
+		PlayerID:	req.PlayerID,
+		Keys:		req.Keys,
+		Events:		req.Events,
+		Seq:		req.Seq,
+	}
+}
+
+func (req *ExchangeRequest) Validate() error {
+	if req.PlayerID == "" {
+		return fmt.Errorf("missing PlayerID")
+	}
+	if req.Wait < 0 || req.Wait > MaxExchangeWait {
+		return fmt.Errorf("Wait must be 0 .. %d", MaxExchangeWait)
+	}
+
+	err := checkMinBytes(req.MinBytes)
+	if err != nil {
+		return err
+	}
+
+	err = checkEncoding(req.Encoding)
+	if err != nil {
+		return err
+	}
+
+	input := req.InputRequest()
+	if input != nil {
+		return input.Validate()
+	}
+
+	return nil
+}
+
+const(
+	MaxInputEvents	= 64	// per InputRequest
+	MaxInputSpan	= 1000	// milliseconds, later InputEvent Offsets are treated as this
+)
+
+// Keys pressed Offset milliseconds after the first key of an InputRequest
+type InputEvent struct {
+	Keys	string
+	Offset	int64
 }
 
+//
+// Keys are sent to huntd straight away, followed by each of Events at its
+// Offset, so a batch of keys reaches huntd with the cadence they were typed.
+//
+// Seq makes Input safe to retry: the game server remembers the replies to a
+// player's recent Seqs, and answers a repeated Seq with the earlier reply
+// instead of sending the keys to huntd again.  Seqs must be unique for the
+// player, and increasing, though they may arrive out of order.  They may wrap
+// around past the largest uint64.  0 means the request isn't de-duplicated.
+//
 type InputRequest struct {
 	Token		int
 	PlayerID	string
 	Keys		string
+	Events		[]InputEvent
+	Seq		uint64
+}
+
+//
+// The keys to send, in order, with Keys at Offset 0.  Offsets are made non
+// decreasing and no later than MaxInputSpan.
+//
+func (req *InputRequest) Batch() []InputEvent {
+	batch := make([]InputEvent, 0, len(req.Events) + 1)
+	if req.Keys != "" {
+		batch = append(batch, InputEvent{Keys: req.Keys})
+	}
+
+	var prev int64
+	for _, event := range req.Events {
+		if event.Offset < prev {
+			event.Offset = prev
+		}
+		if event.Offset > MaxInputSpan {
+			event.Offset = MaxInputSpan
+		}
+		prev = event.Offset
+
+		batch = append(batch, event)
+	}
+
+	return batch
+}
+
+func (req *InputRequest) Validate() error {
+	if req.PlayerID == "" {
+		return fmt.Errorf("missing PlayerID")
+	}
+	if req.Keys == "" && len(req.Events) == 0 {
+		return fmt.Errorf("missing Keys")
+	}
+	if len(req.Events) > MaxInputEvents {
+		return fmt.Errorf("more than %d Events", MaxInputEvents)
+	}
+	for i, event := range req.Events {
+		if event.Keys == "" {
+			return fmt.Errorf("Events[%d]: missing Keys", i)
+		}
+	}
+
+	return nil
 }
 
 type InputReply struct {
 	Token		int
 	Timeout		bool
 	TimeoutError	string
+	Duplicate	bool	// Seq was seen before, the keys weren't sent again
 }
 
 type KeepaliveRequest struct {
@@ -127,6 +344,83 @@ type KeepaliveReply struct {
 	Seq	uint64
 }
 
+// Admin requests, see server-frontend/admin.go
+
+type PlayerInfo struct {
+	PlayerID	string
+	Name		string
+	Team		string	// "0" .. "9" or "none"
+	Uid		uint32
+	ConnectMode	uint32
+	Joined		time.Time
+	LastInput	time.Time	// last keys sent by the player, the zero time if none yet
+	LastSeen	time.Time	// last request of any kind for the player
+	Coalesced	uint64		// times the player fell behind and was sent a screen diff
+}
+
+type PlayersRequest struct {
+	Token	int
+}
+
+type PlayersReply struct {
+	Token	int
+	Players	[]*PlayerInfo
+}
+
+type KickRequest struct {
+	Token		int
+	PlayerID	string
+}
+
+type KickReply struct {
+	Token	int
+}
+
+type DrainRequest struct {
+	Token		int
+	Draining	bool	// false to take the instance out of drain mode
+}
+
+type DrainReply struct {
+	Token		int
+	Draining	bool
+}
+
+type RestartRequest struct {
+	Token	int
+}
+
+type RestartReply struct {
+	Token	int
+}
+
+// Addresses of the huntd a game server is fronting
+type HuntdInfo struct {
+	WellKnownAddr	string	// UDP, where huntd answers discovery requests
+	GameAddr	string	// TCP, "" until discovered
+	StatsAddr	string	// TCP, "" until discovered
+	Protocol	string	// huntd protocol variant
+}
+
+// A game server's self description, served as JSON on /info
+type ServerInfo struct {
+	Version		string	// server-game build version
+	Uptime		int64	// seconds since the game server started
+	RpcType		string	// "jsonrpc" or "netrpc"
+	Host		string
+	Port		string
+	TLS		bool
+	Huntd		HuntdInfo
+	Players		int
+	MaxPlayers	int
+	Rooms		int
+	Draining	bool
+	Healthy		bool
+	Health		string	// why the server isn't healthy, "" if it is
+	Coalesced	uint64	// times a slow player's backlog was replaced by a screen diff
+	CoalescedBytes	uint64	// game data in those backlogs
+}
+
 type HTTPServerExit struct {
 	err	error
 }
@@ -0,0 +1,108 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package gamerpc
+
+import(
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInputBatch(t *testing.T) {
+	tests := []struct {
+		name	string
+		req	InputRequest
+		want	[]InputEvent
+	}{
+		{"keys",		InputRequest{Keys: "hj"},	[]InputEvent{{"hj", 0}}},
+		{"events",		InputRequest{Events: []InputEvent{{"h", 10}, {"j", 20}}},	[]InputEvent{{"h", 10}, {"j", 20}}},
+		{"keys first",		InputRequest{Keys: "k", Events: []InputEvent{{"h", 10}}},	[]InputEvent{{"k", 0}, {"h", 10}}},
+		{"out of order",	InputRequest{Events: []InputEvent{{"h", 30}, {"j", 20}, {"k", 40}}},	[]InputEvent{{"h", 30}, {"j", 30}, {"k", 40}}},
+		{"negative",		InputRequest{Keys: "k", Events: []InputEvent{{"h", -5}}},	[]InputEvent{{"k", 0}, {"h", 0}}},
+		{"too late",		InputRequest{Events: []InputEvent{{"h", MaxInputSpan + 1}, {"j", 1 << 40}}},	[]InputEvent{{"h", MaxInputSpan}, {"j", MaxInputSpan}}},
+	}
+
+	for _, test := range tests {
+		got := test.req.Batch()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInputValidate(t *testing.T) {
+	tooMany := make([]InputEvent, MaxInputEvents + 1)
+	for i := range tooMany {
+		tooMany[i].Keys = "h"
+	}
+
+	tests := []struct {
+		name	string
+		req	InputRequest
+		err	string	// in the error, "" for none
+	}{
+		{"keys",		InputRequest{PlayerID: "1", Keys: "h"},				""},
+		{"events",		InputRequest{PlayerID: "1", Events: []InputEvent{{"h", 0}}},	""},
+		{"most events",		InputRequest{PlayerID: "1", Events: tooMany[1:]},		""},
+		{"no player",		InputRequest{Keys: "h"},					"PlayerID"},
+		{"no keys",		InputRequest{PlayerID: "1"},					"Keys"},
+		{"empty event",		InputRequest{PlayerID: "1", Events: []InputEvent{{"h", 0}, {"", 5}}},	"Events[1]"},
+		{"too many events",	InputRequest{PlayerID: "1", Events: tooMany},			"Events"},
+	}
+
+	for _, test := range tests {
+		err := test.req.Validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestExchangeValidate(t *testing.T) {
+	tests := []struct {
+		name	string
+		req	ExchangeRequest
+		err	string
+	}{
+		{"no keys",		ExchangeRequest{PlayerID: "1"},						""},
+		{"keys",		ExchangeRequest{PlayerID: "1", Keys: "h", Wait: MaxExchangeWait},	""},
+		{"bytes",		ExchangeRequest{PlayerID: "1", Encoding: GAMEDATA_BYTES, MinBytes: MaxGameDataBytes},	""},
+		{"no player",		ExchangeRequest{},							"PlayerID"},
+		{"long wait",		ExchangeRequest{PlayerID: "1", Wait: MaxExchangeWait + 1},		"Wait"},
+		{"negative wait",	ExchangeRequest{PlayerID: "1", Wait: -1},				"Wait"},
+		{"min bytes",		ExchangeRequest{PlayerID: "1", MinBytes: MaxGameDataBytes + 1},		"MinBytes"},
+		{"encoding",		ExchangeRequest{PlayerID: "1", Encoding: "hex"},			"Encoding"},
+		{"bad event",		ExchangeRequest{PlayerID: "1", Events: []InputEvent{{"", 0}}},		"Events[0]"},
+	}
+
+	for _, test := range tests {
+		err := test.req.Validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	req := &ExchangeRequest{Token: 3, PlayerID: "1", Seq: 7}
+	if req.InputRequest() != nil {
+		t.Errorf("InputRequest without keys isn't nil")
+	}
+	req.Events = []InputEvent{{"h", 5}}
+	input := req.InputRequest()
+	if input == nil || input.Token != 3 || input.PlayerID != "1" || input.Seq != 7 || len(input.Events) != 1 {
+		t.Errorf("InputRequest = %+v", input)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// The game data stream huntd sends each player: curses-like draw opcodes,
+// some followed by arguments, and plain characters.  The game server only
+// returns whole opcodes, so each GameData or Exchange reply can be decoded on
+// its own.
+package gamerpc
+
+// draw opcodes, from huntd's hunt.h
+const(
+	DRAW_CLEAR	= 195	// clear screen
+	DRAW_REDRAW	= 210	// redraw screen
+	DRAW_ADDCH	= 225	// literal character, followed by the character
+	DRAW_BELL	= 226	// audible bell
+	DRAW_CLRTOEOL	= 227	// clear to end of line
+	DRAW_ENDWIN	= 229	// end game, followed by the mode
+	DRAW_READY	= 231	// server ready, followed by a count
+	DRAW_MOVE	= 237	// cursor motion, followed by row and column
+	DRAW_REFRESH	= 242	// refresh screen
+)
+
+// whether b is one of the draw opcodes rather than a plain character
+func IsDrawOp(b byte) bool {
+	switch b {
+	case DRAW_CLEAR, DRAW_REDRAW, DRAW_ADDCH, DRAW_BELL, DRAW_CLRTOEOL, DRAW_ENDWIN, DRAW_READY, DRAW_MOVE, DRAW_REFRESH:
+		return true
+	}
+
+	return false
+}
+
+// how many argument bytes follow op
+func DrawArgs(op byte) int {
+	switch op {
+	case DRAW_ADDCH, DRAW_ENDWIN, DRAW_READY:
+		return 1
+	case DRAW_MOVE:
+		return 2
+	}
+
+	return 0
+}
+
+//
+// Returns how much of data is whole opcodes.  The rest, at most two bytes,
+// is the start of an opcode whose arguments haven't arrived yet.
+//
+func CompleteGameData(data []byte) int {
+	n := 0
+	for n < len(data) {
+		next := n + 1 + DrawArgs(data[n])
+		if next > len(data) {
+			break
+		}
+		n = next
+	}
+
+	return n
+}
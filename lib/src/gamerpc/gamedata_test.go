@@ -0,0 +1,106 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package gamerpc
+
+import(
+	"math/rand"
+	"testing"
+)
+
+func TestDrawArgs(t *testing.T) {
+	tests := []struct {
+		op	byte
+		args	int
+	}{
+		{DRAW_CLEAR,	0},
+		{DRAW_REDRAW,	0},
+		{DRAW_ADDCH,	1},
+		{DRAW_BELL,	0},
+		{DRAW_CLRTOEOL,	0},
+		{DRAW_ENDWIN,	1},
+		{DRAW_READY,	1},
+		{DRAW_MOVE,	2},
+		{DRAW_REFRESH,	0},
+		{'a',		0},
+		{0,		0},
+		{128,		0},	// literal bytes above 127 that aren't opcodes
+		{200,		0},
+		{255,		0},
+	}
+
+	for _, test := range tests {
+		if got := DrawArgs(test.op); got != test.args {
+			t.Errorf("DrawArgs(%d) = %d, want %d", test.op, got, test.args)
+		}
+	}
+}
+
+func TestCompleteGameData(t *testing.T) {
+	tests := []struct {
+		name		string
+		data		[]byte
+		complete	int
+	}{
+		{"empty",			nil,						0},
+		{"plain",			[]byte("abc"),					3},
+		{"move at the end",		[]byte{'a', DRAW_MOVE},				1},
+		{"move missing col",		[]byte{'a', DRAW_MOVE, 5},			1},
+		{"move whole",			[]byte{'a', DRAW_MOVE, 5, 6},			4},
+		{"addch missing char",		[]byte{'a', DRAW_ADDCH},			1},
+		{"addch of an opcode",		[]byte{DRAW_ADDCH, DRAW_MOVE, 'b'},		3},
+		{"move to opcode values",	[]byte{DRAW_MOVE, DRAW_CLEAR, DRAW_ADDCH},	3},
+		{"ready at the end",		[]byte{DRAW_REFRESH, DRAW_READY},		1},
+		{"endwin at the end",		[]byte{DRAW_ENDWIN},				0},
+		{"endwin whole",		[]byte{DRAW_ENDWIN, 'q'},			2},
+		{"literals above 127",		[]byte{128, 200, 255, DRAW_CLEAR},		4},
+		{"literal then move",		[]byte{128, DRAW_MOVE, 200},			1},
+	}
+
+	for _, test := range tests {
+		if got := CompleteGameData(test.data); got != test.complete {
+			t.Errorf("%s: CompleteGameData(%v) = %d, want %d", test.name, test.data, got, test.complete)
+		}
+	}
+}
+
+// any prefix of a stream of whole opcodes splits on an opcode boundary
+func TestCompleteGameDataPrefixes(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var stream []byte
+	var boundaries = map[int]bool{0: true}
+	for len(stream) < 4096 {
+		op := byte(rnd.Intn(256))
+		stream = append(stream, op)
+		for i := 0; i < DrawArgs(op); i++ {
+			stream = append(stream, byte(rnd.Intn(256)))
+		}
+		boundaries[len(stream)] = true
+	}
+
+	for n := 0; n <= len(stream); n++ {
+		complete := CompleteGameData(stream[:n])
+		if !boundaries[complete] || complete > n || n - complete > 2 {
+			t.Fatalf("CompleteGameData of the first %d bytes = %d, not an opcode boundary", n, complete)
+		}
+		for k := complete + 1; k <= n; k++ {
+			if boundaries[k] {
+				t.Fatalf("CompleteGameData of the first %d bytes = %d, but %d is a later boundary", n, complete, k)
+			}
+		}
+	}
+}
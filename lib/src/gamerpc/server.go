@@ -14,32 +14,96 @@
 //
 ////////////////////////////////////////////////////////////////////////////////
 //
-// TODO: High-level file comment.
+// GameServer serves the game's RPCs to the frontend.  Each GameServer has its
+// own listener, mux and RPC server, so several can run in one process.
 package gamerpc
 
 import(
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"time"
 	"net"
 	"net/http"
 	"net/rpc"
+	"sync"
+
+	"apputils"
 
 	grpc "github.com/gorilla/rpc"
 	gjson "github.com/gorilla/rpc/json"
 )
 
+//
+// Implemented by services which can describe themselves for /info.  The
+// GameServer fills in the fields it knows about, e.g. RpcType and TLS.
+//
+type InfoProvider interface {
+	Info() *ServerInfo
+}
+
+//
+// Implemented by services which can tell whether they're able to serve
+// games, for /readyz.  Services which don't are always ready.
+//
+type ReadinessChecker interface {
+	Ready() error
+}
+
 type GameServer struct {
 	host		string
 	port		string
 	rpcType		int
+	service		interface{}
 	keepaliveDelay	time.Duration
 	eventc		chan interface{}
 	listener	net.Listener
 	gserver		*grpc.Server
+	auth		*apputils.RequestVerifier	// nil if requests aren't authenticated
+	tlsConfig	*tls.Config			// nil to serve plain HTTP
+	clientCerts	bool				// the RPCs and /info require a client certificate
+	mux		*http.ServeMux
+	server		*http.Server
+	done		chan struct{}			// closed by Shutdown
+	shutdownOnce	sync.Once
 }
 
 func (gs *GameServer) info(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "host %s port %s rpctype %d\n", gs.host, gs.port, gs.rpcType)
+	info := &ServerInfo{Healthy: true}
+	if provider, ok := gs.service.(InfoProvider); ok {
+		info = provider.Info()
+	}
+
+	info.RpcType = RpcTypeToString(gs.rpcType)
+	info.Host = gs.host
+	info.Port = gs.port
+	info.TLS = gs.tlsConfig != nil
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+
+	err := json.NewEncoder(w).Encode(info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// the process is up and serving HTTP
+func (gs *GameServer) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "ok\n")
+}
+
+// the service can serve games, i.e. load balancers may send players here
+func (gs *GameServer) readyz(w http.ResponseWriter, r *http.Request) {
+	if checker, ok := gs.service.(ReadinessChecker); ok {
+		err := checker.Ready()
+		if err != nil {
+			http.Error(w, "not ready: " + err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "ready\n")
 }
 
 func (gs *GameServer) emptyjs(w http.ResponseWriter, r *http.Request) {
@@ -48,27 +112,95 @@ func (gs *GameServer) emptyjs(w http.ResponseWriter, r *http.Request) {
 
 func (gs *GameServer) keepalive(eventc chan interface{}) {
 	var seq uint64
+
+	ticker := time.NewTicker(gs.keepaliveDelay)
+	defer ticker.Stop()
+
 	for {
-		eventc <- &KeepaliveRequest{Seq: seq}
-		seq++
+		select {
+		case eventc <- &KeepaliveRequest{Seq: seq}:
+			seq++
+		case <-gs.done:
+			return
+		}
 
-		time.Sleep(gs.keepaliveDelay)
+		select {
+		case <-ticker.C:
+		case <-gs.done:
+			return
+		}
 	}
 }
 
-func (gs *GameServer) start() {
-	http.HandleFunc("/info", gs.info)
-	http.HandleFunc("/empty.js", gs.emptyjs)
+//
+// Requests from the frontend must be signed if the server was given a
+// verifier, and come with a client certificate if the TLS config requires one.
+//
+func (gs *GameServer) authenticated(handler http.Handler) http.Handler {
+	if gs.auth != nil {
+		handler = gs.auth.Handler(handler)
+	}
 
+	if !gs.clientCerts {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the listener has already verified any certificate presented
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (gs *GameServer) start() {
 	go gs.keepalive(gs.eventc)
 
-	err := http.Serve(gs.listener, nil)
+	err := gs.server.Serve(gs.listener)
+	if err == http.ErrServerClosed {
+		return
+	}
 
 	event := &HTTPServerExit{err: err}
-	gs.eventc <- event
+	select {
+	case gs.eventc <- event:
+	case <-gs.done:
+	}
+}
+
+// The server's handlers, e.g. for serving them from a test server
+func (gs *GameServer) Handler() http.Handler {
+	return gs.mux
+}
+
+// The address the server is listening on, useful when started on port "0"
+func (gs *GameServer) Addr() net.Addr {
+	return gs.listener.Addr()
 }
 
-func NewGameServer(host string, port string, rpcTypeStr string, service interface{}, keepaliveDelay time.Duration, eventc chan interface{}) (*GameServer, error) {
+//
+// Stops the keepalives, closes the listener and waits for in-flight RPCs to
+// finish, or for ctx to be done, whichever comes first.
+//
+func (gs *GameServer) Shutdown(ctx context.Context) error {
+	gs.shutdownOnce.Do(func() {
+		close(gs.done)
+	})
+
+	return gs.server.Shutdown(ctx)
+}
+
+//
+// auth may be nil, in which case anyone who can reach the server can call it.
+// If tlsConfig is non-nil the server speaks HTTPS, and requires client
+// certificates if tlsConfig says so (see apputils.NewServerTLSConfig).  They
+// are only required for the RPCs and /info, so that load balancers and health
+// checkers, which don't have one, can still reach /healthz and /readyz.
+//
+func NewGameServer(host string, port string, rpcTypeStr string, service interface{}, keepaliveDelay time.Duration, eventc chan interface{}, auth *apputils.RequestVerifier, tlsConfig *tls.Config) (*GameServer, error) {
 	var err error
 	var rpcType int
 
@@ -77,39 +209,71 @@ func NewGameServer(host string, port string, rpcTypeStr string, service interfac
 		return nil, err
 	}
 
+	clientCerts := tlsConfig != nil && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	if clientCerts {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	gs := &GameServer{
 		host:		host,
 		port:		port,
 		rpcType:	rpcType,
+		service:	service,
 		keepaliveDelay:	keepaliveDelay,
 		eventc:		eventc,
 		listener:	nil,
 		gserver:	nil,
+		auth:		auth,
+		tlsConfig:	tlsConfig,
+		clientCerts:	clientCerts,
+		mux:		http.NewServeMux(),
+		done:		make(chan struct{}),
 	}
 
+	gs.mux.Handle("/info", gs.authenticated(http.HandlerFunc(gs.info)))
+	gs.mux.HandleFunc("/empty.js", gs.emptyjs)
+
+	// not authenticated, load balancers and health checkers can't sign requests
+	gs.mux.HandleFunc("/healthz", gs.healthz)
+	gs.mux.HandleFunc("/readyz", gs.readyz)
+
 	switch(rpcType) {
 	case GR_NETRPC:
-		err = rpc.Register(service)
+		if auth != nil {
+			return nil, fmt.Errorf("netrpc doesn't support request authentication")
+		}
+		s := rpc.NewServer()
+		err = s.Register(service)
 		if err != nil {
 			return nil, err
 		}
-		rpc.HandleHTTP()
+		gs.mux.Handle(rpc.DefaultRPCPath, s)
 	case GR_JSONRPC:
 		s := grpc.NewServer()
 		s.RegisterCodec(gjson.NewCodec(), "application/json")
 		s.RegisterCodec(gjson.NewCodec(), "text/plain")
-		s.RegisterService(service, "")
-		http.Handle("/jsonrpc", s)
+		err = s.RegisterService(service, "")
+		if err != nil {
+			return nil, err
+		}
+		gs.mux.Handle("/jsonrpc", gs.authenticated(s))
 		gs.gserver = s
 	default:
 		return nil, fmt.Errorf("unhandled rpc type '%s' (%d)", rpcTypeStr, rpcType)
 	}
 
+	gs.server = &http.Server{Handler: gs.mux}
+
 	gs.listener, err = net.Listen("tcp", host + ":" + port)
 	if err != nil {
 		return nil, err
 	}
 
+	if tlsConfig != nil {
+		gs.listener = tls.NewListener(gs.listener, tlsConfig)
+	}
+
 	go gs.start()
 
 	return gs, nil
@@ -0,0 +1,308 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package gamerpc
+
+import(
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"apputils"
+)
+
+// named like the game's service, so GameClient calls reach it
+type HuntDaemon struct {
+	notReady	error
+}
+
+func (huntd *HuntDaemon) JPing(r *http.Request, req *PingRequest, reply *PingReply) error {
+	reply.Token = req.Token
+	reply.Seq = req.Seq + 1
+	return nil
+}
+
+func (huntd *HuntDaemon) Info() *ServerInfo {
+	return &ServerInfo{Version: "v1.2", RpcType: "made up", Healthy: true}
+}
+
+func (huntd *HuntDaemon) Ready() error {
+	return huntd.notReady
+}
+
+func testGameServer(t *testing.T, service interface{}, keys string) (*GameServer, chan interface{}) {
+	auth, err := apputils.NewRequestVerifier(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventc := make(chan interface{}, 1)
+	gs, err := NewGameServer("127.0.0.1", "0", "jsonrpc", service, time.Hour, eventc, auth, nil)
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+
+	return gs, eventc
+}
+
+func testGameClient(t *testing.T, gs *GameServer, keys string) *GameClient {
+	gc, err := NewGameClient("http://" + gs.Addr().String() + "/jsonrpc", "jsonrpc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.Signer, err = apputils.NewRequestSigner(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.Timeout = 5 * time.Second
+
+	return gc
+}
+
+func get(t *testing.T, gs *GameServer, path string) (int, string) {
+	resp, err := http.Get("http://" + gs.Addr().String() + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestGameServerServesRPCs(t *testing.T) {
+	// two servers in one process don't share handlers
+	for i := 0; i < 2; i++ {
+		gs, eventc := testGameServer(t, &HuntDaemon{}, "")
+		defer gs.Shutdown(context.Background())
+
+		gc := testGameClient(t, gs, "")
+		reply, err := gc.Ping(httptest.NewRequest("GET", "/", nil), &PingRequest{Token: 7, Seq: 1})
+		if err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+		if reply.Token != 7 || reply.Seq != 2 {
+			t.Errorf("Ping reply = %+v", reply)
+		}
+
+		select {
+		case event := <-eventc:
+			if ka, ok := event.(*KeepaliveRequest); !ok || ka.Seq != 0 {
+				t.Errorf("first event = %#v, want keepalive 0", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no keepalive")
+		}
+	}
+}
+
+func TestGameServerAuth(t *testing.T) {
+	gs, _ := testGameServer(t, &HuntDaemon{}, "k:secret")
+	defer gs.Shutdown(context.Background())
+
+	r := httptest.NewRequest("GET", "/", nil)
+
+	_, err := testGameClient(t, gs, "k:secret").Ping(r, &PingRequest{})
+	if err != nil {
+		t.Errorf("signed Ping: %v", err)
+	}
+
+	for _, keys := range []string{"", "k:wrong", "other:secret"} {
+		_, err = testGameClient(t, gs, keys).Ping(r, &PingRequest{})
+		if e, ok := err.(*apputils.HttpStatusError); !ok || e.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Ping signed with %q: err = %v, want 401", keys, err)
+		}
+	}
+
+	if status, _ := get(t, gs, "/info"); status != http.StatusUnauthorized {
+		t.Errorf("unsigned /info: status %d", status)
+	}
+	info, err := testGameClient(t, gs, "k:secret").Info(r)
+	if err != nil {
+		t.Fatalf("signed /info: %v", err)
+	}
+	// the service describes itself, the server fills in how it's served
+	if info.Version != "v1.2" || !info.Healthy || info.RpcType != "jsonrpc" || info.Host != "127.0.0.1" || info.TLS {
+		t.Errorf("info = %+v", info)
+	}
+
+	// health checkers can't sign
+	if status, _ := get(t, gs, "/healthz"); status != http.StatusOK {
+		t.Errorf("unsigned /healthz: status %d", status)
+	}
+	if status, _ := get(t, gs, "/readyz"); status != http.StatusOK {
+		t.Errorf("unsigned /readyz: status %d", status)
+	}
+}
+
+func TestGameServerNetRPCRefusesAuth(t *testing.T) {
+	auth, _ := apputils.NewRequestVerifier("k:secret")
+
+	_, err := NewGameServer("127.0.0.1", "0", "netrpc", &HuntDaemon{}, time.Hour, make(chan interface{}), auth, nil)
+	if err == nil {
+		t.Errorf("netrpc server with request authentication created")
+	}
+}
+
+func TestGameServerReadyz(t *testing.T) {
+	huntd := &HuntDaemon{notReady: errors.New("huntd isn't answering")}
+	gs, _ := testGameServer(t, huntd, "")
+	defer gs.Shutdown(context.Background())
+
+	status, body := get(t, gs, "/readyz")
+	if status != http.StatusServiceUnavailable || body != "not ready: huntd isn't answering\n" {
+		t.Errorf("/readyz while not ready: %d %q", status, body)
+	}
+	if status, _ := get(t, gs, "/healthz"); status != http.StatusOK {
+		t.Errorf("/healthz while not ready: status %d", status)
+	}
+}
+
+// signs a certificate for name with parent, or self-signs a CA if parent is nil
+func testCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:	big.NewInt(time.Now().UnixNano()),
+		Subject:	pkix.Name{CommonName: name},
+		NotBefore:	time.Now().Add(-time.Hour),
+		NotAfter:	time.Now().Add(time.Hour),
+		KeyUsage:	x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:	[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:	[]net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestGameServerMutualTLSProbes(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	server := testCert(t, "server", &ca)
+	client := testCert(t, "client", &ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	serverCfg := &tls.Config{
+		Certificates:	[]tls.Certificate{server},
+		ClientCAs:	pool,
+		ClientAuth:	tls.RequireAndVerifyClientCert,
+	}
+
+	gs, err := NewGameServer("127.0.0.1", "0", "jsonrpc", &HuntDaemon{}, time.Hour, make(chan interface{}, 1), nil, serverCfg)
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+	defer gs.Shutdown(context.Background())
+
+	probe := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	frontend := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{client}}}}
+
+	tests := []struct {
+		name	string
+		client	*http.Client
+		path	string
+		status	int
+	}{
+		{"probe healthz",	probe,		"/healthz",	http.StatusOK},
+		{"probe readyz",	probe,		"/readyz",	http.StatusOK},
+		{"probe info",		probe,		"/info",	http.StatusUnauthorized},
+		{"probe jsonrpc",	probe,		"/jsonrpc",	http.StatusUnauthorized},
+		{"frontend info",	frontend,	"/info",	http.StatusOK},
+	}
+
+	for _, test := range tests {
+		resp, err := test.client.Get("https://" + gs.Addr().String() + test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+	}
+
+	// the frontend's RPCs still work
+	gc, err := NewGameClient("https://" + gs.Addr().String() + "/jsonrpc", "jsonrpc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.SetTLSConfig(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{client}})
+	_, err = gc.Ping(httptest.NewRequest("GET", "/", nil), &PingRequest{Token: 1})
+	if err != nil {
+		t.Errorf("Ping with a client certificate: %v", err)
+	}
+}
+
+func TestGameServerShutdown(t *testing.T) {
+	gs, eventc := testGameServer(t, &HuntDaemon{}, "")
+	<-eventc
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	err := gs.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// closing the listener isn't reported as the server exiting
+	select {
+	case event := <-eventc:
+		t.Errorf("event after Shutdown: %#v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = http.Get("http://" + gs.Addr().String() + "/healthz")
+	if err == nil {
+		t.Errorf("still serving after Shutdown")
+	}
+
+	// a second Shutdown doesn't panic
+	gs.Shutdown(ctx)
+}
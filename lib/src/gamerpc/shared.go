@@ -39,6 +39,17 @@ func StringToRpcType(str string) (int, error) {
 	return 0, fmt.Errorf("unsupported rpc type '%s'", str)
 }
 
+func RpcTypeToString(rpcType int) string {
+	switch(rpcType) {
+	case GR_NETRPC:
+		return "netrpc"
+	case GR_JSONRPC:
+		return "jsonrpc"
+	}
+
+	return fmt.Sprintf("unknown(%d)", rpcType)
+}
+
 //
 // TODO(tadhunt): find a better home for this
 //
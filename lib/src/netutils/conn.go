@@ -40,6 +40,16 @@ func (toc *TimeoutTCPConn) Read(buf []byte) (int, error) {
 	return toc.conn.Read(buf)
 }
 
+// like Read, but waits for timeout rather than the connection's timeout
+func (toc *TimeoutTCPConn) ReadTimeout(buf []byte, timeout time.Duration) (int, error) {
+	err := toc.conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	return toc.conn.Read(buf)
+}
+
 func (toc *TimeoutTCPConn) Write(buf []byte) (int, error) {
 	err := toc.conn.SetWriteDeadline(time.Now().Add(toc.timeout))
 	if err != nil {
@@ -92,3 +102,12 @@ func (toc *TimeoutUDPConn) ReadFromUDP(buf []byte) (int, *net.UDPAddr, error) {
 
 	return toc.conn.ReadFromUDP(buf)
 }
+
+func (toc *TimeoutUDPConn) ReadFromUDPTimeout(buf []byte, timeout time.Duration) (int, *net.UDPAddr, error) {
+	err := toc.conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return toc.conn.ReadFromUDP(buf)
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Runs the frontend as a plain net/http server, without App Engine.
+// The frontend package registers the /api/v1 handlers as a side effect of
+// being imported, this program adds the static files app.yaml would serve.
+//
+// It is built without the appengine build tag, which is what selects the
+// standalone platform, so SERVER_FRONTEND_STANDALONE doesn't need to be set.
+package main
+
+import(
+	"flag"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"apputils"
+
+	_ "server-frontend"
+)
+
+func staticFile(path string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, path)
+	}
+}
+
+func main() {
+	var listenAddr string
+	var appDir string
+
+	flag.StringVar(&listenAddr, "listen", ":8080", "address to serve HTTP on")
+	flag.StringVar(&appDir, "app-dir", "server-frontend/src/server-frontend", "directory containing client/ and assets/")
+
+	flag.Parse()
+
+	log.Printf("Platform:       %s", apputils.CurrentPlatform().Name)
+	log.Printf("Listen:         %s", listenAddr)
+	log.Printf("App Dir:        %s", appDir)
+
+	client := filepath.Join(appDir, "client")
+	assets := filepath.Join(appDir, "assets")
+
+	mux := http.NewServeMux()
+	mux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir(assets))))
+	mux.HandleFunc("/index.html", staticFile(filepath.Join(client, "hunt.html")))
+	mux.HandleFunc("/hunt.js", staticFile(filepath.Join(client, "hunt.js")))
+	mux.HandleFunc("/style.css", staticFile(filepath.Join(client, "style.css")))
+	mux.Handle("/api/v1/", http.DefaultServeMux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(client, "hunt.html"))
+	})
+
+	err := http.ListenAndServe(listenAddr, mux)
+	log.Fatalf("ListenAndServe: %v", err)
+}
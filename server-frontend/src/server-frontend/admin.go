@@ -0,0 +1,205 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// The admin API, for operators rather than players.  Every request must carry
+// one of the tokens in SERVER_FRONTEND_ADMIN_TOKENS (comma separated) in an
+// "Authorization: Bearer" header.  Without any tokens the admin API is off.
+// Instances and their load are listed by the public /api/v1/instances.
+//
+//	GET  /api/v1/admin/players/{instance}	players with join time and last activity
+//	POST /api/v1/admin/kick/{instance}	disconnect a player: {"PlayerID": ...}
+//	POST /api/v1/admin/announce/{instance}	send a message to everyone: {"Message": ..., "Name": ...}
+//	POST /api/v1/admin/drain/{instance}	stop accepting players: {"Draining": true|false}
+//	POST /api/v1/admin/restart/{instance}	restart huntd, disconnecting everyone
+package frontend
+
+import(
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"apputils"
+	"gamerpc"
+)
+
+const(
+	DefaultAnnounceName	= "operator"
+)
+
+var ErrAdminDisabled = errors.New("admin API not configured")
+var ErrNotAdmin = errors.New("not authorized")
+
+var adminTokens []string
+
+type AnnounceRequest struct {
+	Token	int
+	Name	string	// code name the message appears to be from, defaults to DefaultAnnounceName
+	Message	string
+}
+
+func initAdmin() {
+	for _, token := range strings.Split(os.Getenv("SERVER_FRONTEND_ADMIN_TOKENS"), ",") {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			adminTokens = append(adminTokens, token)
+		}
+	}
+}
+
+//
+// Checks the request carries an admin token.  On failure an error response
+// has been written and false is returned.
+//
+func CheckAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if len(adminTokens) == 0 {
+		apputils.Error(w, r, http.StatusForbidden, ErrAdminDisabled.Error(), ErrAdminDisabled)
+		return false
+	}
+
+	token := requestSessionToken(r)
+	for _, t := range adminTokens {
+		if hmac.Equal([]byte(t), []byte(token)) {
+			return true
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer realm=\"admin\"")
+	apputils.Error(w, r, http.StatusUnauthorized, ErrNotAdmin.Error(), ErrNotAdmin)
+
+	return false
+}
+
+func NewAdminGameHandler(handler func(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	gameHandler := NewGameHandler(handler)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !CheckAdmin(w, r) {
+			return
+		}
+
+		gameHandler(w, r)
+	}
+}
+
+// decodes an optional JSON body into v
+func decodeAdmin(r io.Reader, v interface{}) error {
+	err := json.NewDecoder(r).Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+func adminReply(w http.ResponseWriter, r *http.Request, reply interface{}, err error) {
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(reply)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+}
+
+func adminPlayersHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+	reply, err := game.ListPlayers(r, &gamerpc.PlayersRequest{})
+
+	adminReply(w, r, reply, err)
+}
+
+func adminKickHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+	var request gamerpc.KickRequest
+	err := decodeAdmin(r.Body, &request)
+	if err == nil && request.PlayerID == "" {
+		err = fmt.Errorf("missing PlayerID")
+	}
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	reply, err := game.Kick(r, &request)
+
+	adminReply(w, r, reply, err)
+}
+
+// sends the message the same way the hunt client's -m option does
+func adminAnnounceHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+	var request AnnounceRequest
+	err := decodeAdmin(r.Body, &request)
+	if err == nil && request.Message == "" {
+		err = fmt.Errorf("missing Message")
+	}
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	if request.Name == "" {
+		request.Name = DefaultAnnounceName
+	}
+
+	message := &gamerpc.MessageRequest{
+		Token:		request.Token,
+		Message:	request.Message,
+		Join:		gamerpc.JoinRequest{
+					Name:		request.Name,
+					Team:		apputils.TEAM_NONE,
+					EnterStatus:	gamerpc.Q_FLY,
+					Ttyname:	"/dev/tty-admin",
+					ConnectMode:	gamerpc.C_MESSAGE,
+				},
+	}
+
+	reply, err := game.Message(r, message)
+
+	adminReply(w, r, reply, err)
+}
+
+func adminDrainHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+	request := gamerpc.DrainRequest{Draining: true}
+	err := decodeAdmin(r.Body, &request)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	reply, err := game.Drain(r, &request)
+
+	adminReply(w, r, reply, err)
+}
+
+func adminRestartHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+	var request gamerpc.RestartRequest
+	err := decodeAdmin(r.Body, &request)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	reply, err := game.Restart(r, &request)
+
+	adminReply(w, r, reply, err)
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"apputils"
+	"gamerpc"
+)
+
+// an admin API request, routed like the real thing
+func adminRequest(method string, path string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer " + token)
+	}
+
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestCheckAdmin(t *testing.T) {
+	tests := []struct {
+		auth	string
+		status	int
+	}{
+		{"Bearer test-admin-token",	0},
+		{"Bearer  test-admin-token ",	0},
+		{"",				401},
+		{"Bearer wrong",		401},
+		{"Bearer test-admin-tokenx",	401},
+		{"Basic test-admin-token",	401},
+	}
+
+	for _, test := range tests {
+		r := testRequest()
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+
+		ok := CheckAdmin(w, r)
+		if ok != (test.status == 0) {
+			t.Errorf("%q: ok = %v", test.auth, ok)
+		}
+		if test.status != 0 {
+			if w.Code != test.status || w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%q: status = %d, headers %v", test.auth, w.Code, w.Header())
+			}
+		}
+	}
+}
+
+func TestAdminDisabledWithoutTokens(t *testing.T) {
+	defer func(saved []string) { adminTokens = saved }(adminTokens)
+	adminTokens = nil
+
+	r := testRequest()
+	r.Header.Set("Authorization", "Bearer test-admin-token")
+	w := httptest.NewRecorder()
+
+	if CheckAdmin(w, r) || w.Code != http.StatusForbidden {
+		t.Errorf("admin API without tokens: status %d", w.Code)
+	}
+}
+
+func TestAdminHandlers(t *testing.T) {
+	huntd := &HuntDaemon{}
+	testRegistry(testGameServer(t, "3", huntd))
+
+	tests := []struct {
+		method	string
+		path	string
+		body	string
+		status	int
+		request	interface{}	// what the game server should be asked, nil for nothing
+	}{
+		{"GET",		"players",	"",				200,	&gamerpc.PlayersRequest{}},
+		{"POST",	"kick",		`{"PlayerID": "1"}`,		200,	&gamerpc.KickRequest{PlayerID: "1"}},
+		{"POST",	"kick",		`{}`,				400,	nil},
+		{"POST",	"kick",		`junk`,				400,	nil},
+		{"POST",	"announce",	`{"Message": "bye"}`,		200,	&gamerpc.MessageRequest{
+											Message:	"bye",
+											Join:		gamerpc.JoinRequest{
+														Name:		DefaultAnnounceName,
+														Team:		apputils.TEAM_NONE,
+														EnterStatus:	gamerpc.Q_FLY,
+														Ttyname:	"/dev/tty-admin",
+														ConnectMode:	gamerpc.C_MESSAGE,
+													},
+										}},
+		{"POST",	"announce",	`{}`,				400,	nil},
+		{"POST",	"drain",	"",				200,	&gamerpc.DrainRequest{Draining: true}},
+		{"POST",	"drain",	`{"Draining": false}`,		200,	&gamerpc.DrainRequest{Draining: false}},
+		{"POST",	"restart",	"",				200,	&gamerpc.RestartRequest{}},
+		{"GET",		"kick",		"",				404,	nil},
+	}
+
+	for _, test := range tests {
+		before := len(huntd.Requests())
+
+		// without the token the game server is never asked
+		w := adminRequest(test.method, "/api/v1/admin/" + test.path + "/3", "", test.body)
+		if test.status != 404 && w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: status %d", test.method, test.path, w.Code)
+		}
+
+		w = adminRequest(test.method, "/api/v1/admin/" + test.path + "/3", "test-admin-token", test.body)
+		if w.Code != test.status {
+			t.Errorf("%s %s %s: status %d, want %d: %s", test.method, test.path, test.body, w.Code, test.status, w.Body)
+		}
+
+		requests := huntd.Requests()[before:]
+		if test.request == nil {
+			if len(requests) != 0 {
+				t.Errorf("%s %s %s: game server asked %v", test.method, test.path, test.body, requests)
+			}
+			continue
+		}
+		if len(requests) != 1 || !reflect.DeepEqual(requests[0], test.request) {
+			t.Errorf("%s %s %s: game server asked %#v, want %#v", test.method, test.path, test.body, requests, test.request)
+		}
+	}
+
+	w := adminRequest("GET", "/api/v1/admin/players/9", "test-admin-token", "")
+	if w.Code == http.StatusOK {
+		t.Errorf("players of an unknown instance: status %d", w.Code)
+	}
+}
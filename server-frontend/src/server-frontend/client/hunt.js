@@ -24,6 +24,10 @@ var BEEPER		= false;	// periodically ring the bell
 var DRAWDBG		= false;	// log playfield rendering commands
 var INPUTDBG		= false;	// log game key events
 var PAYLOADDBG		= false;	// log payloads sent to server
+var INPUT_RETRIES	= 2;		// resend keys this many times if they may not have arrived
+var INPUT_MAX_EVENTS	= 64;		// MaxInputEvents in gamerpc, per input request
+var MAX_MATCH_TRIES	= 6;		// match again this many times while a fresh game server starts
+var GAMEDATA_WAIT	= 3000;		// ms the server waits for game data, at most MaxGameDataWait in gamerpc
 var GAMEDATADBG		= false;	// log gamedata payloads received from server
 var DATAPARSEDBG	= false;	// log commands extracted from gamedata payload
 var REPLYDBG		= false;	// log xmlhttprequest responseText
@@ -773,6 +777,7 @@ function Hunt(font, bellSrc, cols, rows) {
 	this.stringToTeam = function(str) {
 		switch(str) {
 		case "none":
+		case "auto":
 		case "0":
 		case "1":
 		case "2":
@@ -906,8 +911,14 @@ function Hunt(font, bellSrc, cols, rows) {
 	}.bind(this);
 
 	this.me = {
-		Instance:	this.hashFind("instance", "0"),
+		Instance:	this.hashFind("instance", ""),
 		PlayerID:	"",
+		Session:	"",
+		InputSeq:	0,
+		InputQueue:	[],
+		InputBusy:	false,
+		Profile:	{ CodeName: "" },
+		MatchTries:	0,
 		Name:		this.hashFind("name", ""),
 		Team:		this.stringToTeam(this.hashFind("team", "none")),
 		EnterStatus:	this.stringToEnterStatus(this.hashFind("enter", "fly"))
@@ -1078,13 +1089,66 @@ function Hunt(font, bellSrc, cols, rows) {
 			return
 		}
 
+		this.me.InputQueue.push({Keys: key, At: Date.now()});
+
+		if(!this.me.InputBusy) {
+			this.flushInput();
+		}
+	}.bind(this);
+
+	//
+	// Keys typed while an input request is in flight are queued and sent
+	// together, with their timing, once it finishes.  The game server feeds
+	// them to huntd with the same spacing, so fast typists cost fewer requests
+	// without the game feeling any different.
+	//
+	this.flushInput = function() {
+		if(this.me.PlayerID == "" || this.me.InputQueue.length == 0) {
+			return
+		}
+
+		var queue = this.me.InputQueue.splice(0, INPUT_MAX_EVENTS + 1);
+
+		var events = [];
+		for(var i = 1; i < queue.length; i++) {
+			events.push({Keys: queue[i].Keys, Offset: queue[i].At - queue[0].At});
+		}
+
+		this.me.InputSeq++;
+
 		var payload = {
 			PlayerID:	this.me.PlayerID,
-			Keys:		key
+			Keys:		queue[0].Keys,
+			Events:		events,
+			Seq:		this.me.InputSeq
 		};
 
+		this.me.InputBusy = true;
+
+		this.sendInput(payload, INPUT_RETRIES, function() {
+			this.me.InputBusy = false;
+			this.flushInput();
+		}.bind(this));
+	}.bind(this);
+
+	//
+	// The game server ignores a Seq it has already seen, so the keys can be
+	// resent whenever they may not have arrived.
+	//
+	// done is called once the keys have been sent, or given up on
+	this.sendInput = function(payload, retries, done) {
 		var xhr = new XMLHttpRequest();
 
+		var retry = function(why) {
+			if(retries <= 0 || this.me.PlayerID != payload.PlayerID) {
+				console.error("sendPlayerKey " + why + ": giving up on Seq " + payload.Seq);
+				done();
+				return
+			}
+			console.log("sendPlayerKey " + why + ": resend Seq " + payload.Seq);
+			this.sendInput(payload, retries - 1, done);
+		}.bind(this);
+
 		xhr.open("PUT", "/api/v1/input/" + this.me.Instance, true);
 
 		xhr.onload = function(e) {
@@ -1093,6 +1157,12 @@ function Hunt(font, bellSrc, cols, rows) {
 				return
 			}
 
+			if(xhr.status >= 500) {
+				retry(xhr.status + ": " + xhr.statusText);
+				return
+			}
+			done();
+
 			if(xhr.status != 200) {
 				console.error("sendPlayerKey onload: " + xhr.status + ": " + xhr.statusText);
 				return
@@ -1103,17 +1173,18 @@ function Hunt(font, bellSrc, cols, rows) {
 		}.bind(this);
 
 		xhr.onerror = function(e) {
-			console.error("sendPlayerKey onerror: " + xhr.statusText);
+			retry("onerror " + xhr.statusText);
 		}.bind(this);
 
 		xhr.ontimeout = function() {
-			console.error("sendPlayerKey request timedout");
+			retry("timedout");
 		}.bind(this);
 
 		xhr.withCredentials = true;
 		xhr.timeout = 60 * 1000;	/* ms */
 		xhr.setRequestHeader("Content-Type", "application/json;charset=utf-8");
 		xhr.setRequestHeader("Accept", "application/json;charset=utf-8");
+		xhr.setRequestHeader("Authorization", "Bearer " + this.me.Session);
 
 		if(PAYLOADDBG) {
 			console.log(payload)
@@ -1155,6 +1226,7 @@ function Hunt(font, bellSrc, cols, rows) {
 
 			var reply = JSON.parse(xhr.responseText)
 			this.me.PlayerID = ""
+			this.me.Session = ""
 		}.bind(this);
 
 		xhr.onerror = function(e) {
@@ -1169,6 +1241,7 @@ function Hunt(font, bellSrc, cols, rows) {
 		xhr.timeout = 5000;	/* ms */
 		xhr.setRequestHeader("Content-Type", "application/json;charset=utf-8");
 		xhr.setRequestHeader("Accept", "application/json;charset=utf-8");
+		xhr.setRequestHeader("Authorization", "Bearer " + this.me.Session);
 
 		if(PAYLOADDBG) {
 			console.log(payload)
@@ -1179,7 +1252,9 @@ function Hunt(font, bellSrc, cols, rows) {
 
 	this.sendGameData = function() {
 		var payload = {
-			PlayerID: this.me.PlayerID
+			PlayerID: this.me.PlayerID,
+			Wait: GAMEDATA_WAIT,
+			MinBytes: 1
 		};
 
 		var xhr = new XMLHttpRequest();
@@ -1195,24 +1270,25 @@ function Hunt(font, bellSrc, cols, rows) {
 			default:
 				console.error("onload: " + xhr.status + ": " + xhr.statusText);
 				return
+			case 204:
 			case 408:
 				/*
-				 * this means there were no game state changes for a while, so just ask again
+				 * no game state changes within the wait (408 from older frontends), so just ask again
 				 */
 				if(REPLYDBG) {
-					console.error("sendGameData onload: error " + xhr.status + ": " + xhr.statusText);
+					console.error("sendGameData onload: " + xhr.status + ": " + xhr.statusText);
 				}
 				break
 			case 200:
 				/*
-				 * got game state changes.  Process them then ask for more
+				 * got game state changes, the raw stream.  Process them then ask for more
 				 */
+				var data = new Uint8Array(xhr.response);
 				if(REPLYDBG) {
-					console.log("sendGameData onload: got '" + xhr.responseText  +"'");
+					console.log("sendGameData onload: got " + data.length + " bytes");
 				}
 
-				var data = JSON.parse(xhr.responseText)
-				this.processGameData(data)
+				this.processGameData({Data: data})
 				break
 			}
 
@@ -1230,8 +1306,10 @@ function Hunt(font, bellSrc, cols, rows) {
 
 		xhr.withCredentials = true;
 		xhr.timeout = 30*1000;	/* ms */
+		xhr.responseType = "arraybuffer";
 		xhr.setRequestHeader("Content-Type", "application/json;charset=utf-8");
-		xhr.setRequestHeader("Accept", "application/json;charset=utf-8");
+		xhr.setRequestHeader("Accept", "application/octet-stream");
+		xhr.setRequestHeader("Authorization", "Bearer " + this.me.Session);
 
 		if(PAYLOADDBG) {
 			console.log(payload)
@@ -1242,7 +1320,6 @@ function Hunt(font, bellSrc, cols, rows) {
 
 	this.makejoin = function(name, team, enterStatus, connectMode) {
 		var payload = {
-			Uid:		777,
 			Name:		name,
 			Team:		team,
 			EnterStatus:	enterStatus,
@@ -1253,6 +1330,79 @@ function Hunt(font, bellSrc, cols, rows) {
 		return payload
 	}
 
+	// asks the frontend to pick an instance, then joins it
+	this.sendMatch = function() {
+		this.me.joining = true
+
+		var payload = {
+			Team:		this.me.Team,
+		};
+
+		var xhr = new XMLHttpRequest();
+
+		xhr.open("PUT", "/api/v1/match", true);
+
+		xhr.onload = function(e) {
+			if(xhr.readyState != 4) {
+				console.log("onload: readyState " + xhr.readyState);
+				return
+			}
+
+			if(xhr.status != 200 && xhr.status != 202) {
+				this.me.joining = false
+				this.me.MatchTries = 0
+				console.error("onload: " + xhr.status + ": " + xhr.statusText);
+				this.log("No game available, try again later");
+				this.quitGame();
+				return
+			}
+
+			if(REPLYDBG) {
+				console.log("sendMatch onload: got '" + xhr.responseText  +"'");
+			}
+
+			var reply = JSON.parse(xhr.responseText)
+
+			// every game was full, a fresh one is being started
+			if(reply.Starting && this.me.MatchTries < MAX_MATCH_TRIES) {
+				this.me.MatchTries++
+				this.log("Starting a fresh game, hang on");
+				setTimeout(this.sendMatch, reply.RetryAfter * 1000);
+				return
+			}
+			this.me.MatchTries = 0
+
+			if(reply.Starting) {
+				this.me.joining = false
+				this.log("No game available, try again later");
+				this.quitGame();
+				return
+			}
+
+			this.me.Instance = reply.InstanceID
+			this.input.instance.value = this.me.Instance
+
+			this.sendJoin()
+		}.bind(this);
+
+		xhr.onerror = function(e) {
+			this.me.joining = false
+			console.error("onerror: " + xhr.statusText);
+		}.bind(this);
+
+		xhr.ontimeout = function() {
+			this.me.joining = false
+			console.error("match request timedout");
+		}.bind(this);
+
+		xhr.withCredentials = true;
+		xhr.timeout = 5000;	/* ms */
+		xhr.setRequestHeader("Content-Type", "application/json;charset=utf-8");
+		xhr.setRequestHeader("Accept", "application/json;charset=utf-8");
+
+		xhr.send(JSON.stringify(payload));
+	}.bind(this);
+
 	this.sendJoin = function() {
 		this.me.joining = true
 
@@ -1272,6 +1422,9 @@ function Hunt(font, bellSrc, cols, rows) {
 
 			if(xhr.status != 200) {
 				console.error("onload: " + xhr.status + ": " + xhr.statusText);
+				this.log("Join failed: " + xhr.responseText);
+				this.input.login.disabled = false
+				this.input.instance.disabled = false
 				return
 			}
 
@@ -1281,6 +1434,16 @@ function Hunt(font, bellSrc, cols, rows) {
 
 			var reply = JSON.parse(xhr.responseText)
 			this.me.PlayerID = reply.PlayerID
+			this.me.Session = reply.Session
+			this.me.InputSeq = 0
+			this.me.InputQueue = []
+			this.me.InputBusy = false
+
+			// remember the team and enter status with the code name they reserved
+			var profile = this.me.Profile
+			if(profile.CodeName != "" && profile.CodeName.toLowerCase() == this.me.Name.toLowerCase()) {
+				this.sendProfile(profile.CodeName, this.me.Team, this.me.EnterStatus)
+			}
 
 			this.sendGameData()
 		}.bind(this);
@@ -1419,6 +1582,91 @@ function Hunt(font, bellSrc, cols, rows) {
 		}
 	}.bind(this);
 
+	// loads the player's profile, and uses it for anything not given in the URL
+	this.sendGetProfile = function() {
+		var xhr = new XMLHttpRequest();
+
+		xhr.open("GET", "/api/v1/profile", true);
+
+		xhr.onload = function(e) {
+			if(xhr.readyState != 4) {
+				console.log("onload: readyState " + xhr.readyState);
+				return
+			}
+			if(xhr.status != 200) {
+				console.error("onload: " + xhr.status + ": " + xhr.statusText);
+				return
+			}
+
+			if(REPLYDBG) {
+				console.log("sendGetProfile onload: got '" + xhr.responseText  +"'");
+			}
+
+			this.me.Profile = JSON.parse(xhr.responseText)
+
+			if(this.hashFind("name", "") == "" && this.me.Profile.CodeName != "") {
+				this.me.Name = this.me.Profile.CodeName
+				this.input.login.value = this.me.Name
+			}
+			if(this.hashFind("team", "") == "") {
+				this.me.Team = this.stringToTeam(this.me.Profile.Team)
+				setRadioValue("game-login-team", this.me.Team);
+			}
+			if(this.hashFind("enter", "") == "") {
+				this.me.EnterStatus = this.me.Profile.EnterStatus
+				setRadioValue("game-login-estatus", this.enterStatusToString(this.me.EnterStatus));
+			}
+		}.bind(this);
+
+		xhr.withCredentials = true;
+		xhr.timeout = 5000;	/* ms */
+		xhr.setRequestHeader("Accept", "application/json;charset=utf-8");
+
+		xhr.send();
+	}.bind(this);
+
+	// saves the player's profile, reserving the code name for them
+	this.sendProfile = function(name, team, enterStatus) {
+		var payload = {
+			CodeName:	name,
+			Team:		team,
+			EnterStatus:	enterStatus,
+		};
+
+		var xhr = new XMLHttpRequest();
+
+		xhr.open("PUT", "/api/v1/profile", true);
+
+		xhr.onload = function(e) {
+			if(xhr.readyState != 4) {
+				console.log("onload: readyState " + xhr.readyState);
+				return
+			}
+			if(xhr.status != 200) {
+				console.error("onload: " + xhr.status + ": " + xhr.statusText);
+				this.log("Can't claim code name: " + xhr.responseText);
+				return
+			}
+
+			if(REPLYDBG) {
+				console.log("sendProfile onload: got '" + xhr.responseText  +"'");
+			}
+
+			this.me.Profile = JSON.parse(xhr.responseText)
+		}.bind(this);
+
+		xhr.withCredentials = true;
+		xhr.timeout = 5000;	/* ms */
+		xhr.setRequestHeader("Content-Type", "application/json;charset=utf-8");
+		xhr.setRequestHeader("Accept", "application/json;charset=utf-8");
+
+		if(PAYLOADDBG) {
+			console.log(payload)
+		}
+
+		xhr.send(JSON.stringify(payload));
+	}.bind(this);
+
 	this.sendGetInstances = function() {
 		var xhr = new XMLHttpRequest();
 
@@ -1452,6 +1700,13 @@ console.log(msg)
 						continue;
 					}
 
+					// instances that didn't answer can't be joined either
+					if(instance.hasOwnProperty("Status") && instance.Status != "ok") {
+						var s = '<pre class="VT220" style="color:' + COLOR + ';">Stats for instance ' + instance.InstanceID + ': ' + instance.Status + '</pre>\n'
+						stats = stats + s
+						continue;
+					}
+
 					var b = this.makeInstanceButton(instance.InstanceID);
 					this.input.instances.push(b);
 
@@ -1637,13 +1892,6 @@ console.log(msg)
 				this.setTeam(this.me.Team);
 				this.setEnterStatus(this.me.EnterStatus);
 
-				if(this.me.Instance == "") {
-					this.log("Instance required");
-
-					e.preventDefault();
-					return false;
-				}
-
 				if(this.me.Name == "") {
 					this.log("Name required");
 
@@ -1656,7 +1904,11 @@ console.log(msg)
 				this.input.login.blur();
 				this.input.instance.blur();
 
-				this.sendJoin();
+				if(this.me.Instance == "") {
+					this.sendMatch();
+				} else {
+					this.sendJoin();
+				}
 
 				this.input.login.disabled = true
 				this.input.instance.disabled = true
@@ -1755,6 +2007,14 @@ console.log(msg)
 		setRadioValue("game-login-team", this.me.Team);
 		setRadioValue("game-login-estatus", this.enterStatusToString(this.me.EnterStatus));
 
+		this.input.claim = document.getElementById("game-login-claim");
+		this.input.claim.onclick = function() {
+			this.me.Team = this.stringToTeam(getRadioValue("game-login-team", this.me.Team));
+			this.me.EnterStatus = this.stringToEnterStatus(getRadioValue("game-login-estatus", this.me.EnterStatus));
+			this.sendProfile(this.input.login.value, this.me.Team, this.me.EnterStatus);
+		}.bind(this);
+
+		this.sendGetProfile()
 		this.sendGetInstances()
 	}
 
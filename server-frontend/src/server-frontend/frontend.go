@@ -18,6 +18,7 @@
 package frontend
 
 import(
+	"crypto/tls"
 	"log"
 	"fmt"
 	"os"
@@ -36,13 +37,18 @@ import(
 
 const(
 	KeepAliveTimeout	= 30 * time.Second
+	InputRetries		= 2	// extra attempts for Input with a Seq that didn't reach the game server
 )
 
 var gameURLStr string
 var rpcTypeStr string
 var keepAliveTopic string
+var keepAliveSecret string
 var rpOptions = apputils.RProxyOptions(os.Getenv("SERVER_FRONTEND_RPROXY_OPTIONS"))
 var staticGameClient *gamerpc.GameClient
+var rpcSigner *apputils.RequestSigner
+var rpcTLSConfig *tls.Config
+var secureCookies bool
 
 func NewGameHandler(handler func(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -54,11 +60,9 @@ func NewGameHandler(handler func(game *gamerpc.GameClient, w http.ResponseWriter
 			return
 		}
 
-		urlstr := strings.Replace(gameURLStr, "{{instance}}", instance, -1)
-
-		game, err := FindGameInstance(r, urlstr)
+		game, err := FindGameInstance(r, instance)
 		if err != nil {
-			err = fmt.Errorf("urlstr=%s %v", err)
+			err = fmt.Errorf("instance=%s %v", instance, err)
 			apputils.InternalServerError(w, r, "no such instance", err)
 			return
 		}
@@ -71,7 +75,10 @@ func setupHandlers() {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/api/v1/keepalive",		keepaliveHandler)
+	r.HandleFunc("/api/v1/keepalive/push",		keepalivePushHandler).Methods("POST")
 	r.HandleFunc("/api/v1/instances",		instancesHandler)
+	r.HandleFunc("/api/v1/match",			matchHandler)
+	r.HandleFunc("/api/v1/profile",			profileHandler)
 	r.HandleFunc("/api/v1/stats",			allStatsHandler)
 	r.HandleFunc("/api/v1/info/{instance}",		NewGameHandler(infoHandler))
 	r.HandleFunc("/api/v1/join/{instance}",		NewGameHandler(joinHandler))
@@ -80,8 +87,15 @@ func setupHandlers() {
 	r.HandleFunc("/api/v1/quit/{instance}",		NewGameHandler(quitHandler))
 	r.HandleFunc("/api/v1/gamedata/{instance}",	NewGameHandler(gameDataHandler))
 	r.HandleFunc("/api/v1/input/{instance}",	NewGameHandler(inputHandler))
+	r.HandleFunc("/api/v1/exchange/{instance}",	NewGameHandler(exchangeHandler))
 	r.HandleFunc("/api/v1/ping/{instance}",		NewGameHandler(pingHandler))
 
+	r.HandleFunc("/api/v1/admin/players/{instance}",	NewAdminGameHandler(adminPlayersHandler)).Methods("GET")
+	r.HandleFunc("/api/v1/admin/kick/{instance}",		NewAdminGameHandler(adminKickHandler)).Methods("POST")
+	r.HandleFunc("/api/v1/admin/announce/{instance}",	NewAdminGameHandler(adminAnnounceHandler)).Methods("POST")
+	r.HandleFunc("/api/v1/admin/drain/{instance}",		NewAdminGameHandler(adminDrainHandler)).Methods("POST")
+	r.HandleFunc("/api/v1/admin/restart/{instance}",	NewAdminGameHandler(adminRestartHandler)).Methods("POST")
+
 	http.Handle("/", r)
 }
 
@@ -96,30 +110,76 @@ func init() {
 		log.Fatalf("environment variable SERVER_GAME_RPC not set")
 	}
 
+	// optional: keepalives may also be pushed over HTTP or delivered in process
 	keepAliveTopic = os.Getenv("SERVER_KEEPALIVE_TOPIC")
-	if rpcTypeStr == "" {
-		log.Fatalf("SERVER_KEEPALIVE_TOPIC not set")
+	keepAliveSecret = os.Getenv("SERVER_KEEPALIVE_SECRET")
+
+	// optional: where to ask for a fresh game server when every game is full
+	scaleURL = os.Getenv("SERVER_FRONTEND_SCALE_URL")
+
+	// builds without the appengine build tag are always standalone, App Engine builds only if asked
+	standalone := os.Getenv("SERVER_FRONTEND_STANDALONE") == "yes" || apputils.CurrentPlatform().Name != "appengine"
+	secureCookies = os.Getenv("SERVER_FRONTEND_SECURE_COOKIES") == "yes"
+	if standalone {
+		platform, err := apputils.NewStandalonePlatform(os.Getenv("SERVER_FRONTEND_APPID"), os.Getenv("SERVER_FRONTEND_STORE_DIR"))
+		if err != nil {
+			log.Fatalf("failed to create standalone platform: %v", err)
+		}
+		apputils.SetPlatform(platform)
 	}
 
-	standalone := os.Getenv("SERVER_FRONTEND_STANDALONE") == "yes"
+	// must match the keys the game servers accept, see apputils/requestauth.go
+	var err error
+	rpcSigner, err = apputils.NewRequestSigner(os.Getenv("SERVER_GAME_RPC_KEYS"))
+	if err != nil {
+		log.Fatalf("SERVER_GAME_RPC_KEYS: %v", err)
+	}
+
+	// for https game server URLs signed by a private CA, or which require client certificates
+	rpcTLSConfig, err = apputils.NewClientTLSConfig(os.Getenv("SERVER_GAME_TLS_CA"), os.Getenv("SERVER_GAME_TLS_CERT"), os.Getenv("SERVER_GAME_TLS_KEY"))
+	if err != nil {
+		log.Fatalf("game server TLS config: %v", err)
+	}
 
 	if !strings.Contains(gameURLStr, "{{instance}}") {
-		var err error
-		staticGameClient, err = gamerpc.NewGameClient(gameURLStr, rpcTypeStr, rpOptions)
+		staticGameClient, err = newGameClient(gameURLStr)
 		if err != nil {
 			log.Fatalf("failed to create static client: %v", err)
 		}
 	}
 
+	registry, err = NewInstanceRegistry(os.Getenv("SERVER_FRONTEND_REGISTRY"), os.Getenv("SERVER_FRONTEND_REGISTRY_FILE"))
+	if err != nil {
+		log.Fatalf("failed to create instance registry: %v", err)
+	}
+
+	profiles, err = NewProfileStore(os.Getenv("SERVER_FRONTEND_PROFILES"))
+	if err != nil {
+		log.Fatalf("failed to create profile store: %v", err)
+	}
+
+	initSessions()
+	initAdmin()
+
 	log.Printf("Frontend PID:   %d", os.Getpid())
 	log.Printf("Game Server:    %s", gameURLStr)
 	log.Printf("Standalone:     %v", standalone)
+	log.Printf("Platform:       %s", apputils.CurrentPlatform().Name)
 	log.Printf("KeepAliveTopic: %s", keepAliveTopic)
+	log.Printf("KeepAlivePush:  %v", keepAliveSecret != "")
+	log.Printf("ScaleURL:       %s", scaleURL)
+	log.Printf("Registry:       %T", registry)
+	log.Printf("Profiles:       %T", profiles)
+	log.Printf("AdminAPI:       %v", len(adminTokens) > 0)
+	log.Printf("SignedRPC:      %v", rpcSigner != nil)
+	log.Printf("CustomTLS:      %v", rpcTLSConfig != nil)
+	log.Printf("SecureCookies:  %v", secureCookies)
 
 	setupHandlers()
 
-	if standalone {
-		log.Fatalf("standalone mode no longer supported")
+	// there's no App Engine request context to hand keepalives delivered in process
+	if apputils.CurrentPlatform().Name != "appengine" {
+		apputils.LocalKeepAlive().Subscribe(processLocalKeepAlive)
 	}
 }
 
@@ -127,16 +187,18 @@ func init() {
  * Side effect: may modify team to meet the backend huntd protocol requirements
  */
 func CheckJoin(request *gamerpc.JoinRequest) error {
-	if request.Uid == 0 {
-		request.Uid = 31337
-	}
 	if request.Name == "" {
 		return fmt.Errorf("missing Name")
 	}
 
 	switch request.Team {
 	case "none":
-		request.Team = " "
+		request.Team = apputils.TEAM_NONE
+	case apputils.TEAM_AUTO:
+		// resolved by the game server, but messages aren't from a player on any team
+		if request.ConnectMode == gamerpc.C_MESSAGE {
+			request.Team = apputils.TEAM_NONE
+		}
 	case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
 		break
 	default:
@@ -166,6 +228,27 @@ func CheckJoin(request *gamerpc.JoinRequest) error {
 	return nil
 }
 
+//
+// Sets the request's Uid to the requesting user's, and checks they may use
+// the code name.  On failure an error response has been written and false is
+// returned.
+//
+func CheckJoinUser(w http.ResponseWriter, r *http.Request, request *gamerpc.JoinRequest) bool {
+	user, err := RequestUser(w, r)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return false
+	}
+	request.Uid = uint32(user.Uid)
+
+	err = CheckCodeName(r, user.ID, request.Name)
+	if err != nil {
+		apputils.Error(w, r, http.StatusForbidden, err.Error(), err)
+		return false
+	}
+
+	return true
+}
 
 func DecodeJoin(r io.Reader) (*gamerpc.JoinRequest, error) {
 	dec := json.NewDecoder(r)
@@ -184,6 +267,12 @@ func DecodeJoin(r io.Reader) (*gamerpc.JoinRequest, error) {
 	return &request, nil
 }
 
+// what the frontend returns from a join: the game server's reply plus a session token
+type JoinReply struct {
+	*gamerpc.JoinReply
+	Session		string
+}
+
 func joinHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
 	var err error
 
@@ -200,8 +289,22 @@ func joinHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var reply *gamerpc.JoinReply
-	reply, err = game.Join(r, request)
+	if !CheckJoinUser(w, r, request) {
+		return
+	}
+
+	var jreply *gamerpc.JoinReply
+	jreply, err = game.Join(r, request)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+
+	reply := &JoinReply{
+		JoinReply:	jreply,
+	}
+
+	reply.Session, err = NewSessionToken(mux.Vars(r)["instance"], jreply.PlayerID, time.Now())
 	if err != nil {
 		apputils.InternalServerError(w, r, err.Error(), err)
 		return
@@ -274,6 +377,10 @@ func quitHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !CheckSession(w, r, mux.Vars(r)["instance"], request.PlayerID) {
+		return
+	}
+
 	var reply *gamerpc.QuitReply
 	reply, err = game.Quit(r, request)
 	if err != nil {
@@ -298,11 +405,9 @@ func DecodeInput(r io.Reader) (*gamerpc.InputRequest, error) {
 		return nil, err
 	}
 
-	if request.PlayerID == "" {
-		return nil, fmt.Errorf("missing PlayerID")
-	}
-	if request.Keys == "" {
-		return nil, fmt.Errorf("missing Keys")
+	err = request.Validate()
+	if err != nil {
+		return nil, err
 	}
 
 	return request, nil
@@ -327,8 +432,18 @@ func inputHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !CheckSession(w, r, mux.Vars(r)["instance"], request.PlayerID) {
+		return
+	}
+
 	var reply *gamerpc.InputReply
 	reply, err = game.Input(r, request)
+
+	// with a Seq the game server ignores the keys if they got there after all
+	for attempt := 0; attempt < InputRetries && request.Seq != 0 && retryable(err); attempt++ {
+		apputils.Log(r, fmt.Sprintf("inputHandler: retry Seq %d: %v", request.Seq, err))
+		reply, err = game.Input(r, request)
+	}
 	if err != nil {
 		apputils.InternalServerError(w, r, err.Error(), err)
 		return
@@ -342,6 +457,17 @@ func inputHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// whether err means the request may not have reached the game server
+func retryable(err error) bool {
+	if err == nil || err == gamerpc.ErrCircuitOpen {
+		return false
+	}
+
+	_, answered := err.(*apputils.JsonRpcError)
+
+	return !answered
+}
+
 func DecodePing(r io.Reader) (*gamerpc.PingRequest, error) {
 	dec := json.NewDecoder(r)
 
@@ -407,6 +533,10 @@ func messageHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !CheckJoinUser(w, r, &request.Join) {
+		return
+	}
+
 	var reply *gamerpc.MessageReply
 	reply, err = game.Message(r, request)
 	if err != nil {
@@ -431,18 +561,40 @@ func DecodeGameData(r io.Reader) (*gamerpc.GameDataRequest, error) {
 		return nil, err
 	}
 
-	if request.PlayerID == "" {
-		return nil, fmt.Errorf("missing PlayerID")
+	err = request.Validate()
+	if err != nil {
+		return nil, err
 	}
 
 	return request, nil
 }
 
+// whether the client asked for the raw game data stream instead of JSON
+func acceptsOctetStream(r *http.Request) bool {
+	for _, ctype := range r.Header["Accept"] {
+		if strings.Contains(ctype, "application/octet-stream") {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// Replies with JSON, or with the game data alone if the client accepts
+// application/octet-stream: 200 with the bytes, or 204 if none arrived
+// within the wait.
+//
 func gameDataHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
-	err := httputils.RequestAcceptsJSON(r)
-	if err != nil {
-		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
-		return
+	var err error
+
+	raw := acceptsOctetStream(r)
+	if !raw {
+		err = httputils.RequestAcceptsJSON(r)
+		if err != nil {
+			apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json or application/octet-stream", err)
+			return
+		}
 	}
 	err = gamerpc.ContentTypeIsJSON(r.Header)
 	if err != nil {
@@ -457,6 +609,14 @@ func gameDataHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if !CheckSession(w, r, mux.Vars(r)["instance"], request.PlayerID) {
+		return
+	}
+
+	if raw {
+		request.Encoding = gamerpc.GAMEDATA_BYTES
+	}
+
 	var reply *gamerpc.GameDataReply
 	reply, err = game.GameData(r, request)
 	if err != nil {
@@ -464,17 +624,89 @@ func gameDataHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if reply.Timeout {
-		apputils.Error(w, r, http.StatusRequestTimeout, "Timeout waiting for game data", fmt.Errorf("%s", reply.TimeoutError))
+	// no game data within the wait isn't an error, reply.Timeout tells the client to ask again
+
+	if raw {
+		if reply.Timeout {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		err = apputils.WriteCompressed(w, r, "application/octet-stream", reply.Bytes)
+		if err != nil {
+			apputils.Log(r, fmt.Sprintf("gameDataHandler: write: %v", err))
+		}
 		return
 	}
 
-	enc := json.NewEncoder(w)
-	err = enc.Encode(reply)
+	writeCompressedJSON(w, r, reply)
+}
+
+func writeCompressedJSON(w http.ResponseWriter, r *http.Request, reply interface{}) {
+	body, err := json.Marshal(reply)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+
+	err = apputils.WriteCompressed(w, r, "application/json;charset=utf-8", append(body, '\n'))
+	if err != nil {
+		apputils.Log(r, fmt.Sprintf("write: %v", err))
+	}
+}
+
+func DecodeExchange(r io.Reader) (*gamerpc.ExchangeRequest, error) {
+	dec := json.NewDecoder(r)
+
+	var request *gamerpc.ExchangeRequest
+	err := dec.Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+//
+// Unlike gameDataHandler, no game data within Wait isn't an error.  Exchange
+// isn't retried like Input is: a lost reply would take its game data with it.
+//
+func exchangeHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+	err := httputils.RequestAcceptsJSON(r)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
+		return
+	}
+	err = gamerpc.ContentTypeIsJSON(r.Header)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	var request *gamerpc.ExchangeRequest
+	request, err = DecodeExchange(r.Body)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	if !CheckSession(w, r, mux.Vars(r)["instance"], request.PlayerID) {
+		return
+	}
+
+	var reply *gamerpc.ExchangeReply
+	reply, err = game.Exchange(r, request)
 	if err != nil {
 		apputils.InternalServerError(w, r, err.Error(), err)
 		return
 	}
+
+	writeCompressedJSON(w, r, reply)
 }
 
 func DecodeKeepalive(r io.Reader) (*gamerpc.KeepaliveRequest, error) {
@@ -489,9 +721,43 @@ func DecodeKeepalive(r io.Reader) (*gamerpc.KeepaliveRequest, error) {
 	return request, nil
 }
 
+func processKeepAlive(r *http.Request, km *apputils.KeepAliveMessage) error {
+	instance := &GameInstance{
+		InstanceID:	km.Instance,
+		URL:		km.URL.String(),
+		Hostname:	km.Hostname,
+		Seq:		km.Seq,
+		Status:		km.Status,
+	}
+
+	_, err := UpdateGameInstance(r, instance)
+
+	return err
+}
+
+//
+// Keepalives delivered by a game server running in the same process.
+// There's no incoming request, so a synthetic one is used, which only
+// works with the standalone platform.
+//
+func processLocalKeepAlive(km *apputils.KeepAliveMessage) error {
+	r, err := http.NewRequest("POST", "/api/v1/keepalive/push", nil)
+	if err != nil {
+		return err
+	}
+
+	apputils.Log(r, fmt.Sprintf("processLocalKeepAlive: %s", km.String()))
+
+	return processKeepAlive(r, km)
+}
+
 func processKeepAlives(w http.ResponseWriter, r *http.Request) (int, error) {
 	apputils.LogRequestHeaders(r, "keepalive")
 
+	if keepAliveTopic == "" {
+		return 0, nil
+	}
+
 	keepalive, err := apputils.NewKeepAlive(r, keepAliveTopic, KeepAliveTimeout)
 	if err != nil {
 		return 0, err
@@ -502,7 +768,7 @@ func processKeepAlives(w http.ResponseWriter, r *http.Request) (int, error) {
 	err = keepalive.Pull(func (km *apputils.KeepAliveMessage) error {
 		apputils.Log(r, fmt.Sprintf("processKeepAlives: Msg[%d]: %s", n, km.String()))
 
-		_, err := UpdateGameInstance(r, km.Instance, km.URL.String())
+		err := processKeepAlive(r, km)
 		if err != nil {
 			return fmt.Errorf("processKeepAlives: Msg[%d]: UpdateGameInstance: %s", n, err)
 		}
@@ -534,28 +800,38 @@ func keepaliveHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "INFO: Reaped %d instances\n", n)
 }
 
-type InstancesReply struct {
-	InstanceIDs	[]string
-}
+// Keepalives sent directly by game servers using the http transport
+func keepalivePushHandler(w http.ResponseWriter, r *http.Request) {
+	if keepAliveSecret == "" {
+		err := fmt.Errorf("SERVER_KEEPALIVE_SECRET not set")
+		apputils.Error(w, r, http.StatusForbidden, "keepalive push disabled", err)
+		return
+	}
 
-func instancesHandler(w http.ResponseWriter, r *http.Request) {
-	err := httputils.RequestAcceptsJSON(r)
-	if err != nil {
-		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
+	km, err := apputils.ReadSignedKeepAlive(r, keepAliveSecret)
+	switch err {
+	case nil:
+	case apputils.ErrBadSignature, apputils.ErrStaleSignature:
+		apputils.Error(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	case apputils.ErrBodyTooLarge:
+		apputils.Error(w, r, http.StatusRequestEntityTooLarge, err.Error(), err)
+		return
+	default:
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	var instances []*GameInstance
-	instances, err = GameInstances(r)
+	apputils.Log(r, fmt.Sprintf("keepalivePushHandler: %s", km.String()))
+
+	err = processKeepAlive(r, km)
 	if err != nil {
 		apputils.InternalServerError(w, r, err.Error(), err)
 		return
 	}
 
-	var reply = &InstancesReply{}
-
-	for _, instance := range instances {
-		reply.InstanceIDs = append(reply.InstanceIDs, instance.InstanceID)
+	reply := &gamerpc.KeepaliveReply{
+		Seq:	km.Seq,
 	}
 
 	enc := json.NewEncoder(w)
@@ -566,24 +842,41 @@ func instancesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func statsHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
+// an instance as listed by /instances
+type InstanceReply struct {
+	*GameInstance
+	Healthy		bool	// whether frontends can reach it, matchmaking skips it if not
+}
+
+type InstancesReply struct {
+	Instances	[]*InstanceReply
+}
+
+func instancesHandler(w http.ResponseWriter, r *http.Request) {
 	err := httputils.RequestAcceptsJSON(r)
 	if err != nil {
 		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
 		return
 	}
 
-	request := &gamerpc.StatsRequest {
-		Token:		123,
-	}
-
-	var reply *gamerpc.StatsReply
-	reply, err = game.Stats(r, request)
+	var instances []*GameInstance
+	instances, err = GameInstances(r)
 	if err != nil {
 		apputils.InternalServerError(w, r, err.Error(), err)
 		return
 	}
 
+	now := time.Now()
+	var reply = &InstancesReply{
+		Instances:	make([]*InstanceReply, 0, len(instances)),
+	}
+	for _, instance := range instances {
+		reply.Instances = append(reply.Instances, &InstanceReply{
+			GameInstance:	instance,
+			Healthy:	instance.Healthy(now),
+		})
+	}
+
 	enc := json.NewEncoder(w)
 	err = enc.Encode(reply)
 	if err != nil {
@@ -592,56 +885,22 @@ func statsHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Reque
 	}
 }
 
-type InstanceStatsReply struct {
-	InstanceID	string
-	Stats		string
-}
-
-type AllStatsReply struct {
-	AllStats	[]*InstanceStatsReply
-}
-
-func allStatsHandler(w http.ResponseWriter, r *http.Request) {
+func statsHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
 	err := httputils.RequestAcceptsJSON(r)
 	if err != nil {
 		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
 		return
 	}
 
-	var instances []*GameInstance
-
-	instances, err = GameInstances(r)
-	if err != nil {
-		apputils.InternalServerError(w, r, err.Error(), err)
-		return
-	}
-
 	request := &gamerpc.StatsRequest {
 		Token:		123,
 	}
 
-	reply := &AllStatsReply{}
-
-	for _, instance := range instances {
-		game, err := FindGameInstance(r, instance.URL)
-		if err != nil {
-			apputils.Log(r, fmt.Sprintf("allStatsHandler: Ignore error: FindGameInstance %s: %v", instance.URL, err))
-			continue
-		}
-
-		var stats *gamerpc.StatsReply
-		stats, err = game.Stats(r, request)
-		if err != nil {
-			apputils.Log(r, fmt.Sprintf("allStatsHandler: Ignore error: game.Stats %s: %v", instance.URL, err))
-			continue
-		}
-
-		ireply := &InstanceStatsReply {
-			InstanceID:	instance.InstanceID,
-			Stats:		stats.Stats,
-		}
-
-		reply.AllStats = append(reply.AllStats, ireply)
+	var reply *gamerpc.StatsReply
+	reply, err = game.Stats(r, request)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
 	}
 
 	enc := json.NewEncoder(w)
@@ -653,24 +912,21 @@ func allStatsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func infoHandler(game *gamerpc.GameClient, w http.ResponseWriter, r *http.Request) {
-	cfg := &apputils.RProxyConfig{
-		Copy:		[]string{"Cookie", "Accept", "Content-Type"},
-		Add:		[]*apputils.Header{
-					&apputils.Header{Key: "REDACTED", Value: "REDACTED"},
-				},
-		Options:	rpOptions,
+	err := httputils.RequestAcceptsJSON(r)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
+		return
 	}
 
-	u := *game.URL
-	u.Path = "/info"
-
-	buf, err := apputils.RProxy(r, cfg, r.Method, &u, nil)
+	var info *gamerpc.ServerInfo
+	info, err = game.Info(r)
 	if err != nil {
 		apputils.InternalServerError(w, r, "request failed due to internal error", err)
 		return
 	}
 
-	_, err = w.Write(buf)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(info)
 	if err != nil {
 		apputils.InternalServerError(w, r, err.Error(), err)
 		return
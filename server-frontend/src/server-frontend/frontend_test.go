@@ -0,0 +1,320 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"apputils"
+	"gamerpc"
+
+	grpc "github.com/gorilla/rpc"
+	gjson "github.com/gorilla/rpc/json"
+)
+
+//
+// The frontend configures itself from the environment in init, which runs
+// after package variables like this one are initialized.
+//
+var _ = testEnvironment()
+
+func testEnvironment() bool {
+	env := map[string]string{
+		"SERVER_GAME_URL":			"http://localhost:1/jsonrpc?{{instance}}",
+		"SERVER_GAME_RPC":			"jsonrpc",
+		"SERVER_FRONTEND_STANDALONE":		"yes",
+		"SERVER_FRONTEND_REGISTRY":		"memory",
+		"SERVER_FRONTEND_SESSION_SECRET":	"test-session-secret",
+		"SERVER_FRONTEND_ADMIN_TOKENS":		"test-admin-token",
+	}
+
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	return true
+}
+
+func testRequest() *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	return r
+}
+
+// a fresh registry holding the given instances, seen just now
+func testRegistry(instances ...*GameInstance) {
+	registry = NewMemoryRegistry()
+
+	for _, instance := range instances {
+		if instance.URL == "" {
+			instance.URL = "http://localhost:1/jsonrpc?" + instance.InstanceID
+		}
+		registry.Update(testRequest(), instance)
+	}
+}
+
+// a fresh in-memory standalone platform for the rest of the test
+func testPlatform(t *testing.T) *apputils.Platform {
+	platform, err := apputils.NewStandalonePlatform("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := apputils.CurrentPlatform()
+	apputils.SetPlatform(platform)
+	t.Cleanup(func() { apputils.SetPlatform(saved) })
+
+	return platform
+}
+
+//
+// Answers the game server RPCs the frontend makes on behalf of operators,
+// remembering the requests.  Named like the game's service so the calls
+// reach it.
+//
+type HuntDaemon struct {
+	lock		sync.Mutex
+	requests	[]interface{}
+	statsDelay	time.Duration
+	gameData	[]byte		// sent in answer to the next GameData
+}
+
+func (huntd *HuntDaemon) called(req interface{}) {
+	huntd.lock.Lock()
+	huntd.requests = append(huntd.requests, req)
+	huntd.lock.Unlock()
+}
+
+func (huntd *HuntDaemon) Requests() []interface{} {
+	huntd.lock.Lock()
+	defer huntd.lock.Unlock()
+
+	return append([]interface{}(nil), huntd.requests...)
+}
+
+func (huntd *HuntDaemon) JListPlayers(r *http.Request, req *gamerpc.PlayersRequest, reply *gamerpc.PlayersReply) error {
+	huntd.called(req)
+	reply.Players = []*gamerpc.PlayerInfo{{PlayerID: "1", Name: "bob"}}
+	return nil
+}
+
+func (huntd *HuntDaemon) JKick(r *http.Request, req *gamerpc.KickRequest, reply *gamerpc.KickReply) error {
+	huntd.called(req)
+	return nil
+}
+
+func (huntd *HuntDaemon) JMessage(r *http.Request, req *gamerpc.MessageRequest, reply *gamerpc.MessageReply) error {
+	huntd.called(req)
+	return nil
+}
+
+func (huntd *HuntDaemon) JDrain(r *http.Request, req *gamerpc.DrainRequest, reply *gamerpc.DrainReply) error {
+	huntd.called(req)
+	reply.Draining = req.Draining
+	return nil
+}
+
+func (huntd *HuntDaemon) JRestart(r *http.Request, req *gamerpc.RestartRequest, reply *gamerpc.RestartReply) error {
+	huntd.called(req)
+	return nil
+}
+
+func (huntd *HuntDaemon) JStats(r *http.Request, req *gamerpc.StatsRequest, reply *gamerpc.StatsReply) error {
+	huntd.called(req)
+	time.Sleep(huntd.statsDelay)
+	reply.Stats = "stats from " + r.URL.RawQuery
+	return nil
+}
+
+func (huntd *HuntDaemon) JGameData(r *http.Request, req *gamerpc.GameDataRequest, reply *gamerpc.GameDataReply) error {
+	huntd.called(req)
+
+	huntd.lock.Lock()
+	data := huntd.gameData
+	huntd.gameData = nil
+	huntd.lock.Unlock()
+
+	reply.Timeout = len(data) == 0
+	reply.Data, reply.Bytes = gamerpc.PackGameData(req.Encoding, data)
+	return nil
+}
+
+// an instance served by huntd, until the test ends
+func testGameServer(t *testing.T, id string, huntd *HuntDaemon) *GameInstance {
+	s := grpc.NewServer()
+	s.RegisterCodec(gjson.NewCodec(), "application/json")
+	err := s.RegisterService(huntd, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	instance := testInstance(id, 1, 25, nil)
+	instance.URL = srv.URL + "/jsonrpc?" + id
+
+	return instance
+}
+
+func testInstance(id string, players int, maxPlayers int, teams map[string]int) *GameInstance {
+	return &GameInstance{
+		InstanceID:	id,
+		Status:		&apputils.InstanceStatus{
+					Players:	players,
+					MaxPlayers:	maxPlayers,
+					Rooms:		[]*apputils.RoomStatus{
+								{RoomID: "0", Players: players, MaxPlayers: maxPlayers, Teams: teams},
+							},
+				},
+	}
+}
+
+func TestInstancesListsUnhealthy(t *testing.T) {
+	testRegistry(testInstance("0", 1, 10, nil), testInstance("1", 2, 10, nil))
+	registry.MarkUnhealthy(testRequest(), "1", time.Now().Add(time.Minute))
+
+	r := testRequest()
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	instancesHandler(w, r)
+
+	var reply struct {
+		Instances	[]struct {
+			InstanceID	string
+			Healthy		bool
+		}
+	}
+	err := json.NewDecoder(w.Body).Decode(&reply)
+	if err != nil {
+		t.Fatalf("decode %d reply: %v", w.Code, err)
+	}
+
+	healthy := make(map[string]bool)
+	for _, instance := range reply.Instances {
+		healthy[instance.InstanceID] = instance.Healthy
+	}
+	if len(healthy) != 2 || !healthy["0"] || healthy["1"] {
+		t.Errorf("instances = %+v, want 0 healthy and 1 not", reply.Instances)
+	}
+}
+
+func TestLocalKeepAlive(t *testing.T) {
+	testRegistry()
+
+	u, _ := url.Parse("http://game:12345/jsonrpc")
+	km := &apputils.KeepAliveMessage{Hostname: "host", Instance: "7", Seq: 3, URL: u}
+
+	err := apputils.LocalKeepAlive().Send(km)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	instance, err := registry.Find(testRequest(), "7")
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if instance.URL != u.String() || instance.Seq != 3 {
+		t.Errorf("registered %+v", instance)
+	}
+}
+
+// a game data request from player 42 of instance 3, routed like the real thing
+func gameDataRequest(t *testing.T, accept string, body string) *httptest.ResponseRecorder {
+	token, err := NewSessionToken("3", "42", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/v1/gamedata/3", strings.NewReader(body))
+	r.Header.Set("Accept", accept)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer " + token)
+
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestGameDataEncodings(t *testing.T) {
+	huntd := &HuntDaemon{}
+	testRegistry(testGameServer(t, "3", huntd))
+
+	screen := bytes.Repeat([]byte("\x01\x02hunt"), 100)
+
+	// raw: the bytes themselves, gzipped as they're big enough
+	huntd.gameData = screen
+	w := gameDataRequest(t, "application/octet-stream", `{"PlayerID": "42"}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("raw: %d %v", w.Code, w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(gz)
+	if !bytes.Equal(got, screen) {
+		t.Errorf("raw: got %d bytes, want %d", len(got), len(screen))
+	}
+
+	// raw, nothing within the wait
+	w = gameDataRequest(t, "application/octet-stream", `{"PlayerID": "42"}`)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("raw timeout: %d %q", w.Code, w.Body)
+	}
+
+	// JSON, in whichever encoding was asked for
+	for _, encoding := range []string{gamerpc.GAMEDATA_UINT32, gamerpc.GAMEDATA_BYTES} {
+		huntd.gameData = []byte("hi")
+		w = gameDataRequest(t, "application/json", `{"PlayerID": "42", "Encoding": "` + encoding + `"}`)
+
+		var reply gamerpc.GameDataReply
+		err = json.Unmarshal(w.Body.Bytes(), &reply)
+		if w.Code != http.StatusOK || err != nil || reply.Timeout {
+			t.Fatalf("JSON %q: %d %s", encoding, w.Code, w.Body)
+		}
+		if encoding == gamerpc.GAMEDATA_BYTES && (string(reply.Bytes) != "hi" || reply.Data != nil) {
+			t.Errorf("JSON %q: got %+v", encoding, reply)
+		}
+		if encoding == gamerpc.GAMEDATA_UINT32 && (len(reply.Data) != 2 || reply.Data[0] != 'h' || reply.Bytes != nil) {
+			t.Errorf("JSON %q: got %+v", encoding, reply)
+		}
+	}
+
+	// the raw stream always asks the game server for bytes
+	requests := huntd.Requests()
+	if req := requests[0].(*gamerpc.GameDataRequest); req.Encoding != gamerpc.GAMEDATA_BYTES {
+		t.Errorf("raw request asked for Encoding %q", req.Encoding)
+	}
+
+	w = gameDataRequest(t, "application/json", `{"PlayerID": "42", "Encoding": "hex"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown Encoding: %d", w.Code)
+	}
+}
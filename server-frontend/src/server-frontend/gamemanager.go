@@ -20,120 +20,115 @@ package frontend
 import(
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"apputils"
 	"gamerpc"
-
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/memcache"
-	"google.golang.org/appengine/datastore"
 )
 
 const(
 	GameInstanceTimeout	= 2 * time.Minute
 )
 
-// TODO(tad): this is here because Datastore can't handle the types in a gamerpc.GameClient.  Ugh.
-type DatastoreGameInfo struct {
-	URL	string
-}
-
-type GameInstance struct {
-	InstanceID	string
-	URL		string
-}
+var registry InstanceRegistry
 
-func FindGameInstance(r *http.Request, urlstr string) (*gamerpc.GameClient, error) {
-	if staticGameClient != nil {
-		return staticGameClient, nil
-	}
+// game clients are cached by URL so they aren't recreated on every request
+var gameClientsLock sync.Mutex
+var gameClients = make(map[string]*gamerpc.GameClient)
 
-	ctx := appengine.NewContext(r)
-	game := gamerpc.GameClient{}
-	_, err := memcache.JSON.Get(ctx, urlstr, &game)
+// a client with the frontend wide request signing and TLS settings
+func newGameClient(urlstr string) (*gamerpc.GameClient, error) {
+	game, err := gamerpc.NewGameClient(urlstr, rpcTypeStr, rpOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	return &game, nil
+	game.Signer = rpcSigner
+	game.SetTLSConfig(rpcTLSConfig)
+
+	game.Breaker = gamerpc.NewBreaker(gamerpc.DefaultBreakerThreshold, gamerpc.DefaultBreakerCooldown)
+	game.Breaker.OnChange = func(r *http.Request, open bool, until time.Time) {
+		markInstances(r, urlstr, open, until)
+	}
+
+	return game, nil
 }
 
-func UpdateGameInstance(r *http.Request, instance string, urlstr string) (*gamerpc.GameClient, error) {
-	ctx := appengine.NewContext(r)
+//
+// Reports the instances at urlstr as unhealthy until the given time, or as
+// healthy again, so that matchmaking skips them.
+//
+func markInstances(r *http.Request, urlstr string, open bool, until time.Time) {
+	apputils.Log(r, fmt.Sprintf("markInstances: %s: unreachable %v until %v", urlstr, open, until))
 
-	game, err := gamerpc.NewGameClient(urlstr, rpcTypeStr, rpOptions)
+	instances, err := registry.List(r)
 	if err != nil {
-		return nil, err
+		apputils.Log(r, fmt.Sprintf("markInstances: Ignore error listing instances: %v", err))
+		return
 	}
 
-	item := &memcache.Item {
-		Key:		urlstr,
-		Object:		game,
-		Expiration:     GameInstanceTimeout,
-	}
+	for _, instance := range instances {
+		if instance.URL != urlstr {
+			continue
+		}
 
-	// NOTE: we don't bother with CAS because it doesn't really matter who wins
-	err = memcache.JSON.Set(ctx, item)
-	if err != nil {
-		return nil, err
+		err = registry.MarkUnhealthy(r, instance.InstanceID, until)
+		if err != nil {
+			apputils.Log(r, fmt.Sprintf("markInstances: Ignore error marking %s: %v", instance.InstanceID, err))
+		}
 	}
+}
 
-	apputils.Log(r, fmt.Sprintf("UpdateGameInstance[%s]: Memcache updated with %s: %v", instance, urlstr, item))
+func gameClient(urlstr string) (*gamerpc.GameClient, error) {
+	gameClientsLock.Lock()
+	defer gameClientsLock.Unlock()
 
-	dgame := &DatastoreGameInfo {
-		URL: urlstr,
+	game, found := gameClients[urlstr]
+	if found {
+		return game, nil
 	}
 
-	instanceKey := datastore.NewKey(ctx, "instances", instance, 0, nil)
-	key, err := datastore.Put(ctx, instanceKey, dgame)
+	game, err := newGameClient(urlstr)
 	if err != nil {
 		return nil, err
 	}
-
-	apputils.Log(r, fmt.Sprintf("UpdateGameInstance[%s]: Datastore updated with key %s gameserver %s", instance, key, dgame.URL))
+	gameClients[urlstr] = game
 
 	return game, nil
 }
 
-//
-// Deletes all instances found in the database that aren't in memcache.
-// This technique is used because memcache is setup to expire the instances
-// if not heard from for too long.
-//
-func ReapGameInstances(r *http.Request) (int, error) {
-	apputils.Log(r, "ReapGameInstances: start")
+func FindGameInstance(r *http.Request, instanceID string) (*gamerpc.GameClient, error) {
+	if staticGameClient != nil {
+		return staticGameClient, nil
+	}
 
-	ctx := appengine.NewContext(r)
-	query := datastore.NewQuery("instances")
+	instance, err := registry.Find(r, instanceID)
+	if err != nil {
+		return nil, err
+	}
 
-	var urls []*DatastoreGameInfo
+	return gameClient(instance.URL)
+}
 
-	keys, err := query.GetAll(ctx, &urls)
+func UpdateGameInstance(r *http.Request, instance *GameInstance) (*gamerpc.GameClient, error) {
+	game, err := gameClient(instance.URL)
 	if err != nil {
-		apputils.Log(r, fmt.Sprintf("Ignore error finding game instances to reap: %v", err))
-		return 0, nil
+		return nil, err
 	}
 
-	n := 0
-	for i, dgame := range urls {
-		apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Look for key %s url %s", i, keys[i], dgame.URL))
-		_, err := FindGameInstance(r, dgame.URL)
-		if err == nil {
-			apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Keep: key %s url %s still alive", i, keys[i], dgame.URL))
-			continue
-		}
-
-		err = datastore.Delete(ctx, keys[i])
-		if err != nil {
-			apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Ignore error deleting key %s url %s: %v", i, keys[i], dgame.URL, err))
-		} else {
-			apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Deleted key %s game %s", i, keys[i], dgame.URL))
-			n++
-		}
+	err = registry.Update(r, instance)
+	if err != nil {
+		return nil, err
 	}
 
-	return n, nil
+	return game, nil
+}
+
+func ReapGameInstances(r *http.Request) (int, error) {
+	apputils.Log(r, "ReapGameInstances: start")
+
+	return registry.Reap(r)
 }
 
 func GameInstances(r *http.Request) ([]*GameInstance, error) {
@@ -143,28 +138,11 @@ func GameInstances(r *http.Request) ([]*GameInstance, error) {
 	if staticGameClient != nil {
 		instances = append(instances, &GameInstance{InstanceID: "0", URL:gameURLStr})
 	} else {
-		ctx := appengine.NewContext(r)
-		query := datastore.NewQuery("instances")
-
-		var gameinfo []*DatastoreGameInfo
-		keys, err := query.GetAll(ctx, &gameinfo)
+		var err error
+		instances, err = registry.List(r)
 		if err != nil {
 			return nil, err
 		}
-
-		for i, key := range keys {
-			id := key.StringID()
-			if id == "" {
-				apputils.Log(r, fmt.Sprintf("Key %v missing stringid", key))
-				continue
-			}
-
-			instance := &GameInstance{
-				InstanceID:	id,
-				URL:		gameinfo[i].URL,
-			}
-			instances = append(instances, instance)
-		}
 	}
 
 	apputils.Log(r, fmt.Sprintf("GameInstances: return %d instances", len(instances)))
@@ -0,0 +1,455 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Matchmaking picks the game a player should join, using the status
+// reported by each instance with its keepalives.  In order of preference:
+//
+//	the instance the rest of the player's party was sent to
+//	a game with players in it that isn't full, preferring ones where the
+//	player's team is already playing, then the most populated
+//	an empty game, i.e. a fresh one
+//	an instance which hasn't reported its status yet
+//	a fresh game server, started on request
+//
+// Draining instances, games whose team policy would turn the player away,
+// and, for a party's first member, games without space for the whole party
+// are never chosen.  Each game server runs a single huntd, so when nothing
+// fits a fresh room means a fresh game server: Match POSTs a ScaleRequest to
+// SERVER_FRONTEND_SCALE_URL, at most once per ScaleInterval, and asks the
+// player to match again once it has had time to start.  Without a scale URL
+// Match fails with ErrNoCapacity.
+package frontend
+
+import(
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"apputils"
+
+	"github.com/tadhunt/httputils"
+)
+
+const(
+	PartyTimeout	= 10 * time.Minute	// how long a party is remembered after its game was picked
+	MaxPartySize	= 10
+	ScaleInterval	= time.Minute		// how often a fresh game server may be asked for
+	ScaleRetryAfter	= 10			// seconds until a player waiting for a fresh game server matches again
+)
+
+const scaleCacheKey = "scale"
+
+var ErrNoCapacity = errors.New("no game instance has room")
+
+var scaleURL string	// where fresh game servers are asked for, see ScaleRequest
+
+type MatchRequest struct {
+	Token		int
+	PartyID		string	// players with the same PartyID are sent to the same game
+	PartySize	int	// number of players joining together, defaults to 1
+	Team		string	// "0" .. "9", "none" or "auto"
+}
+
+type MatchReply struct {
+	Token		int
+	InstanceID	string	// to join with /api/v1/join/{instance}
+	Fresh		bool	// the game has nobody in it yet
+	Starting	bool	// no game had room, match again after RetryAfter seconds
+	RetryAfter	int
+}
+
+//
+// POSTed to SERVER_FRONTEND_SCALE_URL when no game has room for a player, for
+// whatever manages the deployment to start another game server.  It's signed
+// like game server RPCs when SERVER_GAME_RPC_KEYS is set.
+//
+type ScaleRequest struct {
+	PartySize	int
+	Team		string
+}
+
+type matchCandidate struct {
+	instance	*GameInstance
+	room		*apputils.RoomStatus	// nil if the instance hasn't reported its status
+}
+
+func partyCacheKey(partyID string) string {
+	return "party:" + partyID
+}
+
+func (c *matchCandidate) free() int {
+	return c.room.MaxPlayers - c.room.Players
+}
+
+func (c *matchCandidate) reply(token int) *MatchReply {
+	return &MatchReply{
+		Token:		token,
+		InstanceID:	c.instance.InstanceID,
+		Fresh:		c.room == nil || c.room.Players == 0,
+	}
+}
+
+// orders candidates which have players in them: best first
+type byPreference struct {
+	candidates	[]*matchCandidate
+	team		string
+}
+
+func (p *byPreference) Len() int {
+	return len(p.candidates)
+}
+
+func (p *byPreference) Swap(i int, j int) {
+	p.candidates[i], p.candidates[j] = p.candidates[j], p.candidates[i]
+}
+
+func (p *byPreference) Less(i int, j int) bool {
+	a := p.candidates[i]
+	b := p.candidates[j]
+	team := p.team
+
+	ateam := a.room.Teams[team] > 0
+	bteam := b.room.Teams[team] > 0
+	if ateam != bteam {
+		return ateam
+	}
+
+	if a.room.Players != b.room.Players {
+		return a.room.Players > b.room.Players
+	}
+
+	return a.instance.InstanceID < b.instance.InstanceID
+}
+
+// whether the instance's team policy would let the player in
+func teamAllowed(policy *apputils.TeamPolicy, team string, room *apputils.RoomStatus) bool {
+	if policy == nil {
+		return true
+	}
+
+	if team == "none" {
+		team = apputils.TEAM_NONE
+	}
+
+	_, err := policy.Assign(team, room.Teams)
+
+	return err == nil
+}
+
+func matchCandidates(instances []*GameInstance, partySize int, team string, now time.Time) (populated []*matchCandidate, empty []*matchCandidate, unknown []*matchCandidate) {
+	for _, instance := range instances {
+		if !instance.Alive(now) || !instance.Healthy(now) {
+			continue
+		}
+
+		status := instance.Status
+		if status == nil {
+			unknown = append(unknown, &matchCandidate{instance: instance})
+			continue
+		}
+
+		if status.Draining {
+			continue
+		}
+
+		for _, room := range status.Rooms {
+			c := &matchCandidate{instance: instance, room: room}
+			if c.free() < partySize || !teamAllowed(status.TeamPolicy, team, room) {
+				continue
+			}
+
+			if room.Players == 0 {
+				empty = append(empty, c)
+			} else {
+				populated = append(populated, c)
+			}
+		}
+	}
+
+	return populated, empty, unknown
+}
+
+func Match(r *http.Request, req *MatchRequest) (*MatchReply, error) {
+	if staticGameClient != nil {
+		return &MatchReply{Token: req.Token, InstanceID: "0"}, nil
+	}
+
+	instances, err := registry.List(r)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	partyInstance, err := partyInstanceID(r, req.PartyID)
+	if err != nil {
+		return nil, err
+	}
+
+	choice := partyChoice(instances, req, partyInstance, now)
+	if choice == nil {
+		choice = bestChoice(instances, req, now)
+		if choice == nil {
+			return startFreshRoom(r, req)
+		}
+
+		choice = rememberParty(r, instances, req, partyInstance, choice, now)
+	}
+
+	reply := choice.reply(req.Token)
+
+	apputils.Log(r, fmt.Sprintf("Match: party %s size %d team %s: instance %s fresh %v",
+		req.PartyID, req.PartySize, req.Team, reply.InstanceID, reply.Fresh))
+
+	return reply, nil
+}
+
+func bestChoice(instances []*GameInstance, req *MatchRequest, now time.Time) *matchCandidate {
+	populated, empty, unknown := matchCandidates(instances, req.PartySize, req.Team, now)
+
+	if len(populated) > 0 {
+		sort.Sort(&byPreference{candidates: populated, team: req.Team})
+		return populated[0]
+	}
+
+	if len(empty) > 0 {
+		return empty[0]
+	}
+
+	if len(unknown) > 0 {
+		return unknown[0]
+	}
+
+	return nil
+}
+
+// the instance the party was sent to, or "" if there's no party or it's been forgotten
+func partyInstanceID(r *http.Request, partyID string) (string, error) {
+	if partyID == "" {
+		return "", nil
+	}
+
+	var instanceID string
+	err := apputils.CurrentPlatform().Cache.Get(r, partyCacheKey(partyID), &instanceID)
+	if err == apputils.ErrCacheMiss {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return instanceID, nil
+}
+
+//
+// The instance the player's party was sent to, if it can still take the
+// player.  Space for the whole party was checked when its first member was
+// matched, so later members only need a place of their own.
+//
+func partyChoice(instances []*GameInstance, req *MatchRequest, partyInstance string, now time.Time) *matchCandidate {
+	if partyInstance == "" {
+		return nil
+	}
+
+	populated, empty, unknown := matchCandidates(instances, 1, req.Team, now)
+	for _, c := range append(append(populated, empty...), unknown...) {
+		if c.instance.InstanceID == partyInstance {
+			return c
+		}
+	}
+
+	return nil
+}
+
+//
+// Sends the party to choice.  Members of a new party can be matched at the
+// same time by different frontends, so the first one to store its choice
+// wins and the others follow it.  A party whose game can't take it any more
+// is moved to choice.
+//
+func rememberParty(r *http.Request, instances []*GameInstance, req *MatchRequest, partyInstance string, choice *matchCandidate, now time.Time) *matchCandidate {
+	if req.PartyID == "" {
+		return choice
+	}
+
+	cache := apputils.CurrentPlatform().Cache
+	key := partyCacheKey(req.PartyID)
+
+	var err error
+	if partyInstance != "" {
+		err = cache.Set(r, key, choice.instance.InstanceID, PartyTimeout)
+	} else {
+		err = cache.Add(r, key, choice.instance.InstanceID, PartyTimeout)
+	}
+
+	if err == apputils.ErrNotStored {
+		partyInstance, err = partyInstanceID(r, req.PartyID)
+		if err == nil {
+			c := partyChoice(instances, req, partyInstance, now)
+			if c != nil {
+				return c
+			}
+		}
+	}
+
+	if err != nil {
+		apputils.Log(r, fmt.Sprintf("Match: Ignore error remembering party %s: %v", req.PartyID, err))
+	}
+
+	return choice
+}
+
+//
+// Asks for a fresh game server, unless one was asked for recently, and tells
+// the player to match again once it has had time to start.
+//
+func startFreshRoom(r *http.Request, req *MatchRequest) (*MatchReply, error) {
+	if scaleURL == "" {
+		return nil, ErrNoCapacity
+	}
+
+	reply := &MatchReply{
+		Token:		req.Token,
+		Starting:	true,
+		RetryAfter:	ScaleRetryAfter,
+	}
+
+	cache := apputils.CurrentPlatform().Cache
+	err := cache.Add(r, scaleCacheKey, time.Now(), ScaleInterval)
+	if err == apputils.ErrNotStored {
+		apputils.Log(r, fmt.Sprintf("Match: party %s size %d team %s: waiting for a fresh game server", req.PartyID, req.PartySize, req.Team))
+		return reply, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = requestScale(r, &ScaleRequest{PartySize: req.PartySize, Team: req.Team})
+	if err != nil {
+		cache.Delete(r, scaleCacheKey)
+		return nil, fmt.Errorf("failed to start a fresh game server: %v", err)
+	}
+
+	apputils.Log(r, fmt.Sprintf("Match: party %s size %d team %s: asked for a fresh game server", req.PartyID, req.PartySize, req.Team))
+
+	return reply, nil
+}
+
+func requestScale(r *http.Request, scale *ScaleRequest) error {
+	body, err := json.Marshal(scale)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", scaleURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+
+	if rpcSigner != nil {
+		err = rpcSigner.Sign(req, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	rsp, err := apputils.HTTPClient(r).Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode / 100 != 2 {
+		data, _ := ioutil.ReadAll(rsp.Body)
+		return fmt.Errorf("HTTP Error %d: %s", rsp.StatusCode, string(data))
+	}
+
+	return nil
+}
+
+func DecodeMatch(r io.Reader) (*MatchRequest, error) {
+	dec := json.NewDecoder(r)
+
+	var request MatchRequest
+	err := dec.Decode(&request)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if request.PartySize == 0 {
+		request.PartySize = 1
+	}
+	if request.PartySize < 0 || request.PartySize > MaxPartySize {
+		return nil, fmt.Errorf("bad PartySize")
+	}
+
+	switch request.Team {
+	case "":
+		request.Team = "none"
+	case "none", apputils.TEAM_AUTO, "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		break
+	default:
+		return nil, fmt.Errorf("bad Team")
+	}
+
+	return &request, nil
+}
+
+func matchHandler(w http.ResponseWriter, r *http.Request) {
+	err := httputils.RequestAcceptsJSON(r)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
+		return
+	}
+
+	var request *MatchRequest
+	request, err = DecodeMatch(r.Body)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	var reply *MatchReply
+	reply, err = Match(r, request)
+	if err == ErrNoCapacity {
+		apputils.Error(w, r, http.StatusServiceUnavailable, err.Error(), err)
+		return
+	}
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+
+	if reply.Starting {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", reply.RetryAfter))
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(reply)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"apputils"
+)
+
+func match(t *testing.T, req *MatchRequest) *MatchReply {
+	reply, err := Match(testRequest(), req)
+	if err != nil {
+		t.Fatalf("Match %+v: %v", req, err)
+	}
+
+	return reply
+}
+
+func TestMatchPrefersPopulatedGames(t *testing.T) {
+	testRegistry(
+		testInstance("empty", 0, 10, nil),
+		testInstance("few", 2, 10, map[string]int{"none": 2}),
+		testInstance("many", 7, 10, map[string]int{"none": 7}),
+		testInstance("full", 10, 10, map[string]int{"none": 10}),
+	)
+
+	reply := match(t, &MatchRequest{PartySize: 1, Team: "none"})
+	if reply.InstanceID != "many" || reply.Fresh {
+		t.Fatalf("got %+v, want the most populated game that isn't full", reply)
+	}
+
+	// a team goes where it's already playing
+	testRegistry(
+		testInstance("many", 7, 10, map[string]int{"none": 7}),
+		testInstance("team", 2, 10, map[string]int{"3": 2}),
+	)
+	reply = match(t, &MatchRequest{PartySize: 1, Team: "3"})
+	if reply.InstanceID != "team" {
+		t.Fatalf("got %+v, want the game team 3 is in", reply)
+	}
+}
+
+func TestMatchSkipsDrainingAndUnhealthy(t *testing.T) {
+	draining := testInstance("draining", 5, 10, nil)
+	draining.Status.Draining = true
+	unhealthy := testInstance("unhealthy", 5, 10, nil)
+
+	testRegistry(draining, unhealthy, testInstance("empty", 0, 10, nil))
+	registry.MarkUnhealthy(testRequest(), "unhealthy", time.Now().Add(time.Minute))
+
+	reply := match(t, &MatchRequest{PartySize: 1, Team: "none"})
+	if reply.InstanceID != "empty" || !reply.Fresh {
+		t.Fatalf("got %+v, want the empty game", reply)
+	}
+}
+
+func TestMatchNoCapacity(t *testing.T) {
+	testRegistry(testInstance("full", 10, 10, nil))
+
+	_, err := Match(testRequest(), &MatchRequest{PartySize: 1, Team: "none"})
+	if err != ErrNoCapacity {
+		t.Fatalf("got %v, want ErrNoCapacity", err)
+	}
+}
+
+func TestMatchStartsFreshRoom(t *testing.T) {
+	testPlatform(t)
+	testRegistry(testInstance("full", 10, 10, nil))
+
+	var lock sync.Mutex
+	var scales []*ScaleRequest
+	scaler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var scale ScaleRequest
+		json.NewDecoder(r.Body).Decode(&scale)
+
+		lock.Lock()
+		scales = append(scales, &scale)
+		lock.Unlock()
+	}))
+	defer scaler.Close()
+
+	scaleURL = scaler.URL
+	defer func() { scaleURL = "" }()
+
+	// every player waiting for it is told to come back, but only one game server is asked for
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("PUT", "/api/v1/match", strings.NewReader(`{"PartySize": 3, "Team": "2"}`))
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		matchHandler(w, r)
+
+		var reply MatchReply
+		json.NewDecoder(w.Body).Decode(&reply)
+		if w.Code != http.StatusAccepted || w.Header().Get("Retry-After") != "10" || !reply.Starting || reply.InstanceID != "" {
+			t.Fatalf("match %d: got %d %+v, want 202 and a fresh game server starting", i, w.Code, reply)
+		}
+	}
+
+	if len(scales) != 1 || scales[0].PartySize != 3 || scales[0].Team != "2" {
+		t.Fatalf("scale requests %+v, want one for the party", scales)
+	}
+
+	// once it has started the players are sent to it
+	testRegistry(testInstance("full", 10, 10, nil), testInstance("fresh", 0, 10, nil))
+	reply := match(t, &MatchRequest{PartySize: 3, Team: "2"})
+	if reply.InstanceID != "fresh" || !reply.Fresh || reply.Starting {
+		t.Fatalf("got %+v, want the fresh game server", reply)
+	}
+}
+
+func TestMatchFreshRoomFailure(t *testing.T) {
+	testPlatform(t)
+	testRegistry(testInstance("full", 10, 10, nil))
+
+	scaler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusForbidden)
+	}))
+	defer scaler.Close()
+
+	scaleURL = scaler.URL
+	defer func() { scaleURL = "" }()
+
+	_, err := Match(testRequest(), &MatchRequest{PartySize: 1, Team: "none"})
+	if err == nil || err == ErrNoCapacity {
+		t.Fatalf("got %v, want the scale request's error", err)
+	}
+
+	// and the next player asks again
+	var at time.Time
+	err = apputils.CurrentPlatform().Cache.Get(testRequest(), scaleCacheKey, &at)
+	if err != apputils.ErrCacheMiss {
+		t.Fatalf("failed scale request still remembered: %v", err)
+	}
+}
+
+func TestMatchPartyCapacityCheckedOnce(t *testing.T) {
+	testRegistry(
+		testInstance("small", 6, 10, nil),
+		testInstance("big", 1, 20, nil),
+	)
+
+	// the first member needs room for all four
+	reply := match(t, &MatchRequest{PartyID: "p", PartySize: 4, Team: "none"})
+	if reply.InstanceID != "small" {
+		t.Fatalf("first member: got %+v, want small", reply)
+	}
+
+	// the first three have joined, the last still fits even though four don't
+	testRegistry(
+		testInstance("small", 9, 10, nil),
+		testInstance("big", 1, 20, nil),
+	)
+	reply = match(t, &MatchRequest{PartyID: "p", PartySize: 4, Team: "none"})
+	if reply.InstanceID != "small" {
+		t.Fatalf("last member: got %+v, want the party's game", reply)
+	}
+}
+
+func TestMatchPartyFirstMemberWins(t *testing.T) {
+	testPlatform(t)
+	instances := []*GameInstance{
+		testInstance("a", 5, 10, nil),
+		testInstance("b", 5, 10, nil),
+	}
+	testRegistry(instances...)
+
+	// another frontend matched a member of the same new party to b first
+	req := &MatchRequest{PartyID: "q", PartySize: 2, Team: "none"}
+	err := apputils.CurrentPlatform().Cache.Set(testRequest(), partyCacheKey("q"), "b", PartyTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	choice := &matchCandidate{instance: instances[0], room: instances[0].Status.Rooms[0]}
+	c := rememberParty(testRequest(), instances, req, "", choice, time.Now())
+	if c.instance.InstanceID != "b" {
+		t.Fatalf("got %s, want the instance stored first", c.instance.InstanceID)
+	}
+
+	var stored string
+	apputils.CurrentPlatform().Cache.Get(testRequest(), partyCacheKey("q"), &stored)
+	if stored != "b" {
+		t.Fatalf("party moved to %s", stored)
+	}
+}
+
+func TestDecodeMatch(t *testing.T) {
+	req, err := DecodeMatch(strings.NewReader(""))
+	if err != nil || req.PartySize != 1 || req.Team != "none" {
+		t.Fatalf("defaults: got %+v %v", req, err)
+	}
+
+	for _, body := range []string{`{"PartySize": 11}`, `{"PartySize": -1}`, `{"Team": "x"}`} {
+		if _, err := DecodeMatch(strings.NewReader(body)); err == nil {
+			t.Errorf("%s: accepted", body)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Profile store that lives in the frontend process.  Profiles are lost when
+// the frontend restarts, so this is mostly useful for development; the
+// platform store persists to disk when running standalone.
+package frontend
+
+import(
+	"net/http"
+	"sync"
+)
+
+type MemoryProfileStore struct {
+	lock		sync.Mutex
+	profiles	map[string]*Profile	// by user ID
+	names		map[string]string	// codeNameKey -> user ID
+}
+
+func NewMemoryProfileStore() *MemoryProfileStore {
+	return &MemoryProfileStore{
+		profiles:	make(map[string]*Profile),
+		names:		make(map[string]string),
+	}
+}
+
+func (ps *MemoryProfileStore) Get(r *http.Request, userID string) (*Profile, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	profile, found := ps.profiles[userID]
+	if !found {
+		return nil, ErrNoSuchProfile
+	}
+
+	copy := *profile
+
+	return &copy, nil
+}
+
+func (ps *MemoryProfileStore) NameOwner(r *http.Request, name string) (string, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return ps.names[codeNameKey(name)], nil
+}
+
+// must be called with the lock held
+func (ps *MemoryProfileStore) release(userID string) {
+	old, found := ps.profiles[userID]
+	if !found {
+		return
+	}
+
+	key := codeNameKey(old.CodeName)
+	if ps.names[key] == userID {
+		delete(ps.names, key)
+	}
+}
+
+func (ps *MemoryProfileStore) Put(r *http.Request, profile *Profile) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	key := codeNameKey(profile.CodeName)
+	if key != "" {
+		owner := ps.names[key]
+		if owner != "" && owner != profile.UserID {
+			return ErrNameReserved
+		}
+	}
+
+	ps.release(profile.UserID)
+
+	if key != "" {
+		ps.names[key] = profile.UserID
+	}
+
+	copy := *profile
+	ps.profiles[profile.UserID] = &copy
+
+	return nil
+}
+
+func (ps *MemoryProfileStore) Delete(r *http.Request, userID string) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	_, found := ps.profiles[userID]
+	if !found {
+		return ErrNoSuchProfile
+	}
+
+	ps.release(userID)
+	delete(ps.profiles, userID)
+
+	return nil
+}
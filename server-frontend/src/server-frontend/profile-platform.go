@@ -0,0 +1,142 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Profile store built on the platform store, which on App Engine is the
+// Datastore "profiles" kind keyed by user ID, and the "codenames" kind
+// keyed by codeNameKey holding who reserved each name.
+package frontend
+
+import(
+	"fmt"
+	"net/http"
+
+	"apputils"
+)
+
+const(
+	ProfileKind	= "profiles"
+	CodeNameKind	= "codenames"
+)
+
+// who reserved a code name
+type CodeNameClaim struct {
+	UserID	string
+}
+
+type PlatformProfileStore struct{}
+
+func NewPlatformProfileStore() *PlatformProfileStore {
+	return &PlatformProfileStore{}
+}
+
+func (ps *PlatformProfileStore) Get(r *http.Request, userID string) (*Profile, error) {
+	profile := &Profile{}
+	err := apputils.CurrentPlatform().Store.Get(r, ProfileKind, userID, profile)
+	if err == apputils.ErrNoSuchEntity {
+		return nil, ErrNoSuchProfile
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func (ps *PlatformProfileStore) NameOwner(r *http.Request, name string) (string, error) {
+	key := codeNameKey(name)
+	if key == "" {
+		return "", nil
+	}
+
+	var claim CodeNameClaim
+	err := apputils.CurrentPlatform().Store.Get(r, CodeNameKind, key, &claim)
+	if err == apputils.ErrNoSuchEntity {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return claim.UserID, nil
+}
+
+// releases name if it is held by userID
+func (ps *PlatformProfileStore) release(r *http.Request, userID string, name string) {
+	owner, err := ps.NameOwner(r, name)
+	if err != nil || owner != userID {
+		return
+	}
+
+	err = apputils.CurrentPlatform().Store.Delete(r, CodeNameKind, codeNameKey(name))
+	if err != nil {
+		apputils.Log(r, fmt.Sprintf("PlatformProfileStore: Ignore error releasing '%s': %v", name, err))
+	}
+}
+
+//
+// The store has no transactions, so two users claiming the same free name at
+// the same moment could both succeed; the later claim wins the name.
+//
+func (ps *PlatformProfileStore) Put(r *http.Request, profile *Profile) error {
+	store := apputils.CurrentPlatform().Store
+
+	if profile.CodeName != "" {
+		owner, err := ps.NameOwner(r, profile.CodeName)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != profile.UserID {
+			return ErrNameReserved
+		}
+
+		err = store.Put(r, CodeNameKind, codeNameKey(profile.CodeName), &CodeNameClaim{UserID: profile.UserID})
+		if err != nil {
+			return err
+		}
+	}
+
+	old, err := ps.Get(r, profile.UserID)
+	if err != nil && err != ErrNoSuchProfile {
+		return err
+	}
+
+	err = store.Put(r, ProfileKind, profile.UserID, profile)
+	if err != nil {
+		return err
+	}
+
+	if old != nil && codeNameKey(old.CodeName) != codeNameKey(profile.CodeName) {
+		ps.release(r, profile.UserID, old.CodeName)
+	}
+
+	return nil
+}
+
+func (ps *PlatformProfileStore) Delete(r *http.Request, userID string) error {
+	old, err := ps.Get(r, userID)
+	if err != nil {
+		return err
+	}
+
+	err = apputils.CurrentPlatform().Store.Delete(r, ProfileKind, userID)
+	if err != nil {
+		return err
+	}
+
+	ps.release(r, userID, old.CodeName)
+
+	return nil
+}
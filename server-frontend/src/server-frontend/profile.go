@@ -0,0 +1,204 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Player profiles.  A user (see user.go) can save a profile, which reserves
+// its code name so that nobody else can join under it, and remembers the
+// preferred team and enter status.  Code names are reserved regardless of
+// case, so "Bob" also reserves "bob".
+package frontend
+
+import(
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"apputils"
+	"gamerpc"
+
+	"github.com/tadhunt/httputils"
+)
+
+const(
+	MaxCodeNameLen	= 20	// NAMELEN in huntd's hunt.h
+)
+
+var ErrNoSuchProfile = errors.New("no such profile")
+var ErrNameReserved = errors.New("code name is reserved by another player")
+
+type Profile struct {
+	UserID		string
+	CodeName	string	// reserved for UserID, "" for none
+	Team		string	// "0" .. "9", "none" or "auto"
+	EnterStatus	int64	// any of the gamerpc.Q_* consts; the Datastore has no unsigned ints
+	Updated		time.Time
+}
+
+//
+// Get returns ErrNoSuchProfile if the user hasn't saved a profile.
+// Put returns ErrNameReserved if the code name belongs to another user, and
+// releases the user's previous code name if it changed.
+// NameOwner returns the ID of the user who reserved name, or "" if nobody has.
+//
+type ProfileStore interface {
+	Get(r *http.Request, userID string) (*Profile, error)
+	Put(r *http.Request, profile *Profile) error
+	Delete(r *http.Request, userID string) error
+	NameOwner(r *http.Request, name string) (string, error)
+}
+
+var profiles ProfileStore
+
+//
+// kind is one of:
+//	"platform"	the platform store (the datastore on App Engine)
+//	"memory"	process memory only
+//
+func NewProfileStore(kind string) (ProfileStore, error) {
+	switch kind {
+	case "", "platform":
+		return NewPlatformProfileStore(), nil
+	case "memory":
+		return NewMemoryProfileStore(), nil
+	}
+
+	return nil, fmt.Errorf("unknown profile store '%s'", kind)
+}
+
+// the key code names are reserved under
+func codeNameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+//
+// Checks name isn't reserved by anyone other than userID.  Names can't be
+// checked if the store is unavailable, in which case the join is let through
+// rather than locking everyone out.
+//
+func CheckCodeName(r *http.Request, userID string, name string) error {
+	owner, err := profiles.NameOwner(r, name)
+	if err != nil {
+		apputils.Log(r, fmt.Sprintf("CheckCodeName: Ignore error looking up '%s': %v", name, err))
+		return nil
+	}
+
+	if owner != "" && owner != userID {
+		return ErrNameReserved
+	}
+
+	return nil
+}
+
+func DecodeProfile(r *http.Request) (*Profile, error) {
+	dec := json.NewDecoder(r.Body)
+
+	var profile Profile
+	err := dec.Decode(&profile)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.CodeName = strings.TrimSpace(profile.CodeName)
+	if len(profile.CodeName) > MaxCodeNameLen {
+		return nil, fmt.Errorf("CodeName longer than %d characters", MaxCodeNameLen)
+	}
+
+	switch profile.Team {
+	case "":
+		profile.Team = "none"
+	case "none", apputils.TEAM_AUTO, "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		break
+	default:
+		return nil, fmt.Errorf("bad Team")
+	}
+
+	switch profile.EnterStatus {
+	case 0:
+		profile.EnterStatus = gamerpc.Q_FLY
+	case gamerpc.Q_CLOAK, gamerpc.Q_FLY, gamerpc.Q_SCAN:
+		break
+	default:
+		return nil, fmt.Errorf("bad EnterStatus")
+	}
+
+	return &profile, nil
+}
+
+//
+// GET returns the user's profile, or an empty one if they haven't saved one.
+// PUT saves it, reserving its CodeName.  DELETE removes it, releasing the name.
+//
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	err := httputils.RequestAcceptsJSON(r)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
+		return
+	}
+
+	userID, err := RequestUserID(w, r)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+
+	var profile *Profile
+
+	switch r.Method {
+	case "GET":
+		profile, err = profiles.Get(r, userID)
+		if err == ErrNoSuchProfile {
+			profile, err = &Profile{UserID: userID, Team: "none", EnterStatus: gamerpc.Q_FLY}, nil
+		}
+	case "PUT":
+		profile, err = DecodeProfile(r)
+		if err != nil {
+			apputils.Error(w, r, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		profile.UserID = userID
+		profile.Updated = time.Now()
+
+		err = profiles.Put(r, profile)
+		if err == ErrNameReserved {
+			apputils.Error(w, r, http.StatusConflict, err.Error(), err)
+			return
+		}
+	case "DELETE":
+		err = profiles.Delete(r, userID)
+		if err == nil || err == ErrNoSuchProfile {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apputils.Error(w, r, http.StatusMethodNotAllowed, "method not allowed", fmt.Errorf("method %s", r.Method))
+		return
+	}
+
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(profile)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gamerpc"
+)
+
+// tests any ProfileStore, which must be empty
+func checkProfileStore(t *testing.T, how string, ps ProfileStore) {
+	r := testRequest()
+
+	_, err := ps.Get(r, "alice")
+	if err != ErrNoSuchProfile {
+		t.Fatalf("%s: Get before Put: err = %v, want %v", how, err, ErrNoSuchProfile)
+	}
+
+	err = ps.Put(r, &Profile{UserID: "alice", CodeName: "Bob", Team: "3", EnterStatus: gamerpc.Q_SCAN})
+	if err != nil {
+		t.Fatalf("%s: Put: %v", how, err)
+	}
+	profile, err := ps.Get(r, "alice")
+	if err != nil || profile.CodeName != "Bob" || profile.Team != "3" || profile.EnterStatus != gamerpc.Q_SCAN {
+		t.Errorf("%s: Get: got %+v, %v", how, profile, err)
+	}
+
+	// names are reserved regardless of case and surrounding space
+	for _, name := range []string{"Bob", "bob", " BOB "} {
+		owner, err := ps.NameOwner(r, name)
+		if err != nil || owner != "alice" {
+			t.Errorf("%s: NameOwner(%q) = %q, %v", how, name, owner, err)
+		}
+	}
+	err = ps.Put(r, &Profile{UserID: "carol", CodeName: "bob"})
+	if err != ErrNameReserved {
+		t.Errorf("%s: carol taking bob: err = %v, want %v", how, err, ErrNameReserved)
+	}
+
+	// renaming releases the old name
+	err = ps.Put(r, &Profile{UserID: "alice", CodeName: "Alice"})
+	if err != nil {
+		t.Fatalf("%s: rename: %v", how, err)
+	}
+	if owner, _ := ps.NameOwner(r, "bob"); owner != "" {
+		t.Errorf("%s: bob still owned by %q after rename", how, owner)
+	}
+	err = ps.Put(r, &Profile{UserID: "carol", CodeName: "bob"})
+	if err != nil {
+		t.Errorf("%s: carol taking released bob: %v", how, err)
+	}
+
+	// a profile without a name reserves nothing
+	err = ps.Put(r, &Profile{UserID: "dave"})
+	if err != nil {
+		t.Errorf("%s: Put without a name: %v", how, err)
+	}
+	if owner, _ := ps.NameOwner(r, ""); owner != "" {
+		t.Errorf("%s: empty name owned by %q", how, owner)
+	}
+
+	err = ps.Delete(r, "alice")
+	if err != nil {
+		t.Fatalf("%s: Delete: %v", how, err)
+	}
+	if owner, _ := ps.NameOwner(r, "alice"); owner != "" {
+		t.Errorf("%s: alice still owned by %q after Delete", how, owner)
+	}
+	if _, err = ps.Get(r, "alice"); err != ErrNoSuchProfile {
+		t.Errorf("%s: Get after Delete: err = %v", how, err)
+	}
+	if err = ps.Delete(r, "alice"); err != ErrNoSuchProfile {
+		t.Errorf("%s: Delete twice: err = %v", how, err)
+	}
+	if owner, _ := ps.NameOwner(r, "bob"); owner != "carol" {
+		t.Errorf("%s: bob owned by %q after deleting alice, want carol", how, owner)
+	}
+}
+
+func TestMemoryProfileStore(t *testing.T) {
+	checkProfileStore(t, "memory", NewMemoryProfileStore())
+}
+
+func TestPlatformProfileStore(t *testing.T) {
+	testPlatform(t)
+	checkProfileStore(t, "platform", NewPlatformProfileStore())
+}
+
+func TestDecodeProfile(t *testing.T) {
+	tests := []struct {
+		body	string
+		ok	bool
+		want	Profile
+	}{
+		{`{}`,						true,	Profile{Team: "none", EnterStatus: gamerpc.Q_FLY}},
+		{`{"CodeName": " Bob ", "Team": "auto"}`,	true,	Profile{CodeName: "Bob", Team: "auto", EnterStatus: gamerpc.Q_FLY}},
+		{`{"Team": "9", "EnterStatus": 3}`,		true,	Profile{Team: "9", EnterStatus: gamerpc.Q_SCAN}},
+		{`{"CodeName": "` + strings.Repeat("x", MaxCodeNameLen + 1) + `"}`,	false,	Profile{}},
+		{`{"Team": "10"}`,				false,	Profile{}},
+		{`{"EnterStatus": 7}`,				false,	Profile{}},
+		{`not json`,					false,	Profile{}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("PUT", "/api/v1/profile", strings.NewReader(test.body))
+
+		profile, err := DecodeProfile(r)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: err = %v", test.body, err)
+			continue
+		}
+		if test.ok && *profile != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.body, profile, test.want)
+		}
+	}
+}
+
+func testProfileStore(t *testing.T) {
+	saved := profiles
+	profiles = NewMemoryProfileStore()
+	t.Cleanup(func() { profiles = saved })
+}
+
+func profileRequest(t *testing.T, method string, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/v1/profile", strings.NewReader(body))
+	r.Header.Set("Accept", "application/json")
+	r.AddCookie(cookie)
+
+	w := httptest.NewRecorder()
+	profileHandler(w, r)
+
+	return w
+}
+
+// a new user's cookie
+func testUserCookie(t *testing.T) *http.Cookie {
+	w := httptest.NewRecorder()
+	_, err := RequestUserID(w, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Result().Cookies()[0]
+}
+
+func TestProfileHandler(t *testing.T) {
+	testProfileStore(t)
+	alice := testUserCookie(t)
+	carol := testUserCookie(t)
+
+	w := profileRequest(t, "GET", "", alice)
+	var profile Profile
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if w.Code != http.StatusOK || profile.CodeName != "" || profile.Team != "none" {
+		t.Errorf("GET before PUT: %d %s", w.Code, w.Body)
+	}
+
+	w = profileRequest(t, "PUT", `{"CodeName": "Bob", "Team": "2"}`, alice)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+
+	w = profileRequest(t, "GET", "", alice)
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if profile.CodeName != "Bob" || profile.Team != "2" || profile.Updated.IsZero() {
+		t.Errorf("GET after PUT: %s", w.Body)
+	}
+
+	w = profileRequest(t, "PUT", `{"CodeName": "bob"}`, carol)
+	if w.Code != http.StatusConflict {
+		t.Errorf("PUT of a reserved name: %d %s", w.Code, w.Body)
+	}
+
+	w = profileRequest(t, "PUT", `{"Team": "x"}`, alice)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("PUT of a bad profile: %d %s", w.Code, w.Body)
+	}
+
+	for i := 0; i < 2; i++ {
+		w = profileRequest(t, "DELETE", "", alice)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("DELETE #%d: %d %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	w = profileRequest(t, "POST", "", alice)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Errorf("POST: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCheckCodeName(t *testing.T) {
+	testProfileStore(t)
+	r := testRequest()
+
+	profiles.Put(r, &Profile{UserID: "alice", CodeName: "Bob"})
+
+	if err := CheckCodeName(r, "alice", "BOB"); err != nil {
+		t.Errorf("owner joining as their name: %v", err)
+	}
+	if err := CheckCodeName(r, "carol", "bob"); err != ErrNameReserved {
+		t.Errorf("other user joining as a reserved name: err = %v", err)
+	}
+	if err := CheckCodeName(r, "carol", "carol"); err != nil {
+		t.Errorf("joining as a free name: %v", err)
+	}
+}
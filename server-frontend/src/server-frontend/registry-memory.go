@@ -0,0 +1,170 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Instance registries that live in the frontend process.  These only make
+// sense when there is a single frontend process, e.g. when running standalone.
+// The file registry additionally persists to a JSON file so that a restarted
+// frontend remembers the game servers it knew about.
+package frontend
+
+import(
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryRegistry struct {
+	lock		sync.Mutex
+	instances	map[string]*GameInstance
+	path		string	// if not empty, persist to this file
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		instances:	make(map[string]*GameInstance),
+	}
+}
+
+func NewFileRegistry(path string) (*MemoryRegistry, error) {
+	registry := NewMemoryRegistry()
+	registry.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &registry.instances)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+// must be called with the lock held
+func (registry *MemoryRegistry) sync() error {
+	if registry.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(registry.instances)
+	if err != nil {
+		return err
+	}
+
+	tmp := registry.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, registry.path)
+}
+
+func (registry *MemoryRegistry) Find(r *http.Request, instanceID string) (*GameInstance, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	instance, found := registry.instances[instanceID]
+	if !found || !instance.Alive(time.Now()) {
+		return nil, ErrNoSuchInstance
+	}
+
+	i := *instance
+	return &i, nil
+}
+
+func (registry *MemoryRegistry) Update(r *http.Request, instance *GameInstance) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	now := time.Now()
+	instance.LastSeen = now
+	instance.FirstSeen = now
+
+	prev, found := registry.instances[instance.InstanceID]
+	if found && prev.URL == instance.URL {
+		instance.FirstSeen = prev.FirstSeen
+		instance.UnhealthyUntil = prev.UnhealthyUntil
+	}
+
+	i := *instance
+	registry.instances[instance.InstanceID] = &i
+
+	return registry.sync()
+}
+
+func (registry *MemoryRegistry) Reap(r *http.Request) (int, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	now := time.Now()
+	n := 0
+	for id, instance := range registry.instances {
+		if instance.Alive(now) {
+			continue
+		}
+		delete(registry.instances, id)
+		n++
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, registry.sync()
+}
+
+func (registry *MemoryRegistry) List(r *http.Request) ([]*GameInstance, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	ids := make([]string, 0, len(registry.instances))
+	for id := range registry.instances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	instances := make([]*GameInstance, len(ids))
+	for i, id := range ids {
+		instance := *registry.instances[id]
+		instances[i] = &instance
+	}
+
+	return instances, nil
+}
+
+func (registry *MemoryRegistry) MarkUnhealthy(r *http.Request, instanceID string, until time.Time) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	instance, found := registry.instances[instanceID]
+	if !found {
+		return ErrNoSuchInstance
+	}
+
+	instance.UnhealthyUntil = until
+
+	return registry.sync()
+}
@@ -0,0 +1,228 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Instance registry built on the platform cache and store, which on
+// App Engine are memcache and the Datastore "instances" kind.
+// Liveness is tracked by the cache expiring entries, the store remembers
+// every instance until it is reaped.
+package frontend
+
+import(
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"apputils"
+)
+
+const(
+	GameInstanceKind	= "instances"
+)
+
+// TODO(tad): this is here because Datastore can't handle the types in a GameInstance.  Ugh.
+type DatastoreGameInfo struct {
+	URL	string
+	Data	[]byte	`datastore:",noindex"`	// JSON encoded GameInstance
+}
+
+type PlatformRegistry struct{}
+
+func NewPlatformRegistry() *PlatformRegistry {
+	return &PlatformRegistry{}
+}
+
+func instanceCacheKey(instanceID string) string {
+	return "instance:" + instanceID
+}
+
+func (dgame *DatastoreGameInfo) instance(instanceID string) (*GameInstance, error) {
+	instance := &GameInstance{}
+
+	if len(dgame.Data) > 0 {
+		err := json.Unmarshal(dgame.Data, instance)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// entities written before Data existed only have a URL
+	instance.InstanceID = instanceID
+	instance.URL = dgame.URL
+
+	return instance, nil
+}
+
+func (registry *PlatformRegistry) Find(r *http.Request, instanceID string) (*GameInstance, error) {
+	instance := &GameInstance{}
+
+	err := apputils.CurrentPlatform().Cache.Get(r, instanceCacheKey(instanceID), instance)
+	if err == apputils.ErrCacheMiss {
+		return nil, ErrNoSuchInstance
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+func (registry *PlatformRegistry) Update(r *http.Request, instance *GameInstance) error {
+	platform := apputils.CurrentPlatform()
+
+	now := time.Now()
+	instance.LastSeen = now
+	instance.FirstSeen = now
+
+	var dgame DatastoreGameInfo
+	err := platform.Store.Get(r, GameInstanceKind, instance.InstanceID, &dgame)
+	switch err {
+	case nil:
+		prev, err := dgame.instance(instance.InstanceID)
+		if err == nil && prev.URL == instance.URL {
+			if !prev.FirstSeen.IsZero() {
+				instance.FirstSeen = prev.FirstSeen
+			}
+			instance.UnhealthyUntil = prev.UnhealthyUntil
+		}
+	case apputils.ErrNoSuchEntity:
+	default:
+		apputils.Log(r, fmt.Sprintf("UpdateGameInstance[%s]: Ignore error reading store: %v", instance.InstanceID, err))
+	}
+
+	// NOTE: we don't bother with CAS because it doesn't really matter who wins
+	err = platform.Cache.Set(r, instanceCacheKey(instance.InstanceID), instance, GameInstanceTimeout)
+	if err != nil {
+		return err
+	}
+
+	apputils.Log(r, fmt.Sprintf("UpdateGameInstance[%s]: Cache updated with %v", instance.InstanceID, instance))
+
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	dgame = DatastoreGameInfo {
+		URL:	instance.URL,
+		Data:	data,
+	}
+
+	err = platform.Store.Put(r, GameInstanceKind, instance.InstanceID, &dgame)
+	if err != nil {
+		return err
+	}
+
+	apputils.Log(r, fmt.Sprintf("UpdateGameInstance[%s]: Store updated with gameserver %s", instance.InstanceID, dgame.URL))
+
+	return nil
+}
+
+//
+// Deletes all instances found in the store that aren't in the cache.
+// This technique is used because the cache is setup to expire the instances
+// if not heard from for too long.
+//
+func (registry *PlatformRegistry) Reap(r *http.Request) (int, error) {
+	platform := apputils.CurrentPlatform()
+
+	var dgames []*DatastoreGameInfo
+
+	keys, err := platform.Store.GetAll(r, GameInstanceKind, &dgames)
+	if err != nil {
+		apputils.Log(r, fmt.Sprintf("Ignore error finding game instances to reap: %v", err))
+		return 0, nil
+	}
+
+	n := 0
+	for i, dgame := range dgames {
+		apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Look for key %s url %s", i, keys[i], dgame.URL))
+		_, err := registry.Find(r, keys[i])
+		if err == nil {
+			apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Keep: key %s url %s still alive", i, keys[i], dgame.URL))
+			continue
+		}
+
+		err = platform.Store.Delete(r, GameInstanceKind, keys[i])
+		if err != nil {
+			apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Ignore error deleting key %s url %s: %v", i, keys[i], dgame.URL, err))
+		} else {
+			apputils.Log(r, fmt.Sprintf("ReapGameInstances[%d]: Deleted key %s game %s", i, keys[i], dgame.URL))
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (registry *PlatformRegistry) List(r *http.Request) ([]*GameInstance, error) {
+	var dgames []*DatastoreGameInfo
+
+	keys, err := apputils.CurrentPlatform().Store.GetAll(r, GameInstanceKind, &dgames)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []*GameInstance
+	for i, id := range keys {
+		if id == "" {
+			apputils.Log(r, fmt.Sprintf("Key %d missing stringid", i))
+			continue
+		}
+
+		instance, err := dgames[i].instance(id)
+		if err != nil {
+			apputils.Log(r, fmt.Sprintf("Ignore instance %s: %v", id, err))
+			continue
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+//
+// Only the store is updated, since that's what List reads.  Find reads the
+// cache, so players already in a game on the instance are still sent to it,
+// where the caller's circuit breaker fails them fast.
+//
+func (registry *PlatformRegistry) MarkUnhealthy(r *http.Request, instanceID string, until time.Time) error {
+	store := apputils.CurrentPlatform().Store
+
+	var dgame DatastoreGameInfo
+	err := store.Get(r, GameInstanceKind, instanceID, &dgame)
+	if err == apputils.ErrNoSuchEntity {
+		return ErrNoSuchInstance
+	}
+	if err != nil {
+		return err
+	}
+
+	instance, err := dgame.instance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	instance.UnhealthyUntil = until
+
+	dgame.Data, err = json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	return store.Put(r, GameInstanceKind, instanceID, &dgame)
+}
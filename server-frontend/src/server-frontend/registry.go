@@ -0,0 +1,91 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// The instance registry keeps track of which game servers are alive.
+// Game servers announce themselves with keepalives, which are recorded with
+// Update, and are forgotten when they haven't been heard from for
+// GameInstanceTimeout.
+package frontend
+
+import(
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"apputils"
+)
+
+var ErrNoSuchInstance = errors.New("no such instance")
+
+type GameInstance struct {
+	InstanceID	string
+	URL		string
+	Hostname	string
+	Seq		uint64		// sequence number of the most recent keepalive
+	FirstSeen	time.Time
+	LastSeen	time.Time
+	Status		*apputils.InstanceStatus	// as of the most recent keepalive, may be nil
+	UnhealthyUntil	time.Time	// frontends couldn't reach it, don't send players there before this
+}
+
+//
+// Find returns ErrNoSuchInstance unless the instance has been heard from recently.
+// List returns every known instance, including ones which are due to be reaped.
+// Reap forgets instances that haven't been heard from recently, returning how many.
+// MarkUnhealthy records that the instance can't be reached until the given
+// time, or that it can be again if until is the zero time.  Keepalives don't
+// clear the mark, since a game server can be up but unreachable from frontends.
+//
+type InstanceRegistry interface {
+	Find(r *http.Request, instanceID string) (*GameInstance, error)
+	Update(r *http.Request, instance *GameInstance) error
+	Reap(r *http.Request) (int, error)
+	List(r *http.Request) ([]*GameInstance, error)
+	MarkUnhealthy(r *http.Request, instanceID string, until time.Time) error
+}
+
+//
+// kind is one of:
+//	"platform"	the platform cache and store (memcache and datastore on App Engine)
+//	"memory"	process memory only
+//	"file"		process memory, persisted to path
+//
+func NewInstanceRegistry(kind string, path string) (InstanceRegistry, error) {
+	switch kind {
+	case "", "platform":
+		return NewPlatformRegistry(), nil
+	case "memory":
+		return NewMemoryRegistry(), nil
+	case "file":
+		return NewFileRegistry(path)
+	}
+
+	return nil, fmt.Errorf("unknown instance registry '%s'", kind)
+}
+
+func (instance *GameInstance) String() string {
+	return fmt.Sprintf("GameInstance{InstanceID: %s, URL: %s, Hostname: %s, Seq: %d, LastSeen: %v}",
+		instance.InstanceID, instance.URL, instance.Hostname, instance.Seq, instance.LastSeen)
+}
+
+func (instance *GameInstance) Alive(now time.Time) bool {
+	return now.Sub(instance.LastSeen) < GameInstanceTimeout
+}
+
+func (instance *GameInstance) Healthy(now time.Time) bool {
+	return !now.Before(instance.UnhealthyUntil)
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// what every InstanceRegistry does
+func checkRegistry(t *testing.T, name string, registry InstanceRegistry) {
+	r := testRequest()
+
+	_, err := registry.Find(r, "0")
+	if err != ErrNoSuchInstance {
+		t.Fatalf("%s: Find before Update: got %v, want %v", name, err, ErrNoSuchInstance)
+	}
+
+	err = registry.Update(r, &GameInstance{InstanceID: "0", URL: "http://a/jsonrpc", Seq: 1})
+	if err != nil {
+		t.Fatalf("%s: Update: %v", name, err)
+	}
+
+	instance, err := registry.Find(r, "0")
+	if err != nil {
+		t.Fatalf("%s: Find: %v", name, err)
+	}
+	if instance.URL != "http://a/jsonrpc" || instance.Seq != 1 || instance.FirstSeen.IsZero() {
+		t.Fatalf("%s: Find = %v", name, instance)
+	}
+	firstSeen := instance.FirstSeen
+
+	// unhealthy survives keepalives from the same game server
+	until := time.Now().Add(time.Minute).Round(0)
+	err = registry.MarkUnhealthy(r, "0", until)
+	if err != nil {
+		t.Fatalf("%s: MarkUnhealthy: %v", name, err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	registry.Update(r, &GameInstance{InstanceID: "0", URL: "http://a/jsonrpc", Seq: 2})
+	instance, _ = registry.Find(r, "0")
+	if !instance.FirstSeen.Equal(firstSeen) || !instance.UnhealthyUntil.Equal(until) || instance.Seq != 2 {
+		t.Errorf("%s: after a keepalive: FirstSeen %v UnhealthyUntil %v Seq %d, want %v %v 2", name, instance.FirstSeen, instance.UnhealthyUntil, instance.Seq, firstSeen, until)
+	}
+
+	// but not a different game server taking over the instance
+	registry.Update(r, &GameInstance{InstanceID: "0", URL: "http://b/jsonrpc", Seq: 1})
+	instance, _ = registry.Find(r, "0")
+	if instance.FirstSeen.Equal(firstSeen) || !instance.UnhealthyUntil.IsZero() {
+		t.Errorf("%s: new game server kept FirstSeen %v UnhealthyUntil %v", name, instance.FirstSeen, instance.UnhealthyUntil)
+	}
+
+	err = registry.MarkUnhealthy(r, "9", until)
+	if err == nil {
+		t.Errorf("%s: MarkUnhealthy of an unknown instance succeeded", name)
+	}
+
+	registry.Update(r, &GameInstance{InstanceID: "1", URL: "http://c/jsonrpc"})
+	instances, err := registry.List(r)
+	if err != nil || len(instances) != 2 {
+		t.Fatalf("%s: List = %v %v, want 2 instances", name, instances, err)
+	}
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	registry := NewMemoryRegistry()
+	checkRegistry(t, "memory", registry)
+
+	// instances not heard from for a while are reaped
+	registry.instances["0"].LastSeen = time.Now().Add(-GameInstanceTimeout)
+
+	_, err := registry.Find(testRequest(), "0")
+	if err != ErrNoSuchInstance {
+		t.Errorf("Find of a timed out instance: got %v, want %v", err, ErrNoSuchInstance)
+	}
+
+	n, err := registry.Reap(testRequest())
+	if err != nil || n != 1 {
+		t.Errorf("Reap = %d %v, want 1", n, err)
+	}
+	instances, _ := registry.List(testRequest())
+	if len(instances) != 1 || instances[0].InstanceID != "1" {
+		t.Errorf("after Reap: %v", instances)
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances.json")
+
+	registry, err := NewFileRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistry(t, "file", registry)
+
+	// a restarted frontend remembers them
+	registry, err = NewFileRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, _ := registry.List(testRequest())
+	if len(instances) != 2 || instances[0].URL != "http://b/jsonrpc" {
+		t.Errorf("reloaded: %v", instances)
+	}
+}
+
+func TestPlatformRegistry(t *testing.T) {
+	platform := testPlatform(t)
+
+	registry := NewPlatformRegistry()
+	checkRegistry(t, "platform", registry)
+
+	// the cache entry expiring is what makes an instance dead
+	platform.Cache.Delete(testRequest(), instanceCacheKey("0"))
+
+	_, err := registry.Find(testRequest(), "0")
+	if err != ErrNoSuchInstance {
+		t.Errorf("Find of an expired instance: got %v, want %v", err, ErrNoSuchInstance)
+	}
+
+	n, err := registry.Reap(testRequest())
+	if err != nil || n != 1 {
+		t.Errorf("Reap = %d %v, want 1", n, err)
+	}
+}
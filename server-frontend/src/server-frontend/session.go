@@ -0,0 +1,150 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Player sessions.  A PlayerID alone is enough to control a player, so when a
+// player joins the frontend also hands out a signed, expiring session token
+// bound to the instance and PlayerID.  Player scoped endpoints require the
+// token in an "Authorization: Bearer" header.
+package frontend
+
+import(
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"apputils"
+)
+
+const(
+	SessionTimeout	= 12 * time.Hour
+)
+
+var ErrNoSession = errors.New("missing session")
+var ErrBadSession = errors.New("invalid or expired session")
+var ErrSessionMismatch = errors.New("session is for a different player")
+
+var sessionSecret []byte
+
+type Session struct {
+	InstanceID	string
+	PlayerID	string
+	Expires		int64	// unix seconds
+}
+
+//
+// All frontends must share SERVER_FRONTEND_SESSION_SECRET, otherwise sessions
+// issued by one won't be accepted by another.  It also signs the user cookies
+// that uids are keyed by, so a secret that changed on every restart would
+// orphan every user; refuse to start without one.
+//
+func initSessions() {
+	secret := os.Getenv("SERVER_FRONTEND_SESSION_SECRET")
+	if secret == "" {
+		log.Fatalf("SERVER_FRONTEND_SESSION_SECRET must be set")
+	}
+
+	sessionSecret = []byte(secret)
+}
+
+func NewSessionToken(instanceID string, playerID string, now time.Time) (string, error) {
+	session := &Session{
+		InstanceID:	instanceID,
+		PlayerID:	playerID,
+		Expires:	now.Add(SessionTimeout).Unix(),
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(session.Expires, 10)
+	sig := apputils.Sign(sessionSecret, expires, data)
+
+	return base64.RawURLEncoding.EncodeToString(data) + "." + sig, nil
+}
+
+func ParseSessionToken(token string, now time.Time) (*Session, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return nil, ErrBadSession
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, ErrBadSession
+	}
+
+	session := &Session{}
+	err = json.Unmarshal(data, session)
+	if err != nil {
+		return nil, ErrBadSession
+	}
+
+	expires := strconv.FormatInt(session.Expires, 10)
+	if !hmac.Equal([]byte(apputils.Sign(sessionSecret, expires, data)), []byte(token[i+1:])) {
+		return nil, ErrBadSession
+	}
+
+	if now.Unix() >= session.Expires {
+		return nil, ErrBadSession
+	}
+
+	return session, nil
+}
+
+func requestSessionToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(h[len("Bearer "):])
+}
+
+//
+// Checks the request carries a session for the given player on the given instance.
+// On failure an error response has been written and false is returned.
+//
+func CheckSession(w http.ResponseWriter, r *http.Request, instanceID string, playerID string) bool {
+	token := requestSessionToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		apputils.Error(w, r, http.StatusUnauthorized, ErrNoSession.Error(), ErrNoSession)
+		return false
+	}
+
+	session, err := ParseSessionToken(token, time.Now())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+		apputils.Error(w, r, http.StatusUnauthorized, err.Error(), err)
+		return false
+	}
+
+	if session.InstanceID != instanceID || session.PlayerID != playerID {
+		apputils.Error(w, r, http.StatusForbidden, ErrSessionMismatch.Error(), ErrSessionMismatch)
+		return false
+	}
+
+	return true
+}
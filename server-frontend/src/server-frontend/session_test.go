@@ -0,0 +1,90 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionToken(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	token, err := NewSessionToken("3", "42", now)
+	if err != nil {
+		t.Fatalf("NewSessionToken: %v", err)
+	}
+
+	session, err := ParseSessionToken(token, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ParseSessionToken: %v", err)
+	}
+	if session.InstanceID != "3" || session.PlayerID != "42" {
+		t.Errorf("session = %+v, want instance 3 player 42", session)
+	}
+
+	_, err = ParseSessionToken(token, now.Add(SessionTimeout))
+	if err != ErrBadSession {
+		t.Errorf("expired token: err = %v, want %v", err, ErrBadSession)
+	}
+
+	other, _ := NewSessionToken("3", "43", now)
+	forged := token[:strings.LastIndex(token, ".")] + other[strings.LastIndex(other, "."):]
+
+	for _, bad := range []string{"", "nodot", "!!!.sig", forged, token + "x"} {
+		_, err = ParseSessionToken(bad, now)
+		if err != ErrBadSession {
+			t.Errorf("ParseSessionToken(%q): err = %v, want %v", bad, err, ErrBadSession)
+		}
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	token, _ := NewSessionToken("3", "42", time.Now())
+
+	tests := []struct {
+		auth		string
+		instance	string
+		player		string
+		status		int
+	}{
+		{"Bearer " + token,	"3",	"42",	0},
+		{"",			"3",	"42",	401},
+		{"Bearer junk",		"3",	"42",	401},
+		{"Basic " + token,	"3",	"42",	401},
+		{"Bearer " + token,	"4",	"42",	403},
+		{"Bearer " + token,	"3",	"43",	403},
+	}
+
+	for _, test := range tests {
+		r := testRequest()
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+
+		ok := CheckSession(w, r, test.instance, test.player)
+		if ok != (test.status == 0) {
+			t.Errorf("%q on %s/%s: ok = %v", test.auth, test.instance, test.player, ok)
+		}
+		if test.status != 0 && w.Code != test.status {
+			t.Errorf("%q on %s/%s: status = %d, want %d", test.auth, test.instance, test.player, w.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Stats from every game server instance.  Instances are asked concurrently,
+// each with its own deadline, so one slow or dead game server can't hold up
+// the rest.  Requests still outstanding at the deadline are abandoned, and
+// the handler doesn't return until every one of them has stopped.  The aggregate is cached for a little while, so that a burst of
+// requests costs the game servers one round of stats requests.
+package frontend
+
+import(
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"apputils"
+	"gamerpc"
+
+	"github.com/tadhunt/httputils"
+)
+
+const(
+	StatsTimeout		= 3 * time.Second	// per instance
+	AllStatsCacheTime	= 5 * time.Second
+	AllStatsCacheKey	= "allstats"
+)
+
+// InstanceStatsReply.Status
+const(
+	STATS_OK	= "ok"
+	STATS_TIMEOUT	= "timeout"
+	STATS_ERROR	= "error"
+)
+
+type InstanceStatsReply struct {
+	InstanceID	string
+	Status		string	// one of STATS_*
+	Error		string	// why the stats are missing, if Status isn't STATS_OK
+	Stats		string
+}
+
+type AllStatsReply struct {
+	Time		time.Time	// when the stats were gathered
+	AllStats	[]*InstanceStatsReply
+}
+
+func isTimeout(err error) bool {
+	t, ok := err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// ctx abandons the request, r is only used for the platform
+func instanceStats(ctx context.Context, r *http.Request, instanceID string) *InstanceStatsReply {
+	reply := &InstanceStatsReply{
+		InstanceID:	instanceID,
+		Status:		STATS_ERROR,
+	}
+
+	game, err := FindGameInstance(r, instanceID)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+
+	// a copy, the client may be shared with other requests
+	limited := *game
+	limited.Timeout = StatsTimeout
+	limited.Context = ctx
+
+	stats, err := limited.Stats(r, &gamerpc.StatsRequest{Token: 123})
+	if err != nil {
+		if isTimeout(err) {
+			reply.Status = STATS_TIMEOUT
+		}
+		reply.Error = err.Error()
+		return reply
+	}
+
+	reply.Status = STATS_OK
+	reply.Stats = stats.Stats
+
+	return reply
+}
+
+//
+// Asks every instance for its stats.  Instances that haven't answered by
+// the deadline are reported as timed out, even if the platform's HTTP
+// client doesn't honor the per request timeout.  Their requests are
+// abandoned, and waited for, so that nothing uses r once this returns.
+//
+func gatherAllStats(r *http.Request, instances []*GameInstance) *AllStatsReply {
+	ctx, cancel := context.WithTimeout(r.Context(), StatsTimeout + time.Second)
+	defer cancel()
+
+	replies := make([]*InstanceStatsReply, len(instances))
+	done := make(chan int, len(instances))
+
+	var workers sync.WaitGroup
+	defer workers.Wait()
+
+	for i, instance := range instances {
+		workers.Add(1)
+		go func(i int, instanceID string) {
+			defer workers.Done()
+			replies[i] = instanceStats(ctx, r, instanceID)
+			done <- i
+		}(i, instance.InstanceID)
+	}
+
+	answered := make([]*InstanceStatsReply, len(instances))
+
+	timedOut := false
+	for n := 0; n < len(instances) && !timedOut; n++ {
+		select {
+		case i := <-done:
+			answered[i] = replies[i]
+		case <-ctx.Done():
+			timedOut = true
+		}
+	}
+
+	reply := &AllStatsReply{
+		Time:		time.Now(),
+		AllStats:	make([]*InstanceStatsReply, 0, len(instances)),
+	}
+
+	for i, instance := range instances {
+		ireply := answered[i]
+		if ireply == nil {
+			ireply = &InstanceStatsReply{
+				InstanceID:	instance.InstanceID,
+				Status:		STATS_TIMEOUT,
+				Error:		fmt.Sprintf("no answer within %v", StatsTimeout),
+			}
+		}
+
+		if ireply.Status != STATS_OK {
+			apputils.Log(r, fmt.Sprintf("allStatsHandler: %s: %s: %s", ireply.InstanceID, ireply.Status, ireply.Error))
+		}
+
+		reply.AllStats = append(reply.AllStats, ireply)
+	}
+
+	return reply
+}
+
+func allStatsHandler(w http.ResponseWriter, r *http.Request) {
+	err := httputils.RequestAcceptsJSON(r)
+	if err != nil {
+		apputils.Error(w, r, http.StatusBadRequest, "client does not accept application/json", err)
+		return
+	}
+
+	cache := apputils.CurrentPlatform().Cache
+
+	reply := &AllStatsReply{}
+	err = cache.Get(r, AllStatsCacheKey, reply)
+	if err != nil {
+		if err != apputils.ErrCacheMiss {
+			apputils.Log(r, fmt.Sprintf("allStatsHandler: Ignore error: cache get: %v", err))
+		}
+
+		var instances []*GameInstance
+		instances, err = GameInstances(r)
+		if err != nil {
+			apputils.InternalServerError(w, r, err.Error(), err)
+			return
+		}
+
+		reply = gatherAllStats(r, instances)
+
+		err = cache.Set(r, AllStatsCacheKey, reply, AllStatsCacheTime)
+		if err != nil {
+			apputils.Log(r, fmt.Sprintf("allStatsHandler: Ignore error: cache set: %v", err))
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(reply)
+	if err != nil {
+		apputils.InternalServerError(w, r, err.Error(), err)
+		return
+	}
+}
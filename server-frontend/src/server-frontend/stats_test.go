@@ -0,0 +1,113 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGatherAllStats(t *testing.T) {
+	slow := &HuntDaemon{statsDelay: StatsTimeout + 500 * time.Millisecond}
+	instances := []*GameInstance{
+		testGameServer(t, "0", &HuntDaemon{}),
+		testGameServer(t, "1", slow),
+		testGameServer(t, "2", &HuntDaemon{}),
+	}
+	testRegistry(instances...)
+
+	// listed, but gone from the registry by the time it's asked
+	instances = append(instances, testInstance("9", 0, 25, nil))
+
+	start := time.Now()
+	reply := gatherAllStats(testRequest(), instances)
+	if elapsed := time.Since(start); elapsed > StatsTimeout + time.Second {
+		t.Errorf("took %v, the slow instance held up the rest", elapsed)
+	}
+
+	want := []struct {
+		id	string
+		status	string
+		stats	string
+	}{
+		{"0",	STATS_OK,	"stats from 0"},
+		{"1",	STATS_TIMEOUT,	""},
+		{"2",	STATS_OK,	"stats from 2"},
+		{"9",	STATS_ERROR,	""},
+	}
+
+	if len(reply.AllStats) != len(want) {
+		t.Fatalf("got %d replies, want %d", len(reply.AllStats), len(want))
+	}
+	for i, w := range want {
+		got := reply.AllStats[i]
+		if got.InstanceID != w.id || got.Status != w.status || got.Stats != w.stats {
+			t.Errorf("reply %d: got %+v, want %+v", i, got, w)
+		}
+		if got.Status != STATS_OK && got.Error == "" {
+			t.Errorf("reply %d: %s without an Error", i, got.Status)
+		}
+	}
+}
+
+func TestGatherAllStatsRequestGone(t *testing.T) {
+	slow := &HuntDaemon{statsDelay: StatsTimeout + 500 * time.Millisecond}
+	instances := []*GameInstance{testGameServer(t, "1", slow)}
+	testRegistry(instances...)
+
+	// the operator gave up waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100 * time.Millisecond, cancel)
+
+	start := time.Now()
+	reply := gatherAllStats(testRequest().WithContext(ctx), instances)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, the stats requests outlived the request", elapsed)
+	}
+
+	if len(reply.AllStats) != 1 || reply.AllStats[0].Status == STATS_OK {
+		t.Errorf("got %+v, want the stats missing", reply.AllStats)
+	}
+}
+
+func TestAllStatsCached(t *testing.T) {
+	testPlatform(t)
+	huntd := &HuntDaemon{}
+	testRegistry(testGameServer(t, "0", huntd))
+
+	for i := 0; i < 3; i++ {
+		r := testRequest()
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		allStatsHandler(w, r)
+
+		var reply AllStatsReply
+		err := json.Unmarshal(w.Body.Bytes(), &reply)
+		if w.Code != http.StatusOK || err != nil || len(reply.AllStats) != 1 || reply.AllStats[0].Stats != "stats from 0" {
+			t.Fatalf("request %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+
+	if n := len(huntd.Requests()); n != 1 {
+		t.Errorf("game server asked %d times, want once", n)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Users.  Each browser gets a long lived, signed cookie holding a random user
+// ID, and each user ID is given a UNIX uid of its own, which is what huntd
+// uses to tell players apart in its score bookkeeping.  The uid is allocated
+// once and kept in the platform Store, so it is the same across sessions,
+// frontends and game instances.
+package frontend
+
+import(
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"apputils"
+)
+
+const(
+	UserCookie		= "hunt-user"
+	UserCookieMaxAge	= 5 * 365 * 24 * time.Hour
+	UserKind		= "users"
+	UidKind			= "uids"
+	UidBase			= 100000	// stay clear of system and regular login uids
+	UidAttempts		= 16
+)
+
+type User struct {
+	ID	string
+	Uid	int64		// a uint32, but the Datastore has no unsigned ints
+	Created	time.Time
+}
+
+// who holds a uid, stored under UidKind keyed by the uid
+type UidClaim struct {
+	UserID	string
+}
+
+func userCookieValue(id string) string {
+	return id + "." + apputils.Sign(sessionSecret, "user", []byte(id))
+}
+
+func parseUserCookie(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 1 {
+		return "", false
+	}
+
+	id := value[:i]
+	if !hmac.Equal([]byte(userCookieValue(id)), []byte(value)) {
+		return "", false
+	}
+
+	return id, true
+}
+
+func newUserID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+//
+// Whether the browser reached us over HTTPS.  Behind App Engine or a TLS
+// terminating proxy r.TLS is always nil, so trust X-Forwarded-Proto, or
+// SERVER_FRONTEND_SECURE_COOKIES=yes when the proxy doesn't set it.
+//
+func requestIsHTTPS(r *http.Request) bool {
+	if secureCookies || r.TLS != nil {
+		return true
+	}
+
+	return strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+//
+// Returns the ID of the user making the request, from their cookie.  If they
+// don't have one yet, or it doesn't verify, a new ID is issued and the cookie
+// set on w.
+//
+func RequestUserID(w http.ResponseWriter, r *http.Request) (string, error) {
+	cookie, err := r.Cookie(UserCookie)
+	if err == nil {
+		id, ok := parseUserCookie(cookie.Value)
+		if ok {
+			return id, nil
+		}
+	}
+
+	id, err := newUserID()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:		UserCookie,
+		Value:		userCookieValue(id),
+		Path:		"/",
+		MaxAge:		int(UserCookieMaxAge / time.Second),
+		HttpOnly:	true,
+		Secure:		requestIsHTTPS(r),
+	})
+
+	return id, nil
+}
+
+// the attempt'th candidate uid for a user, spread over [UidBase, 2^31)
+func candidateUid(userID string, attempt int) uint32 {
+	sum := sha256.Sum256([]byte(userID + ":" + strconv.Itoa(attempt)))
+	n := binary.BigEndian.Uint32(sum[:4])
+
+	return UidBase + n % (1<<31 - UidBase)
+}
+
+//
+// Claims the first free candidate uid for the user.  The Store has no
+// transactions, so two users racing for the same uid could in theory both
+// get it; with 2^31 candidates that isn't worth a lock.
+//
+func allocateUid(r *http.Request, userID string) (uint32, error) {
+	store := apputils.CurrentPlatform().Store
+
+	for attempt := 0; attempt < UidAttempts; attempt++ {
+		uid := candidateUid(userID, attempt)
+		key := strconv.FormatUint(uint64(uid), 10)
+
+		var claim UidClaim
+		err := store.Get(r, UidKind, key, &claim)
+		if err == nil {
+			if claim.UserID == userID {
+				return uid, nil
+			}
+			continue
+		}
+		if err != apputils.ErrNoSuchEntity {
+			return 0, err
+		}
+
+		err = store.Put(r, UidKind, key, &UidClaim{UserID: userID})
+		if err != nil {
+			return 0, err
+		}
+
+		return uid, nil
+	}
+
+	return 0, fmt.Errorf("no free uid for user %s after %d attempts", userID, UidAttempts)
+}
+
+func LoadUser(r *http.Request, userID string) (*User, error) {
+	store := apputils.CurrentPlatform().Store
+
+	user := &User{}
+	err := store.Get(r, UserKind, userID, user)
+	if err == nil {
+		return user, nil
+	}
+	if err != apputils.ErrNoSuchEntity {
+		return nil, err
+	}
+
+	uid, err := allocateUid(r, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user = &User{
+		ID:		userID,
+		Uid:		int64(uid),
+		Created:	time.Now(),
+	}
+
+	err = store.Put(r, UserKind, userID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+//
+// The user making the request.  If the Store is unavailable the user still
+// gets a stable uid, it just isn't checked for collisions.
+//
+func RequestUser(w http.ResponseWriter, r *http.Request) (*User, error) {
+	id, err := RequestUserID(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := LoadUser(r, id)
+	if err != nil {
+		apputils.Log(r, fmt.Sprintf("RequestUser: user %s: falling back to unchecked uid: %v", id, err))
+		user = &User{ID: id, Uid: int64(candidateUid(id, 0))}
+	}
+
+	return user, nil
+}
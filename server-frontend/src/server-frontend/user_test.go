@@ -0,0 +1,181 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package frontend
+
+import(
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestUserCookie(t *testing.T) {
+	value := userCookieValue("abc123")
+
+	id, ok := parseUserCookie(value)
+	if !ok || id != "abc123" {
+		t.Errorf("parseUserCookie(%q) = %q, %v", value, id, ok)
+	}
+
+	for _, bad := range []string{"", ".", "abc123", "abc124" + value[len("abc123"):], value + "0"} {
+		_, ok = parseUserCookie(bad)
+		if ok {
+			t.Errorf("parseUserCookie(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRequestUserIDKeepsCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	id, err := RequestUserID(w, testRequest())
+	if err != nil {
+		t.Fatalf("RequestUserID: %v", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != UserCookie {
+		t.Fatalf("cookies = %v, want one %s", cookies, UserCookie)
+	}
+
+	r := testRequest()
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+
+	again, err := RequestUserID(w, r)
+	if err != nil {
+		t.Fatalf("RequestUserID: %v", err)
+	}
+	if again != id {
+		t.Errorf("user ID = %q, want %q", again, id)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("cookie set again for a known user")
+	}
+}
+
+func TestUserCookieSecure(t *testing.T) {
+	defer func(saved bool) { secureCookies = saved }(secureCookies)
+
+	tests := []struct {
+		name	string
+		flag	bool
+		tls	bool
+		proto	string
+		secure	bool
+	}{
+		{"plain",		false,	false,	"",		false},
+		{"tls",			false,	true,	"",		true},
+		{"proxied https",	false,	false,	"https",	true},
+		{"proxied http",	false,	false,	"http",		false},
+		{"flag",		true,	false,	"",		true},
+	}
+
+	for _, test := range tests {
+		secureCookies = test.flag
+
+		r := testRequest()
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if test.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		w := httptest.NewRecorder()
+
+		_, err := RequestUserID(w, r)
+		if err != nil {
+			t.Fatalf("%s: RequestUserID: %v", test.name, err)
+		}
+
+		var cookie *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == UserCookie {
+				cookie = c
+			}
+		}
+		if cookie == nil {
+			t.Fatalf("%s: no %s cookie", test.name, UserCookie)
+		}
+		if cookie.Secure != test.secure {
+			t.Errorf("%s: Secure = %v, want %v", test.name, cookie.Secure, test.secure)
+		}
+	}
+}
+
+func TestAllocateUid(t *testing.T) {
+	platform := testPlatform(t)
+	r := testRequest()
+
+	uid, err := allocateUid(r, "alice")
+	if err != nil {
+		t.Fatalf("allocateUid: %v", err)
+	}
+	if uid != candidateUid("alice", 0) || uid < UidBase || uid >= 1<<31 {
+		t.Errorf("uid = %d, want the first candidate %d", uid, candidateUid("alice", 0))
+	}
+
+	again, err := allocateUid(r, "alice")
+	if err != nil || again != uid {
+		t.Errorf("allocating again: got %d, %v, want %d", again, err, uid)
+	}
+
+	// someone else holds bob's first two candidates
+	for attempt := 0; attempt < 2; attempt++ {
+		key := strconv.FormatUint(uint64(candidateUid("bob", attempt)), 10)
+		platform.Store.Put(r, UidKind, key, &UidClaim{UserID: "carol"})
+	}
+	uid, err = allocateUid(r, "bob")
+	if err != nil || uid != candidateUid("bob", 2) {
+		t.Errorf("bob: got %d, %v, want the third candidate %d", uid, err, candidateUid("bob", 2))
+	}
+
+	for attempt := 0; attempt < UidAttempts; attempt++ {
+		key := strconv.FormatUint(uint64(candidateUid("dave", attempt)), 10)
+		platform.Store.Put(r, UidKind, key, &UidClaim{UserID: "carol"})
+	}
+	_, err = allocateUid(r, "dave")
+	if err == nil {
+		t.Errorf("dave got a uid with every candidate taken")
+	}
+}
+
+func TestLoadUser(t *testing.T) {
+	platform := testPlatform(t)
+	r := testRequest()
+
+	user, err := LoadUser(r, "alice")
+	if err != nil {
+		t.Fatalf("LoadUser: %v", err)
+	}
+	if user.ID != "alice" || user.Uid != int64(candidateUid("alice", 0)) || user.Created.IsZero() {
+		t.Errorf("user = %+v", user)
+	}
+
+	var stored User
+	err = platform.Store.Get(r, UserKind, "alice", &stored)
+	if err != nil || stored.Uid != user.Uid {
+		t.Errorf("stored user = %+v, %v", stored, err)
+	}
+
+	// the stored uid wins, even if the candidates would now say otherwise
+	platform.Store.Put(r, UserKind, "alice", &User{ID: "alice", Uid: 123456})
+	user, err = LoadUser(r, "alice")
+	if err != nil || user.Uid != 123456 {
+		t.Errorf("reloaded user = %+v, %v", user, err)
+	}
+}
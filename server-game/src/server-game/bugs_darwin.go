@@ -25,10 +25,12 @@ package main
 const(
 	DEBIAN_ULONG_BUG	= false		// this bug doesn't exist
 	VERSION_AFTER_JOIN	= true		// but the protocol is different in this respect
+	HUNTD_PROTOCOL		= "bsdgames-osx"
 )
 
 func ShowBugs() {
 	logger.Log(LOG_STARTUP, "OS: Darwin")
 	logger.Log(LOG_STARTUP, "DEBIAN_ULONG_BUG   = %v", DEBIAN_ULONG_BUG)
 	logger.Log(LOG_STARTUP, "VERSION_AFTER_JOIN = %v", VERSION_AFTER_JOIN)
+	logger.Log(LOG_STARTUP, "HUNTD_PROTOCOL     = %v", HUNTD_PROTOCOL)
 }
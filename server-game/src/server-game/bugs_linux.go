@@ -20,10 +20,12 @@ package main
 const(
 	DEBIAN_ULONG_BUG	= true
 	VERSION_AFTER_JOIN	= false
+	HUNTD_PROTOCOL		= "debian"
 )
 
 func ShowBugs() {
 	logger.Log(LOG_STARTUP, "OS: Linux")
 	logger.Log(LOG_STARTUP, "DEBIAN_ULONG_BUG   = %v", DEBIAN_ULONG_BUG)
 	logger.Log(LOG_STARTUP, "VERSION_AFTER_JOIN = %v", VERSION_AFTER_JOIN)
+	logger.Log(LOG_STARTUP, "HUNTD_PROTOCOL     = %v", HUNTD_PROTOCOL)
 }
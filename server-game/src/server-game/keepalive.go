@@ -0,0 +1,133 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// TODO: High-level file comment.
+package main
+
+import(
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"apputils"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+//
+// Periodically announces this game server to the frontend.
+// The transport is selected by SERVER_KEEPALIVE_TRANSPORT:
+//	pubsub	publish to SERVER_KEEPALIVE_TOPIC (the default if a topic is set)
+//	http	POST to the frontend at SERVER_KEEPALIVE_URL, signed with SERVER_KEEPALIVE_SECRET
+//	local	deliver to a frontend running in the same process
+//
+type KeepAlive struct {
+	Transport	apputils.KeepAliveTransport
+	URL		*url.URL
+	Hostname	string
+	Instance	string
+}
+
+func (keepAlive *KeepAlive) KeepAlive(seq uint64, status *apputils.InstanceStatus) error {
+	msg := &apputils.KeepAliveMessage{
+		Hostname:	keepAlive.Hostname,
+		Instance:	keepAlive.Instance,
+		Seq:		seq,
+		URL:		keepAlive.URL,
+		Status:		status,
+	}
+
+	err := keepAlive.Transport.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	logger.Log(LOG_KEEPALIVE, "Sent seqid %d via %T", seq, keepAlive.Transport)
+
+	return nil
+}
+
+// SERVER_GAME_INSTANCE overrides the GAE backend instance, for running outside of GCE
+func keepAliveInstance() (string, error) {
+	instance := os.Getenv("SERVER_GAME_INSTANCE")
+	if instance != "" {
+		return instance, nil
+	}
+
+	return apputils.GAEBackendInstance()
+}
+
+func keepAliveHostname() (string, error) {
+	if metadata.OnGCE() {
+		return metadata.Hostname()
+	}
+
+	return os.Hostname()
+}
+
+func newKeepAliveTransport(transport string) (apputils.KeepAliveTransport, error) {
+	switch transport {
+	case "pubsub":
+		project, err := apputils.ProjectID()
+		if err != nil {
+			return nil, err
+		}
+		return apputils.NewPubSubKeepAliveTransport(project, os.Getenv("SERVER_KEEPALIVE_TOPIC"))
+	case "http":
+		return apputils.NewHTTPKeepAliveTransport(os.Getenv("SERVER_KEEPALIVE_URL"), os.Getenv("SERVER_KEEPALIVE_SECRET"))
+	case "local":
+		return apputils.LocalKeepAlive(), nil
+	}
+
+	return nil, fmt.Errorf("unknown keepalive transport '%s'", transport)
+}
+
+func NewKeepAlive(transport string, gameURL string) (*KeepAlive, error) {
+	if gameURL == "" {
+		return nil, fmt.Errorf("missing gameurl")
+	}
+
+	instance, err := keepAliveInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	ustr := strings.Replace(gameURL, "{{instance}}", instance, -1)
+	u, err := url.Parse(ustr)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := keepAliveHostname()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newKeepAliveTransport(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	keepalive :=  &KeepAlive{
+		Transport:	t,
+		URL:		u,
+		Hostname:	hostname,
+		Instance:	instance,
+	}
+
+	return keepalive, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import(
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"apputils"
+	"gamerpc"
+)
+
+type recordingTransport struct {
+	sent	[]*apputils.KeepAliveMessage
+}
+
+func (rt *recordingTransport) Send(km *apputils.KeepAliveMessage) error {
+	rt.sent = append(rt.sent, km)
+	return nil
+}
+
+func TestKeepAliveSendsStatus(t *testing.T) {
+	policy, err := apputils.NewTeamPolicy(2, 5, apputils.NO_TEAM_AUTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huntd := testHuntDaemon(
+		testPlayer("a", "0", gamerpc.C_PLAYER),
+		testPlayer("b", "1", gamerpc.C_PLAYER),
+		testPlayer("m", "1", gamerpc.C_MONITOR),
+	)
+	huntd.TeamPolicy = policy
+	huntd.Draining = true
+	huntd.started = time.Now().Add(-time.Minute)
+
+	transport := &recordingTransport{}
+	u, _ := url.Parse("http://game-3/jsonrpc")
+	keepAlive := &KeepAlive{Transport: transport, URL: u, Hostname: "game-3", Instance: "3"}
+
+	err = keepAlive.KeepAlive(7, huntd.Status())
+	if err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	if len(transport.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(transport.sent))
+	}
+
+	km := transport.sent[0]
+	if km.Instance != "3" || km.Hostname != "game-3" || km.Seq != 7 || km.URL != u {
+		t.Errorf("message = %+v", km)
+	}
+
+	status := km.Status
+	if status.Players != 3 || status.MaxPlayers != HuntdMaxPlayers || !status.Draining || status.TeamPolicy != policy {
+		t.Errorf("status = %+v", status)
+	}
+	if status.Version != Version || status.Protocol != HUNTD_PROTOCOL || status.Uptime < 60 {
+		t.Errorf("status version %q protocol %q uptime %d", status.Version, status.Protocol, status.Uptime)
+	}
+	if len(status.Rooms) != 1 {
+		t.Fatalf("%d rooms, want 1", len(status.Rooms))
+	}
+	room := status.Rooms[0]
+	if room.RoomID != DefaultRoomID || room.Players != 3 || len(room.Teams) != 2 || room.Teams["0"] != 1 || room.Teams["1"] != 1 {
+		t.Errorf("room = %+v", room)
+	}
+}
+
+func TestKeepAliveInstanceOverride(t *testing.T) {
+	defer os.Setenv("SERVER_GAME_INSTANCE", os.Getenv("SERVER_GAME_INSTANCE"))
+	os.Setenv("SERVER_GAME_INSTANCE", "7")
+
+	instance, err := keepAliveInstance()
+	if err != nil || instance != "7" {
+		t.Errorf("got %q %v, want 7", instance, err)
+	}
+
+	_, err = newKeepAliveTransport("carrier-pigeon")
+	if err == nil {
+		t.Errorf("unknown transport accepted")
+	}
+}
+
+func TestLocalKeepAliveTransport(t *testing.T) {
+	transport, err := newKeepAliveTransport("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport != apputils.LocalKeepAlive() {
+		t.Errorf("local transport is %T, want the process wide one", transport)
+	}
+
+	_, err = newKeepAliveTransport("carrier-pigeon")
+	if err == nil {
+		t.Errorf("unknown transport accepted")
+	}
+}
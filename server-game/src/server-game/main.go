@@ -18,7 +18,9 @@
 package main
 
 import(
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"flag"
 	"time"
@@ -27,31 +29,107 @@ import(
 	"net/http"
 	"strings"
 	"os"
-	"net/url"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
+	"apputils"
 	"byteutils"
 	"gamerpc"
 	"netutils"
 	"loggy"
-	"apputils"
 
 	"github.com/satori/go.uuid"
-	"golang.org/x/net/context"
-	"cloud.google.com/go/pubsub"
-	"cloud.google.com/go/compute/metadata"
 )
 
 const(
 	HuntdTimeout		= 1*1000 * time.Millisecond	// don't wait longer than this for any huntd I/O to complete
 	KeepAliveTimeout	= 1*10000 * time.Millisecond	// send a keepalive every 10 seconds
+	HuntdMaxPlayers		= 25				// MAXPL in huntd's hunt.h
+	DefaultRoomID		= "0"
+	HuntdRestartAttempts	= 10				// how many times to check on huntd while restarting it
+	HuntdRestartDelay	= 500 * time.Millisecond	// between attempts
+	ShutdownTimeout		= 10 * time.Second		// how long in-flight RPCs get to finish on SIGTERM
+	InputSeqWindow		= 64				// how many recent Input Seqs are remembered per player
+	ExchangeNoWait		= 10 * time.Millisecond		// how long an Exchange with no Wait left reads for game data already sent
+	ReadyCacheTime		= 5 * time.Second		// how long a readiness check is reused, probes and keepalives can be frequent
+	WellKnownDrainTimeout	= time.Millisecond		// how long to wait for stale well-known port replies before a request
 )
 
+var ErrGameDataTimeout = errors.New("timeout waiting for game data")
+var ErrAdminDisabled = errors.New("admin RPCs need authenticated requests, see SERVER_GAME_RPC_KEYS")
+
+// set at build time with -ldflags "-X main.Version=..."
+var Version = "dev"
+
 type Player struct {
 	ID		string
 	serverVersion	uint32
 	gameAddr	*net.TCPAddr
 	gameConn	*netutils.TimeoutTCPConn
 	joinRequest	gamerpc.JoinRequest
+	joined		time.Time
+	lastInput	int64	// unix nanoseconds, accessed atomically
+	lastSeen	int64	// unix nanoseconds, accessed atomically
+
+	stream		*GameStream				// game data from huntd, nil until joined
+	inputLock	sync.Mutex				// serializes Input, so a retry waits for the original
+	inputReplies	map[uint64]gamerpc.InputReply		// by Seq, for the most recent InputSeqWindow Seqs
+	inputSeqs	[]uint64				// the Seqs in inputReplies, oldest first
+}
+
+// whether Seq a comes before b, allowing for Seqs wrapping around
+func seqBefore(a uint64, b uint64) bool {
+	return int64(a - b) < 0
+}
+
+//
+// Returns the reply to an earlier request with the same Seq, if the request
+// is a duplicate.  Seqs too old to be remembered are also treated as
+// duplicates, since dropping keys is better than sending them twice.
+// Must be called with inputLock held.
+//
+func (p *Player) duplicateInput(seq uint64) (gamerpc.InputReply, bool) {
+	if seq == 0 {
+		return gamerpc.InputReply{}, false
+	}
+
+	reply, found := p.inputReplies[seq]
+	if found {
+		return reply, true
+	}
+
+	if len(p.inputSeqs) >= InputSeqWindow && seqBefore(seq, p.inputSeqs[0]) {
+		return gamerpc.InputReply{}, true
+	}
+
+	return gamerpc.InputReply{}, false
+}
+
+// Must be called with inputLock held.
+func (p *Player) rememberInput(seq uint64, reply gamerpc.InputReply) {
+	if seq == 0 {
+		return
+	}
+
+	if p.inputReplies == nil {
+		p.inputReplies = make(map[uint64]gamerpc.InputReply)
+	}
+
+	// keep inputSeqs sorted, so the oldest is evicted even if Seqs arrive out of order
+	i := sort.Search(len(p.inputSeqs), func(i int) bool { return !seqBefore(p.inputSeqs[i], seq) })
+	p.inputSeqs = append(p.inputSeqs, 0)
+	copy(p.inputSeqs[i + 1:], p.inputSeqs[i:])
+	p.inputSeqs[i] = seq
+	p.inputReplies[seq] = reply
+
+	if len(p.inputSeqs) > InputSeqWindow {
+		delete(p.inputReplies, p.inputSeqs[0])
+		p.inputSeqs = p.inputSeqs[1:]
+	}
 }
 
 type HuntDaemon struct {
@@ -61,11 +139,30 @@ type HuntDaemon struct {
 
 	wkAddr		*net.UDPAddr
 	wkConn		*netutils.TimeoutUDPConn
+	wkLock		sync.Mutex	// serializes requests on wkConn, so replies aren't mixed up
 
-	gameAddr	*net.TCPAddr
+	readyLock	sync.Mutex	// protects the cached readiness check
+	readyChecked	time.Time
+	readyErr	error
+
+	gameAddr	*net.TCPAddr	// protected by lock, they change if huntd is restarted
 	statsAddr	*net.TCPAddr
 
+	StopCommand	string		// shell command which stops huntd, "" if it can't be restarted
+	StartCommand	string		// shell command which starts huntd again once it has exited
+	AdminEnabled	bool		// whether the admin RPCs are allowed, only when requests are authenticated
+
+	RoomID		string
+	MaxPlayers	int
+	TeamPolicy	*apputils.TeamPolicy
+	Draining	bool
+	started		time.Time
+
+	joinLock	sync.Mutex	// serializes joins, so team assignment sees every player
+	lock		sync.Mutex	// protects Players, Draining and the huntd addresses
 	Players		map[string]*Player
+
+	streamStats	StreamStats
 }
 
 var logger = loggy.MustNewLoggerFromString(
@@ -86,11 +183,15 @@ var LOG_HUNTD_CONNECT	= logger.MustLevel("LOG_HUNTD_CONNECT")
 var LOG_PLAYER_API	= logger.MustLevel("LOG_PLAYER_API")
 var LOG_KEEPALIVE	= logger.MustLevel("LOG_KEEPALIVE")
 
-func NewHuntDaemon(host string, wkport string) (*HuntDaemon, error) {
+func NewHuntDaemon(host string, wkport string, maxPlayers int, teamPolicy *apputils.TeamPolicy) (*HuntDaemon, error) {
 	logger.Log(LOG_HUNTD_CONNECT, "Contacting huntd @ %s ...", wkport)
 
 	huntd := &HuntDaemon{
 		WellKnownPort:	wkport,
+		RoomID:		DefaultRoomID,
+		MaxPlayers:	maxPlayers,
+		TeamPolicy:	teamPolicy,
+		started:	time.Now(),
 		Players:	make(map[string]*Player),
 	}
 
@@ -109,42 +210,59 @@ func NewHuntDaemon(host string, wkport string) (*HuntDaemon, error) {
 
 	huntd.wkConn = netutils.NewTimeoutUDPConn(conn, HuntdTimeout)
 
-	logger.Log(LOG_HUNTD_CONNECT, "Requesting gameplay port")
-	gPort, wkpAddr, err := huntd.wkRequest(gamerpc.C_PLAYER)
+	err = huntd.discover()
 	if err != nil {
 		huntd.wkConn.Close()
 		return nil, err
 	}
+
+	return huntd, nil
+}
+
+// asks huntd for its gameplay and stats ports
+func (huntd *HuntDaemon) discover() error {
+	logger.Log(LOG_HUNTD_CONNECT, "Requesting gameplay port")
+	gPort, wkpAddr, err := huntd.wkRequest(gamerpc.C_PLAYER)
+	if err != nil {
+		return err
+	}
 	logger.Log(LOG_HUNTD_CONNECT, "gameplay port is %d on host %s", gPort, ReplacePort(wkpAddr.String(), ""))
 
 	logger.Log(LOG_HUNTD_CONNECT, "Requesting stats port")
 	sPort, statsAddr, err := huntd.wkRequest(gamerpc.C_SCORES)
 	if err != nil {
-		huntd.wkConn.Close()
-		return nil, err
+		return err
 	}
 	logger.Log(LOG_HUNTD_CONNECT, "statistics port is %d on host %s", sPort, ReplacePort(statsAddr.String(), ""))
 
 	gpstr := ReplacePort(wkpAddr.String(), fmt.Sprintf("%d", gPort))
-	huntd.gameAddr, err = net.ResolveTCPAddr("tcp", gpstr)
+	gameAddr, err := net.ResolveTCPAddr("tcp", gpstr)
 	if err != nil {
-		huntd.wkConn.Close()
-		return nil, err
+		return err
 	}
 
 	ststr := ReplacePort(statsAddr.String(), fmt.Sprintf("%d", sPort))
-	huntd.statsAddr, err = net.ResolveTCPAddr("tcp", ststr)
+	statsAddr2, err := net.ResolveTCPAddr("tcp", ststr)
 	if err != nil {
-		huntd.wkConn.Close()
-		return nil, err
+		return err
 	}
 
-	return huntd, nil
+	huntd.lock.Lock()
+	huntd.gameAddr = gameAddr
+	huntd.statsAddr = statsAddr2
+	huntd.lock.Unlock()
+
+	return nil
 }
 
 func (huntd *HuntDaemon) wkRequest(op uint16) (uint16, *net.UDPAddr, error) {
 	var err error
 
+	huntd.wkLock.Lock()
+	defer huntd.wkLock.Unlock()
+
+	huntd.wkDrain()
+
 	b := make([]byte, 2)
 	binary.BigEndian.PutUint16(b, op)
 
@@ -169,21 +287,49 @@ func (huntd *HuntDaemon) wkRequest(op uint16) (uint16, *net.UDPAddr, error) {
 	return binary.BigEndian.Uint16(rxBuf), fromAddr, nil
 }
 
+//
+// Discards replies to earlier requests which timed out, so that they aren't
+// taken for the reply to the next one.  Must be called with wkLock held.
+//
+func (huntd *HuntDaemon) wkDrain() {
+	rxBuf := make([]byte, 2)
+	for {
+		_, _, err := huntd.wkConn.ReadFromUDPTimeout(rxBuf, WellKnownDrainTimeout)
+		if err != nil {
+			return
+		}
+		logger.Log(LOG_HUNTD_CONNECT, "Discarded a stale reply from huntd's well-known port")
+	}
+}
+
 func (huntd *HuntDaemon) player(id string) (*Player, error) {
+	huntd.lock.Lock()
+	defer huntd.lock.Unlock()
+
 	player, found := huntd.Players[id]
 	if !found {
 		return nil, fmt.Errorf("%s: no such player", id)
 	}
 
+	atomic.StoreInt64(&player.lastSeen, time.Now().UnixNano())
+
 	return player, nil
 }
 
 
 func (huntd *HuntDaemon) newPlayer() (*Player, error) {
+	huntd.lock.Lock()
+	gameAddr := huntd.gameAddr
+	huntd.lock.Unlock()
+
+	now := time.Now()
+
 	player := &Player{
 		ID:		uuid.NewV4().String(),
-		gameAddr:	huntd.gameAddr,
+		gameAddr:	gameAddr,
 		gameConn:	nil,
+		joined:		now,
+		lastSeen:	now.UnixNano(),
 	}
 
 	logger.Log(LOG_PLAYER_API, "Created new player %s", player.ID)
@@ -348,27 +494,24 @@ func (p *Player) Join(joinRequest *gamerpc.JoinRequest, mstr string) error {
 	return nil
 }
 
-// Note: may block
-func (p *Player) GameData() (error, []byte, error) {
-	logger.Log(LOG_PLAYER_API, "Player %s: Request GameData", p.ID)
-
-	buf := make([]byte, 2048)
+// Note: blocks for up to wait if huntd hasn't sent anything
+//
+// Returns game data once at least minBytes have arrived or wait has passed.
+// Only whole opcodes are returned, an incomplete one at the end is held back
+// until the rest of it arrives.  Only returns a timeout error if nothing
+// complete arrived at all.
+//
+func (p *Player) GameData(wait time.Duration, minBytes int) (error, []byte, error) {
+	logger.Log(LOG_PLAYER_API, "Player %s: Request GameData wait %v min %d", p.ID, wait, minBytes)
 
-	n, err := p.gameConn.Read(buf)
-	logger.Log(LOG_PLAYER_API, "Player %s: Read GameData: n %d err %v", p.ID, n, err)
-	if err != nil {
-		nerr, isNetErr := err.(net.Error)
-		if isNetErr && nerr.Timeout() {
-			return err, nil, nil
-		}
-		return nil, nil, err
+	if p.stream == nil {
+		return nil, nil, fmt.Errorf("player %s hasn't joined", p.ID)
 	}
 
-	if n < 1 {
-		return nil, nil, fmt.Errorf("short read: got %d expected >= 1", n)
-	}
+	timeoutErr, data, err := p.stream.Read(wait, minBytes)
+	logger.Log(LOG_PLAYER_API, "Player %s: Read GameData: n %d timeout %v err %v", p.ID, len(data), timeoutErr, err)
 
-	return nil, buf[:n], nil
+	return timeoutErr, data, err
 }
 
 // Note: may block
@@ -405,9 +548,13 @@ func (p *Player) Close() error {
 }
 
 func (huntd *HuntDaemon) Stats(req *gamerpc.StatsRequest, reply *gamerpc.StatsReply) error {
-	logger.Log(LOG_RPC, "Contacting huntd stats @ %s\n", huntd.statsAddr)
+	huntd.lock.Lock()
+	statsAddr := huntd.statsAddr
+	huntd.lock.Unlock()
 
-	c, err := net.DialTCP("tcp", nil, huntd.statsAddr)
+	logger.Log(LOG_RPC, "Contacting huntd stats @ %s\n", statsAddr)
+
+	c, err := net.DialTCP("tcp", nil, statsAddr)
 	if err != nil {
 		return err
 	}
@@ -451,9 +598,44 @@ func (huntd *HuntDaemon) JMessage(r *http.Request, req *gamerpc.MessageRequest,
 	return huntd.Message(req, reply)
 }
 
+// players per team, not counting monitors, which watch rather than play
+func (huntd *HuntDaemon) teamCounts() map[string]int {
+	counts := make(map[string]int)
+
+	huntd.lock.Lock()
+	for _, player := range huntd.Players {
+		if player.joinRequest.ConnectMode == gamerpc.C_MONITOR {
+			continue
+		}
+		counts[TeamName(player.joinRequest.Team)]++
+	}
+	huntd.lock.Unlock()
+
+	return counts
+}
+
 func (huntd *HuntDaemon) Join(req *gamerpc.JoinRequest, reply *gamerpc.JoinReply) error {
 	logger.Log(LOG_RPC, "Join %s\n", req.Name)
 
+	huntd.joinLock.Lock()
+	defer huntd.joinLock.Unlock()
+
+	huntd.lock.Lock()
+	draining := huntd.Draining
+	huntd.lock.Unlock()
+	if draining {
+		return fmt.Errorf("instance is draining, not accepting new players")
+	}
+
+	team, err := huntd.TeamPolicy.Assign(req.Team, huntd.teamCounts())
+	if err != nil {
+		return err
+	}
+	if team != req.Team {
+		logger.Log(LOG_PLAYER_API, "Join %s: team '%s' assigned team '%s'", req.Name, req.Team, team)
+		req.Team = team
+	}
+
 	player, err := huntd.newPlayer()
 	if err != nil {
 		return err
@@ -464,7 +646,12 @@ func (huntd *HuntDaemon) Join(req *gamerpc.JoinRequest, reply *gamerpc.JoinReply
 		return err
 	}
 
+	player.stream = NewGameStream(player.ID, player.gameConn, &huntd.streamStats)
+	go player.stream.Run()
+
+	huntd.lock.Lock()
 	huntd.Players[player.ID] = player
+	huntd.lock.Unlock()
 
 	reply.Token = req.Token
 	reply.PlayerID = player.ID
@@ -479,9 +666,14 @@ func (huntd *HuntDaemon) JJoin(r *http.Request, req *gamerpc.JoinRequest, reply
 func (huntd *HuntDaemon) Quit(req *gamerpc.QuitRequest, reply *gamerpc.QuitReply) error {
 	logger.Log(LOG_RPC, "Quit %s\n", req.PlayerID)
 
+	huntd.lock.Lock()
 	player, found := huntd.Players[req.PlayerID]
 	if found {
 		delete(huntd.Players, req.PlayerID)
+	}
+	huntd.lock.Unlock()
+
+	if found {
 		player.Close()
 	}
 
@@ -495,16 +687,26 @@ func (huntd *HuntDaemon) JQuit(r *http.Request, req *gamerpc.QuitRequest, reply
 }
 
 func (huntd *HuntDaemon) GameData(req *gamerpc.GameDataRequest, reply *gamerpc.GameDataReply) error {
-	logger.Log(LOG_RPC, "GameData %s\n", req.PlayerID)
+	logger.Log(LOG_RPC, "GameData %s Wait %d MinBytes %d\n", req.PlayerID, req.Wait, req.MinBytes)
+
+	err := req.Validate()
+	if err != nil {
+		return err
+	}
 
 	player, err := huntd.player(req.PlayerID)
 	if err != nil {
 		return err
 	}
 
+	wait := time.Duration(req.Wait) * time.Millisecond
+	if wait == 0 {
+		wait = gamerpc.DefaultGameDataWait * time.Millisecond
+	}
+
 	var timeoutErr error
 	var data []byte
-	timeoutErr, data, err = player.GameData()
+	timeoutErr, data, err = player.GameData(wait, req.MinBytes)
 	if err != nil {
 		return err
 	}
@@ -514,16 +716,7 @@ func (huntd *HuntDaemon) GameData(req *gamerpc.GameDataRequest, reply *gamerpc.G
 		reply.TimeoutError = timeoutErr.Error()
 	}
 
-	//
-	// (yuck) we repack the 8 bit data into uint32 values because
-	// otherwise the handling in javascript out on the client side
-	// becomes incredibly complex
-	//
-	reply.Data = make([]uint32, len(data))
-	for i, v := range data {
-		reply.Data[i] = uint32(v)
-	}
-		
+	reply.Data, reply.Bytes = gamerpc.PackGameData(req.Encoding, data)
 	reply.Token = req.Token
 
 	return nil
@@ -533,26 +726,114 @@ func (huntd *HuntDaemon) JGameData(r *http.Request, req *gamerpc.GameDataRequest
 	return huntd.GameData(req, reply)
 }
 
-func (huntd *HuntDaemon) Input(req *gamerpc.InputRequest, reply *gamerpc.InputReply) error {
-	logger.Log(LOG_RPC, "Input %s Keys %s\n", req.PlayerID, req.Keys)
+//
+// Input followed by GameData in one round trip.  The keys are sent first,
+// so the reply can already show what they did.  Wait covers the whole call,
+// so a batch of keys can't push it past the frontend's fetch deadline, after
+// which the game data read for the reply would be lost.
+//
+func (huntd *HuntDaemon) Exchange(req *gamerpc.ExchangeRequest, reply *gamerpc.ExchangeReply) error {
+	logger.Log(LOG_RPC, "Exchange %s Seq %d Wait %d\n", req.PlayerID, req.Seq, req.Wait)
+
+	deadline := time.Now().Add(time.Duration(req.Wait) * time.Millisecond)
+
+	err := req.Validate()
+	if err != nil {
+		return err
+	}
+
+	input := req.InputRequest()
+	if input != nil {
+		reply.Input = &gamerpc.InputReply{}
+		err = huntd.Input(input, reply.Input)
+		if err != nil {
+			return err
+		}
+	}
 
 	player, err := huntd.player(req.PlayerID)
 	if err != nil {
 		return err
 	}
 
+	wait := time.Until(deadline)
+	if wait < ExchangeNoWait {
+		wait = ExchangeNoWait
+	}
+
 	var timeoutErr error
-	timeoutErr, err = player.Input(req.Keys)
+	var data []byte
+	timeoutErr, data, err = player.GameData(wait, req.MinBytes)
 	if err != nil {
 		return err
 	}
 
+	reply.Timeout = timeoutErr != nil
+	reply.Data, reply.Bytes = gamerpc.PackGameData(req.Encoding, data)
+	reply.Token = req.Token
+
+	return nil
+}
+
+func (huntd *HuntDaemon) JExchange(r *http.Request, req *gamerpc.ExchangeRequest, reply *gamerpc.ExchangeReply) error {
+	return huntd.Exchange(req, reply)
+}
+
+//
+// A batch of keys is fed to huntd at the intended cadence, so the RPC takes
+// as long as the batch spans.  Batches for a player are sent one after the
+// other, in the order they arrive.
+//
+func (huntd *HuntDaemon) Input(req *gamerpc.InputRequest, reply *gamerpc.InputReply) error {
+	logger.Log(LOG_RPC, "Input %s Seq %d Keys %s Events %d\n", req.PlayerID, req.Seq, req.Keys, len(req.Events))
+
+	err := req.Validate()
+	if err != nil {
+		return err
+	}
+
+	player, err := huntd.player(req.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	player.inputLock.Lock()
+	defer player.inputLock.Unlock()
+
+	previous, duplicate := player.duplicateInput(req.Seq)
+	if duplicate {
+		logger.Log(LOG_PLAYER_API, "Player %s: Ignore duplicate Input Seq %d", player.ID, req.Seq)
+		*reply = previous
+		reply.Duplicate = true
+		reply.Token = req.Token
+		return nil
+	}
+
 	reply.Timeout = false
-	if timeoutErr != nil {
-		reply.Timeout = true
-		reply.TimeoutError = timeoutErr.Error()
+
+	start := time.Now()
+	for _, event := range req.Batch() {
+		delay := time.Until(start.Add(time.Duration(event.Offset) * time.Millisecond))
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		atomic.StoreInt64(&player.lastInput, time.Now().UnixNano())
+
+		var timeoutErr error
+		timeoutErr, err = player.Input(event.Keys)
+		if err != nil {
+			return err
+		}
+
+		if timeoutErr != nil && !reply.Timeout {
+			reply.Timeout = true
+			reply.TimeoutError = timeoutErr.Error()
+		}
 	}
 
+	player.rememberInput(req.Seq, *reply)
+
 	reply.Token = req.Token
 
 	return nil
@@ -574,102 +855,339 @@ func (huntd *HuntDaemon) JPing(r *http.Request, req *gamerpc.PingRequest, reply
 	return huntd.Ping(req, reply)
 }
 
-// it's ok for port to be empty, which simply strips it off
-func ReplacePort(addr string, port string) string {
-	if port != "" {
-		port = ":" + port
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, ns)
+}
 
-	i := strings.LastIndex(addr, ":")
-	if i < 0 {
-		return addr + port
+func (huntd *HuntDaemon) ListPlayers(req *gamerpc.PlayersRequest, reply *gamerpc.PlayersReply) error {
+	logger.Log(LOG_RPC, "ListPlayers")
+
+	// PlayerIDs are enough to control a player
+	if !huntd.AdminEnabled {
+		return ErrAdminDisabled
+	}
+
+	huntd.lock.Lock()
+	reply.Players = make([]*gamerpc.PlayerInfo, 0, len(huntd.Players))
+	for _, player := range huntd.Players {
+		info := &gamerpc.PlayerInfo{
+			PlayerID:	player.ID,
+			Name:		player.joinRequest.Name,
+			Team:		TeamName(player.joinRequest.Team),
+			Uid:		player.joinRequest.Uid,
+			ConnectMode:	player.joinRequest.ConnectMode,
+			Joined:		player.joined,
+			LastInput:	unixNanoTime(atomic.LoadInt64(&player.lastInput)),
+			LastSeen:	unixNanoTime(atomic.LoadInt64(&player.lastSeen)),
+		}
+		if player.stream != nil {
+			info.Coalesced = player.stream.Coalesced()
+		}
+		reply.Players = append(reply.Players, info)
 	}
+	huntd.lock.Unlock()
 
-	return addr[:i] + port
+	reply.Token = req.Token
+
+	return nil
 }
 
-type KeepAlive struct {
-	Topic		*pubsub.Topic
-	URL		*url.URL
-	Hostname	string
-	Instance	string
-	MsgData		[]byte
+func (huntd *HuntDaemon) JListPlayers(r *http.Request, req *gamerpc.PlayersRequest, reply *gamerpc.PlayersReply) error {
+	return huntd.ListPlayers(req, reply)
 }
 
-func (keepAlive *KeepAlive) KeepAlive(seq uint64) error {
-	msg := &pubsub.Message{
-		Attributes: map[string]string{
-			"hostname":	keepAlive.Hostname,
-			"instance":	keepAlive.Instance,
-			"seq":		fmt.Sprintf("%d", seq),
-		},
-		Data: keepAlive.MsgData,
+// Like Quit, but an error if the player doesn't exist
+func (huntd *HuntDaemon) Kick(req *gamerpc.KickRequest, reply *gamerpc.KickReply) error {
+	logger.Log(LOG_RPC, "Kick %s\n", req.PlayerID)
+
+	if !huntd.AdminEnabled {
+		return ErrAdminDisabled
 	}
 
-	msgIDs, err := keepAlive.Topic.Publish(context.Background(), msg)
-	if err != nil {
-		return err
+	huntd.lock.Lock()
+	player, found := huntd.Players[req.PlayerID]
+	if found {
+		delete(huntd.Players, req.PlayerID)
+	}
+	huntd.lock.Unlock()
+
+	if !found {
+		return fmt.Errorf("%s: no such player", req.PlayerID)
+	}
+
+	player.Close()
+
+	reply.Token = req.Token
+
+	return nil
+}
+
+func (huntd *HuntDaemon) JKick(r *http.Request, req *gamerpc.KickRequest, reply *gamerpc.KickReply) error {
+	return huntd.Kick(req, reply)
+}
+
+// A draining instance keeps its players but refuses new ones
+func (huntd *HuntDaemon) Drain(req *gamerpc.DrainRequest, reply *gamerpc.DrainReply) error {
+	logger.Log(LOG_RPC, "Drain %v\n", req.Draining)
+
+	if !huntd.AdminEnabled {
+		return ErrAdminDisabled
 	}
 
-	logger.Log(LOG_KEEPALIVE, "Published seqid %d msgid %v", seq, msgIDs)
+	huntd.lock.Lock()
+	huntd.Draining = req.Draining
+	huntd.lock.Unlock()
+
+	reply.Token = req.Token
+	reply.Draining = req.Draining
 
 	return nil
 }
 
-func NewKeepAlive(topic string, gameURL string) (*KeepAlive, error) {
-	if topic == "" {
-		return nil, fmt.Errorf("missing keepalive topic") 
+func (huntd *HuntDaemon) JDrain(r *http.Request, req *gamerpc.DrainRequest, reply *gamerpc.DrainReply) error {
+	return huntd.Drain(req, reply)
+}
+
+// whether huntd answers on the well known port
+func (huntd *HuntDaemon) answering() bool {
+	_, _, err := huntd.wkRequest(gamerpc.C_SCORES)
+	return err == nil
+}
+
+//
+// Disconnects every player and stops huntd with StopCommand.  Once the old
+// huntd has stopped answering on the well known port, and so has let go of
+// it, StartCommand starts a new one, and Restart waits for that to answer.
+//
+func (huntd *HuntDaemon) Restart(req *gamerpc.RestartRequest, reply *gamerpc.RestartReply) error {
+	logger.Log(LOG_RPC, "Restart\n")
+
+	if !huntd.AdminEnabled {
+		return ErrAdminDisabled
 	}
 
-	if gameURL == "" {
-		return nil, fmt.Errorf("missing gameurl")
+	if huntd.StopCommand == "" || huntd.StartCommand == "" {
+		return fmt.Errorf("huntd restart not configured, see -huntd-stop-command and -huntd-start-command")
 	}
 
-	instance, err := apputils.GAEBackendInstance()
-	if err != nil {
-		return nil, err
+	huntd.joinLock.Lock()
+	defer huntd.joinLock.Unlock()
+
+	huntd.lock.Lock()
+	players := huntd.Players
+	huntd.Players = make(map[string]*Player)
+	huntd.lock.Unlock()
+
+	for _, player := range players {
+		player.Close()
 	}
 
-	ustr := strings.Replace(gameURL, "{{instance}}", instance, -1)
-	u, err := url.Parse(ustr)
+	// huntd is going away, and its ports may change
+	defer huntd.forgetReady()
+
+	out, err := exec.Command("/bin/sh", "-c", huntd.StopCommand).CombinedOutput()
+	logger.Log(LOG_HUNTD_CONNECT, "Restart: %s: %s", huntd.StopCommand, out)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("stop command failed: %v", err)
 	}
 
-	project, err := apputils.ProjectID()
+	for attempt := 1; huntd.answering(); attempt++ {
+		if attempt == HuntdRestartAttempts {
+			return fmt.Errorf("huntd still running after the stop command, not starting another")
+		}
+		time.Sleep(HuntdRestartDelay)
+	}
+
+	out, err = exec.Command("/bin/sh", "-c", huntd.StartCommand).CombinedOutput()
+	logger.Log(LOG_HUNTD_CONNECT, "Restart: %s: %s", huntd.StartCommand, out)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("start command failed: %v", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = huntd.discover()
+		if err == nil {
+			break
+		}
+		if attempt == HuntdRestartAttempts {
+			return fmt.Errorf("huntd didn't come back after restart: %v", err)
+		}
+		time.Sleep(HuntdRestartDelay)
 	}
 
-	hostname, err := metadata.Hostname()
+	reply.Token = req.Token
+
+	return nil
+}
+
+func (huntd *HuntDaemon) JRestart(r *http.Request, req *gamerpc.RestartRequest, reply *gamerpc.RestartReply) error {
+	return huntd.Restart(req, reply)
+}
+
+// The frontend's name for a huntd team: "0" .. "9" or "none"
+func TeamName(team string) string {
+	if team == "" || team == " " {
+		return "none"
+	}
+
+	return team
+}
+
+// Reported to the frontend with each keepalive
+func (huntd *HuntDaemon) Status() *apputils.InstanceStatus {
+	teams := huntd.teamCounts()
+
+	huntd.lock.Lock()
+	players := len(huntd.Players)
+	draining := huntd.Draining
+	huntd.lock.Unlock()
+
+	room := &apputils.RoomStatus{
+		RoomID:		huntd.RoomID,
+		Players:	players,
+		MaxPlayers:	huntd.MaxPlayers,
+		Teams:		teams,
+	}
+
+	status := &apputils.InstanceStatus{
+		Players:	players,
+		MaxPlayers:	huntd.MaxPlayers,
+		Rooms:		[]*apputils.RoomStatus{room},
+		Version:	Version,
+		Protocol:	HUNTD_PROTOCOL,
+		Uptime:		int64(time.Since(huntd.started) / time.Second),
+		Draining:	draining,
+		TeamPolicy:	huntd.TeamPolicy,
+	}
+
+	return status
+}
+
+//
+// huntd is ready if it answers on the well-known UDP port and accepts TCP
+// connections on the gameplay port.  The result is reused for ReadyCacheTime,
+// so that probes, /info and keepalives don't each bother huntd.
+//
+func (huntd *HuntDaemon) Ready() error {
+	huntd.readyLock.Lock()
+	defer huntd.readyLock.Unlock()
+
+	now := time.Now()
+	if !huntd.readyChecked.IsZero() && now.Sub(huntd.readyChecked) < ReadyCacheTime {
+		return huntd.readyErr
+	}
+
+	huntd.readyErr = huntd.checkReady()
+	huntd.readyChecked = now
+
+	return huntd.readyErr
+}
+
+// the next Ready checks huntd again
+func (huntd *HuntDaemon) forgetReady() {
+	huntd.readyLock.Lock()
+	huntd.readyChecked = time.Time{}
+	huntd.readyLock.Unlock()
+}
+
+//
+// The probe connection is closed before huntd is sent anything, which huntd
+// treats like a client that went away.
+//
+func (huntd *HuntDaemon) checkReady() error {
+	_, _, err := huntd.wkRequest(gamerpc.C_PLAYER)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("huntd well-known port %s: %v", huntd.wkAddr, err)
+	}
+
+	huntd.lock.Lock()
+	gameAddr := huntd.gameAddr
+	huntd.lock.Unlock()
+
+	if gameAddr == nil {
+		return fmt.Errorf("huntd gameplay port not discovered")
 	}
 
-	client, err := pubsub.NewClient(context.Background(), project)
+	conn, err := net.DialTimeout("tcp", gameAddr.String(), HuntdTimeout)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("huntd gameplay port %s: %v", gameAddr, err)
 	}
+	conn.Close()
+
+	return nil
+}
+
+// Served by the game server's /info
+func (huntd *HuntDaemon) Info() *gamerpc.ServerInfo {
+	status := huntd.Status()
 
-	t, err := client.CreateTopic(context.Background(), topic)
+	huntd.lock.Lock()
+	gameAddr := huntd.gameAddr
+	statsAddr := huntd.statsAddr
+	huntd.lock.Unlock()
+
+	info := &gamerpc.ServerInfo{
+		Version:	status.Version,
+		Uptime:		status.Uptime,
+		Huntd:		gamerpc.HuntdInfo{
+					WellKnownAddr:	huntd.wkAddr.String(),
+					Protocol:	status.Protocol,
+				},
+		Players:	status.Players,
+		MaxPlayers:	status.MaxPlayers,
+		Rooms:		len(status.Rooms),
+		Draining:	status.Draining,
+		Healthy:	true,
+		Coalesced:	atomic.LoadUint64(&huntd.streamStats.Coalesced),
+		CoalescedBytes:	atomic.LoadUint64(&huntd.streamStats.DroppedBytes),
+	}
+
+	if gameAddr != nil {
+		info.Huntd.GameAddr = gameAddr.String()
+	}
+	if statsAddr != nil {
+		info.Huntd.StatsAddr = statsAddr.String()
+	}
+
+	err := huntd.Ready()
 	if err != nil {
-		s := fmt.Sprintf("%v", err)
-		if !strings.Contains(s, "Resource already exists in the project") {
-			return nil, err
-		}
+		info.Healthy = false
+		info.Health = err.Error()
+	}
+
+	return info
+}
+
+// it's ok for port to be empty, which simply strips it off
+func ReplacePort(addr string, port string) string {
+	if port != "" {
+		port = ":" + port
+	}
 
-		t = client.Topic(topic)
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return addr + port
 	}
 
-	keepalive :=  &KeepAlive{
-		Topic:		t,
-		URL:		u,
-		Hostname:	hostname,
-		Instance:	instance,
-		MsgData:	[]byte(u.String()),
+	return addr[:i] + port
+}
+
+func shutdownOnSignal(sigc chan os.Signal, server *gamerpc.GameServer) {
+	sig := <-sigc
+	logger.Log(LOG_STARTUP, "%v: shutting down\n", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		logger.Fatalf("Shutdown: %v", err)
 	}
 
-	return keepalive, nil
+	os.Exit(0)
 }
 
 func main() {
@@ -678,6 +1196,15 @@ func main() {
 	var huntdHost string
 	var huntdPort string
 	var rpcType string
+	var maxPlayers int
+	var teams int
+	var maxTeamSize int
+	var noTeam string
+	var tlsCert string
+	var tlsKey string
+	var tlsClientCA string
+	var stopCommand string
+	var startCommand string
 	var err error
 	var huntd *HuntDaemon
 	var server *gamerpc.GameServer
@@ -688,6 +1215,15 @@ func main() {
 	flag.StringVar(&huntdHost,  "huntd-well-known-host", "localhost", "'well known' hostname/address huntd listens on")
 	flag.StringVar(&huntdPort,  "huntd-well-known-port", "", "UDP 'well known' port huntd listens on")
 	flag.StringVar(&rpcType,    "rpc-type", "netrpc", "'netrpc' for golang stdlib or 'jsonrpc' for jsonrpc")
+	flag.IntVar(&maxPlayers,    "max-players", HuntdMaxPlayers, "maximum number of players huntd allows")
+	flag.IntVar(&teams,         "teams", 2, "number of teams players joining with team 'auto' are balanced across")
+	flag.IntVar(&maxTeamSize,   "max-team-size", 0, "maximum number of players per team, 0 for no limit")
+	flag.StringVar(&noTeam,     "no-team", apputils.NO_TEAM_ALLOW, "players joining without a team: 'allow', 'auto' to assign one, or 'reject'")
+	flag.StringVar(&stopCommand, "huntd-stop-command", "", "shell command which stops huntd, for the admin API's restart")
+	flag.StringVar(&startCommand, "huntd-start-command", "", "shell command which starts huntd after -huntd-stop-command")
+	flag.StringVar(&tlsCert,    "tls-cert", "", "PEM certificate to serve frontend rpcs over TLS with")
+	flag.StringVar(&tlsKey,     "tls-key", "", "PEM private key for -tls-cert")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "PEM CA bundle; if set, frontends must present a client certificate signed by it")
 
 	flag.Parse()
 
@@ -705,29 +1241,58 @@ func main() {
 
 	ShowBugs()
 
-	huntd, err = NewHuntDaemon(huntdHost, huntdPort)
+	teamPolicy, err := apputils.NewTeamPolicy(teams, maxTeamSize, noTeam)
+	if err != nil {
+		logger.Fatalf("NewTeamPolicy: %v", err)
+	}
+
+	huntd, err = NewHuntDaemon(huntdHost, huntdPort, maxPlayers, teamPolicy)
 	if err != nil {
 		logger.Fatalf("NewHuntDaemon: %v", err)
 	}
+	huntd.StopCommand = stopCommand
+	huntd.StartCommand = startCommand
 
 	logger.Log(LOG_STARTUP, "huntd: %v\n", huntd)
 
-	server, err = gamerpc.NewGameServer(listenHost, listenPort, rpcType, huntd, KeepAliveTimeout, eventc)
+	// any of the listed keys is accepted, which allows them to be rotated, see apputils/requestauth.go
+	auth, err := apputils.NewRequestVerifier(os.Getenv("SERVER_GAME_RPC_KEYS"))
+	if err != nil {
+		logger.Fatalf("SERVER_GAME_RPC_KEYS: %v", err)
+	}
+	if auth == nil {
+		logger.Log(LOG_STARTUP, "SERVER_GAME_RPC_KEYS not set, requests will not be authenticated and admin RPCs are disabled\n")
+	}
+	huntd.AdminEnabled = auth != nil
+
+	tlsConfig, err := apputils.NewServerTLSConfig(tlsCert, tlsKey, tlsClientCA)
+	if err != nil {
+		logger.Fatalf("TLS config: %v", err)
+	}
+
+	server, err = gamerpc.NewGameServer(listenHost, listenPort, rpcType, huntd, KeepAliveTimeout, eventc, auth, tlsConfig)
 	if err != nil {
 		logger.Fatalf("NewGameServer: %v", err)
 	}
 	logger.Log(LOG_STARTUP, "server: %v\n", server)
 
 	var keepalive *KeepAlive
-	topic := os.Getenv("SERVER_KEEPALIVE_TOPIC");
-	if topic != "" {
-		keepalive, err = NewKeepAlive(os.Getenv("SERVER_KEEPALIVE_TOPIC"), os.Getenv("SERVER_GAME_URL"))
+	transport := os.Getenv("SERVER_KEEPALIVE_TRANSPORT")
+	if transport == "" && os.Getenv("SERVER_KEEPALIVE_TOPIC") != "" {
+		transport = "pubsub"
+	}
+	if transport != "" {
+		keepalive, err = NewKeepAlive(transport, os.Getenv("SERVER_GAME_URL"))
 		if err != nil {
 			logger.Fatalf("NewKeepAlive: %v", err)
 		}
 	}
 	logger.Log(LOG_STARTUP, "keepalive: %v\n", keepalive)
 
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, os.Interrupt)
+	go shutdownOnSignal(sigc, server)
+
 	for {
 		event := <- eventc
 		logger.Log(LOG_EVENT, "event %T %v\n", event, event)
@@ -744,7 +1309,13 @@ func main() {
 				logger.Log(LOG_KEEPALIVE, "KeepaliveRequest ignored: %v", t)
 				break
 			}
-			err := keepalive.KeepAlive(t.Seq)
+			// stop advertising the instance while huntd can't serve games
+			err := huntd.Ready()
+			if err != nil {
+				logger.Log(LOG_KEEPALIVE, "Keepalive %d withheld: %v", t.Seq, err)
+				break
+			}
+			err = keepalive.KeepAlive(t.Seq, huntd.Status())
 			if err != nil {
 				logger.Log(LOG_KEEPALIVE, "Keepalive failed: %v", err)
 			}
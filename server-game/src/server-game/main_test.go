@@ -0,0 +1,698 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import(
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"apputils"
+	"gamerpc"
+	"netutils"
+)
+
+func testPlayer(id string, team string, connectMode uint32) *Player {
+	return &Player{
+		ID:		id,
+		joinRequest:	gamerpc.JoinRequest{Team: team, ConnectMode: connectMode},
+	}
+}
+
+func testHuntDaemon(players ...*Player) *HuntDaemon {
+	huntd := &HuntDaemon{
+		RoomID:		DefaultRoomID,
+		MaxPlayers:	HuntdMaxPlayers,
+		Players:	make(map[string]*Player),
+	}
+
+	for _, player := range players {
+		huntd.Players[player.ID] = player
+	}
+
+	return huntd
+}
+
+//
+// A joined player whose game connection leads to the returned conn, which
+// stands in for huntd: game data written to it reaches the player's stream,
+// and keys sent by the player can be read from it.
+//
+func testJoinedPlayer(t *testing.T, id string) (*Player, *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	player := testPlayer(id, "0", gamerpc.C_PLAYER)
+	player.gameConn = netutils.NewTimeoutTCPConn(conn, HuntdTimeout)
+	player.stream = NewGameStream(id, player.gameConn, &StreamStats{})
+	go player.stream.Run()
+
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+
+	return player, peer
+}
+
+func TestTeamCountsSkipMonitors(t *testing.T) {
+	huntd := testHuntDaemon(
+		testPlayer("a", "0", gamerpc.C_PLAYER),
+		testPlayer("b", " ", gamerpc.C_PLAYER),
+		testPlayer("m1", "1", gamerpc.C_MONITOR),
+		testPlayer("m2", "1", gamerpc.C_MONITOR),
+	)
+
+	counts := huntd.teamCounts()
+	if len(counts) != 2 || counts["0"] != 1 || counts["none"] != 1 {
+		t.Fatalf("got %v, want map[0:1 none:1]", counts)
+	}
+
+	// spectators don't make team 1 look bigger than it is
+	policy, err := apputils.NewTeamPolicy(2, 0, apputils.NO_TEAM_ALLOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team, err := policy.Assign(apputils.TEAM_AUTO, counts)
+	if err != nil || team != "1" {
+		t.Fatalf("auto team: got '%s' %v, want 1", team, err)
+	}
+}
+
+func TestAdminRPCsNeedAuth(t *testing.T) {
+	huntd := testHuntDaemon(testPlayer("a", "0", gamerpc.C_PLAYER))
+	huntd.StopCommand = "false"
+	huntd.StartCommand = "false"
+
+	errs := map[string]error{
+		"ListPlayers":	huntd.ListPlayers(&gamerpc.PlayersRequest{}, &gamerpc.PlayersReply{}),
+		"Kick":		huntd.Kick(&gamerpc.KickRequest{PlayerID: "a"}, &gamerpc.KickReply{}),
+		"Drain":	huntd.Drain(&gamerpc.DrainRequest{Draining: true}, &gamerpc.DrainReply{}),
+		"Restart":	huntd.Restart(&gamerpc.RestartRequest{}, &gamerpc.RestartReply{}),
+	}
+	for name, err := range errs {
+		if err != ErrAdminDisabled {
+			t.Errorf("%s: got %v, want %v", name, err, ErrAdminDisabled)
+		}
+	}
+
+	if len(huntd.Players) != 1 || huntd.Draining {
+		t.Errorf("admin RPCs changed the game: players %d draining %v", len(huntd.Players), huntd.Draining)
+	}
+
+	huntd.AdminEnabled = true
+	err := huntd.Drain(&gamerpc.DrainRequest{Draining: true}, &gamerpc.DrainReply{})
+	if err != nil || !huntd.Draining {
+		t.Errorf("Drain with admin enabled: %v draining %v", err, huntd.Draining)
+	}
+}
+
+//
+// Stands in for huntd on the well known port.  The "old" huntd keeps
+// answering for a few requests after the stop command has run, as a
+// huntd that takes a moment to exit would, and a "new" one takes over the
+// port once the start command has run.
+//
+type fakeHuntd struct {
+	addr		*net.UDPAddr
+	dir		string
+	linger		int
+	startedEarly	int32	// set if the start command ran while the old huntd was still up
+	done		chan struct{}
+}
+
+func (f *fakeHuntd) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(f.dir, name))
+	return err == nil
+}
+
+// answers well known port requests until stop returns true
+func (f *fakeHuntd) serve(conn *net.UDPConn, stop func() bool) {
+	defer conn.Close()
+
+	b := make([]byte, 2)
+	for !stop() {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, from, err := conn.ReadFromUDP(b)
+		if err != nil {
+			continue
+		}
+
+		reply := make([]byte, 2)
+		binary.BigEndian.PutUint16(reply, uint16(f.addr.Port + 1))
+		conn.WriteToUDP(reply, from)
+
+		if f.exists("stopped") {
+			f.linger--
+		}
+	}
+}
+
+func (f *fakeHuntd) run(conn *net.UDPConn) {
+	f.serve(conn, func() bool {
+		if f.exists("started") {
+			atomic.StoreInt32(&f.startedEarly, 1)
+		}
+		return f.exists("stopped") && f.linger < 0
+	})
+
+	for !f.exists("started") {
+		select {
+		case <-f.done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", f.addr)
+	if err != nil {
+		return
+	}
+	f.serve(conn, func() bool {
+		select {
+		case <-f.done:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func TestRestartWaitsForOldHuntd(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeHuntd{
+		addr:	conn.LocalAddr().(*net.UDPAddr),
+		dir:	t.TempDir(),
+		linger:	2,
+		done:	make(chan struct{}),
+	}
+	defer close(fake.done)
+	go fake.run(conn)
+
+	policy, err := apputils.NewTeamPolicy(2, 0, apputils.NO_TEAM_ALLOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huntd, err := NewHuntDaemon("127.0.0.1", strconv.Itoa(fake.addr.Port), HuntdMaxPlayers, policy)
+	if err != nil {
+		t.Fatalf("NewHuntDaemon: %v", err)
+	}
+	huntd.AdminEnabled = true
+	huntd.StopCommand = "touch " + filepath.Join(fake.dir, "stopped")
+	huntd.StartCommand = "touch " + filepath.Join(fake.dir, "started")
+	huntd.Players["a"] = testPlayer("a", "0", gamerpc.C_PLAYER)
+
+	err = huntd.Restart(&gamerpc.RestartRequest{}, &gamerpc.RestartReply{})
+	if err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+
+	if atomic.LoadInt32(&fake.startedEarly) != 0 {
+		t.Errorf("start command ran before the old huntd exited")
+	}
+	if len(huntd.Players) != 0 {
+		t.Errorf("%d players survived the restart", len(huntd.Players))
+	}
+	if !huntd.answering() {
+		t.Errorf("new huntd not answering after Restart")
+	}
+}
+
+func TestRestartRefusesWhileOldHuntdRuns(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeHuntd{
+		addr:	conn.LocalAddr().(*net.UDPAddr),
+		dir:	t.TempDir(),
+		linger:	1 << 20,	// never exits
+		done:	make(chan struct{}),
+	}
+	defer close(fake.done)
+	go fake.serve(conn, func() bool {
+		select {
+		case <-fake.done:
+			return true
+		default:
+			return false
+		}
+	})
+
+	policy, err := apputils.NewTeamPolicy(2, 0, apputils.NO_TEAM_ALLOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huntd, err := NewHuntDaemon("127.0.0.1", strconv.Itoa(fake.addr.Port), HuntdMaxPlayers, policy)
+	if err != nil {
+		t.Fatalf("NewHuntDaemon: %v", err)
+	}
+	huntd.AdminEnabled = true
+	huntd.StopCommand = "true"
+	huntd.StartCommand = "touch " + filepath.Join(fake.dir, "started")
+
+	err = huntd.Restart(&gamerpc.RestartRequest{}, &gamerpc.RestartReply{})
+	if err == nil {
+		t.Fatalf("Restart succeeded with the old huntd still running")
+	}
+	if fake.exists("started") {
+		t.Errorf("start command ran while the old huntd was still running")
+	}
+}
+
+//
+// A HuntDaemon that discovered a stand-in huntd: the returned listener is its
+// gameplay port, and the well known port stops answering once silent is set.
+//
+func testDiscoveredHuntDaemon(t *testing.T) (*HuntDaemon, *net.TCPListener, *int32) {
+	game, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { game.Close() })
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	silent := new(int32)
+	go func() {
+		b := make([]byte, 2)
+		for {
+			_, from, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(silent) != 0 {
+				continue
+			}
+			reply := make([]byte, 2)
+			binary.BigEndian.PutUint16(reply, uint16(game.Addr().(*net.TCPAddr).Port))
+			conn.WriteToUDP(reply, from)
+		}
+	}()
+
+	policy, err := apputils.NewTeamPolicy(2, 0, apputils.NO_TEAM_ALLOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huntd, err := NewHuntDaemon("127.0.0.1", strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port), HuntdMaxPlayers, policy)
+	if err != nil {
+		t.Fatalf("NewHuntDaemon: %v", err)
+	}
+
+	return huntd, game, silent
+}
+
+func TestReady(t *testing.T) {
+	huntd, game, silent := testDiscoveredHuntDaemon(t)
+
+	err := huntd.Ready()
+	if err != nil {
+		t.Fatalf("Ready: %v", err)
+	}
+
+	// the answer is reused for a while
+	game.Close()
+	err = huntd.Ready()
+	if err != nil {
+		t.Fatalf("Ready within ReadyCacheTime: %v", err)
+	}
+
+	huntd.forgetReady()
+	err = huntd.Ready()
+	if err == nil || !strings.Contains(err.Error(), "gameplay port") {
+		t.Errorf("Ready with the gameplay port closed: %v", err)
+	}
+
+	atomic.StoreInt32(silent, 1)
+	huntd.forgetReady()
+	err = huntd.Ready()
+	if err == nil || !strings.Contains(err.Error(), "well-known port") {
+		t.Errorf("Ready with huntd not answering: %v", err)
+	}
+}
+
+func TestWellKnownStaleReplies(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// answers each request with its op, the first one too late
+	go func() {
+		b := make([]byte, 2)
+		for first := true; ; first = false {
+			_, from, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			if first {
+				time.Sleep(HuntdTimeout + 100 * time.Millisecond)
+			}
+			conn.WriteToUDP(b, from)
+		}
+	}()
+
+	wk, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	huntd := &HuntDaemon{wkConn: netutils.NewTimeoutUDPConn(wk, HuntdTimeout)}
+	defer huntd.wkConn.Close()
+
+	_, _, err = huntd.wkRequest(gamerpc.C_PLAYER)
+	if err == nil {
+		t.Fatalf("first request answered in time")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// the late reply to the first request is there to be read, but isn't taken for this one's
+	op, _, err := huntd.wkRequest(gamerpc.C_SCORES)
+	if err != nil || op != gamerpc.C_SCORES {
+		t.Fatalf("got %d %v, want the reply to C_SCORES (%d)", op, err, gamerpc.C_SCORES)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	huntd, game, _ := testDiscoveredHuntDaemon(t)
+	huntd.Players["a"] = testPlayer("a", "0", gamerpc.C_PLAYER)
+	huntd.Draining = true
+
+	info := huntd.Info()
+	if !info.Healthy || info.Health != "" {
+		t.Errorf("healthy huntd: Healthy %v Health %q", info.Healthy, info.Health)
+	}
+	if info.Players != 1 || info.MaxPlayers != HuntdMaxPlayers || info.Rooms != 1 || !info.Draining {
+		t.Errorf("info = %+v", info)
+	}
+	if info.Huntd.GameAddr != game.Addr().String() || info.Huntd.WellKnownAddr != huntd.wkAddr.String() || info.Huntd.StatsAddr == "" {
+		t.Errorf("huntd addresses = %+v", info.Huntd)
+	}
+
+	game.Close()
+	huntd.forgetReady()
+	info = huntd.Info()
+	if info.Healthy || !strings.Contains(info.Health, "gameplay port") {
+		t.Errorf("gameplay port closed: Healthy %v Health %q", info.Healthy, info.Health)
+	}
+}
+
+func TestInputReplayedSeq(t *testing.T) {
+	player := testPlayer("a", "0", gamerpc.C_PLAYER)
+	huntd := testHuntDaemon(player)
+
+	// the first request timed out writing to huntd
+	player.rememberInput(5, gamerpc.InputReply{Timeout: true, TimeoutError: "slow"})
+
+	// a replay gets the same answer, without the keys reaching huntd (there's no connection to send them on)
+	var reply gamerpc.InputReply
+	err := huntd.Input(&gamerpc.InputRequest{Token: 9, PlayerID: "a", Keys: "h", Seq: 5}, &reply)
+	if err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	if !reply.Duplicate || !reply.Timeout || reply.TimeoutError != "slow" || reply.Token != 9 {
+		t.Errorf("reply = %+v, want the first reply marked Duplicate", reply)
+	}
+}
+
+func TestInputSeqWindow(t *testing.T) {
+	player := testPlayer("a", "0", gamerpc.C_PLAYER)
+
+	// out of order, the window still keeps the newest
+	for seq := uint64(InputSeqWindow + 10); seq > 0; seq-- {
+		player.rememberInput(seq, gamerpc.InputReply{})
+	}
+	if len(player.inputSeqs) != InputSeqWindow || player.inputSeqs[0] != 11 {
+		t.Fatalf("remembered %d Seqs from %d, want %d from 11", len(player.inputSeqs), player.inputSeqs[0], InputSeqWindow)
+	}
+
+	tests := []struct {
+		seq		uint64
+		duplicate	bool
+	}{
+		{0,			false},	// not de-duplicated
+		{1,			true},	// older than the window, dropped
+		{10,			true},
+		{11,			true},	// oldest remembered
+		{InputSeqWindow + 10,	true},	// newest remembered
+		{InputSeqWindow + 11,	false},	// new
+	}
+
+	for _, test := range tests {
+		_, duplicate := player.duplicateInput(test.seq)
+		if duplicate != test.duplicate {
+			t.Errorf("Seq %d: duplicate %v, want %v", test.seq, duplicate, test.duplicate)
+		}
+	}
+}
+
+func TestInputSeqWraparound(t *testing.T) {
+	player := testPlayer("a", "0", gamerpc.C_PLAYER)
+
+	// Seqs up to the largest uint64, then wrapping around past 0
+	seq := uint64(math.MaxUint64 - InputSeqWindow/2)
+	for i := 0; i < InputSeqWindow; i++ {
+		seq++
+		if seq == 0 {
+			seq++
+		}
+		player.rememberInput(seq, gamerpc.InputReply{})
+	}
+	newest := seq
+
+	for _, s := range []uint64{math.MaxUint64, 1, newest} {
+		_, duplicate := player.duplicateInput(s)
+		if !duplicate {
+			t.Errorf("remembered Seq %d not a duplicate", s)
+		}
+	}
+
+	// Seqs after the wrap are new, not older than the window
+	_, duplicate := player.duplicateInput(newest + 1)
+	if duplicate {
+		t.Errorf("Seq %d after wrapping treated as a duplicate", newest + 1)
+	}
+
+	player.rememberInput(newest + 1, gamerpc.InputReply{})
+	if player.inputSeqs[len(player.inputSeqs) - 1] != newest + 1 {
+		t.Errorf("Seq %d not kept as the newest: %v", newest + 1, player.inputSeqs)
+	}
+
+	// the oldest was evicted, and Seqs before it are too old
+	_, duplicate = player.duplicateInput(math.MaxUint64 - InputSeqWindow)
+	if !duplicate {
+		t.Errorf("Seq before the window not treated as a duplicate")
+	}
+	_, found := player.inputReplies[math.MaxUint64 - InputSeqWindow/2 + 1]
+	if found {
+		t.Errorf("oldest Seq not evicted")
+	}
+}
+
+func TestGameDataWaitAndMinBytes(t *testing.T) {
+	player, peer := testJoinedPlayer(t, "a")
+	huntd := testHuntDaemon(player)
+
+	gameData := func(wait int64, minBytes int) (*gamerpc.GameDataReply, time.Duration) {
+		req := &gamerpc.GameDataRequest{PlayerID: "a", Wait: wait, MinBytes: minBytes, Encoding: gamerpc.GAMEDATA_BYTES}
+
+		start := time.Now()
+		var reply gamerpc.GameDataReply
+		err := huntd.GameData(req, &reply)
+		if err != nil {
+			t.Fatalf("GameData wait %d min %d: %v", wait, minBytes, err)
+		}
+
+		return &reply, time.Since(start)
+	}
+
+	// returns as soon as MinBytes have arrived
+	peer.Write([]byte("ab"))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		peer.Write([]byte("cd"))
+	}()
+	reply, elapsed := gameData(1000, 4)
+	if string(reply.Bytes) != "abcd" || reply.Timeout || elapsed < 100 * time.Millisecond || elapsed > 500 * time.Millisecond {
+		t.Errorf("MinBytes 4: got %q timeout %v after %v", reply.Bytes, reply.Timeout, elapsed)
+	}
+
+	// fewer than MinBytes by the end of the wait isn't a timeout
+	peer.Write([]byte("x"))
+	reply, elapsed = gameData(200, 10)
+	if string(reply.Bytes) != "x" || reply.Timeout || elapsed < 200 * time.Millisecond {
+		t.Errorf("MinBytes 10: got %q timeout %v after %v", reply.Bytes, reply.Timeout, elapsed)
+	}
+
+	// nothing at all is
+	reply, elapsed = gameData(100, 0)
+	if len(reply.Bytes) != 0 || !reply.Timeout || elapsed < 100 * time.Millisecond || elapsed > 500 * time.Millisecond {
+		t.Errorf("nothing: got %q timeout %v after %v", reply.Bytes, reply.Timeout, elapsed)
+	}
+
+	for _, req := range []*gamerpc.GameDataRequest{
+		{PlayerID: "a", Wait: gamerpc.MaxGameDataWait + 1},
+		{PlayerID: "a", Wait: -1},
+		{PlayerID: "a", MinBytes: gamerpc.MaxGameDataBytes + 1},
+	} {
+		err := huntd.GameData(req, &gamerpc.GameDataReply{})
+		if err == nil {
+			t.Errorf("GameData %+v accepted", req)
+		}
+	}
+}
+
+func TestInputKeepsCadence(t *testing.T) {
+	player, peer := testJoinedPlayer(t, "a")
+	huntd := testHuntDaemon(player)
+
+	req := &gamerpc.InputRequest{
+		PlayerID:	"a",
+		Keys:		"h",
+		Events:		[]gamerpc.InputEvent{{Keys: "j", Offset: 100}, {Keys: "k", Offset: 50}, {Keys: "l", Offset: 200}},
+	}
+
+	// keys arrive when they're due: h straight away, j and k (out of order, so sent with j) after 100ms, l after 200ms
+	arrived := make(chan time.Duration, 4)
+	start := time.Now()
+	go func() {
+		b := make([]byte, 1)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		for _, want := range "hjkl" {
+			_, err := io.ReadFull(peer, b)
+			if err != nil || rune(b[0]) != want {
+				break
+			}
+			arrived <- time.Since(start)
+		}
+		close(arrived)
+	}()
+
+	var reply gamerpc.InputReply
+	err := huntd.Input(req, &reply)
+	if err != nil || reply.Timeout {
+		t.Fatalf("Input: %v %+v", err, reply)
+	}
+
+	var got []time.Duration
+	for d := range arrived {
+		got = append(got, d)
+	}
+	if len(got) != 4 {
+		t.Fatalf("huntd got %d of the keys hjkl in order", len(got))
+	}
+
+	due := []time.Duration{0, 100 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, d := range got {
+		if d < due[i] || d > due[i] + 80 * time.Millisecond {
+			t.Errorf("key %c arrived after %v, due after %v", "hjkl"[i], d, due[i])
+		}
+	}
+}
+
+func TestExchangeWaitCoversInput(t *testing.T) {
+	if gamerpc.MaxInputSpan >= gamerpc.MaxExchangeWait || gamerpc.MaxExchangeWait + gamerpc.MaxInputSpan >= 5000 {
+		t.Fatalf("MaxExchangeWait %d and MaxInputSpan %d don't fit App Engine's fetch deadline", gamerpc.MaxExchangeWait, gamerpc.MaxInputSpan)
+	}
+
+	player, peer := testJoinedPlayer(t, "a")
+	huntd := testHuntDaemon(player)
+
+	req := &gamerpc.ExchangeRequest{
+		PlayerID:	"a",
+		Keys:		"h",
+		Events:		[]gamerpc.InputEvent{{Keys: "j", Offset: 300}},
+		Wait:		400,
+	}
+
+	start := time.Now()
+	var reply gamerpc.ExchangeReply
+	err := huntd.Exchange(req, &reply)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	// the keys took 300ms to send, leaving 100ms to wait for game data
+	if elapsed < 390 * time.Millisecond || elapsed > 600 * time.Millisecond {
+		t.Errorf("Exchange took %v, want about 400ms", elapsed)
+	}
+	if !reply.Timeout || reply.Input == nil {
+		t.Errorf("reply = %+v, want a timeout and an input reply", reply)
+	}
+
+	keys := make([]byte, 2)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(peer, keys)
+	if err != nil || string(keys) != "hj" {
+		t.Errorf("huntd got %q %v, want \"hj\"", keys, err)
+	}
+}
+
+func TestExchangeWaitsAtLeastNoWait(t *testing.T) {
+	player, peer := testJoinedPlayer(t, "a")
+	huntd := testHuntDaemon(player)
+
+	// keys that take longer than Wait still get game data already sent
+	peer.Write([]byte("x"))
+	time.Sleep(50 * time.Millisecond)
+
+	req := &gamerpc.ExchangeRequest{
+		PlayerID:	"a",
+		Events:		[]gamerpc.InputEvent{{Keys: "h", Offset: 100}},
+		Wait:		50,
+		Encoding:	gamerpc.GAMEDATA_BYTES,
+	}
+
+	var reply gamerpc.ExchangeReply
+	err := huntd.Exchange(req, &reply)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if reply.Timeout || string(reply.Bytes) != "x" {
+		t.Errorf("reply = %+v, want the game data already sent", reply)
+	}
+}
@@ -159,4 +159,6 @@ run exec /go/bin/server-game \
 	-huntd-well-known-port "${huntd_port}" \
 	-server-host "${server_host}" \
 	-server-port "${server_port}" \
+	-huntd-stop-command "pkill -x huntd" \
+	-huntd-start-command "/usr/sbin/huntd -s -p ${huntd_port} < /dev/null" \
 	-rpc-type "${rpc_type}"
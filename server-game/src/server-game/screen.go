@@ -0,0 +1,365 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Each player's game data is read from huntd as soon as it arrives, so huntd
+// never blocks writing to a player whose browser polls slowly.  The game
+// server keeps what huntd has drawn on the player's screen, and what it has
+// sent the client.  If the client falls more than StreamBacklog behind, the
+// queued game data is thrown away and the client is sent the difference
+// between the two screens instead.
+package main
+
+import(
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gamerpc"
+	"netutils"
+)
+
+const(
+	ScreenRows	= 24
+	ScreenCols	= 80
+	StreamBacklog	= 8 * gamerpc.MaxGameDataBytes	// game data queued for a client before it's replaced by a screen diff
+	StreamReadSize	= 4096
+	StreamIdleRead	= KeepAliveTimeout		// how long the reader waits for huntd between checks
+)
+
+// for all players since the game server started
+type StreamStats struct {
+	Coalesced	uint64	// backlogs replaced by a screen diff, accessed atomically
+	DroppedBytes	uint64	// game data in those backlogs, accessed atomically
+}
+
+//
+// The screen as drawn by a stream of game data, following the same rules as
+// the browser client.
+//
+type Screen struct {
+	cells	[ScreenRows][ScreenCols]byte
+	row	int
+	col	int
+}
+
+func NewScreen() *Screen {
+	s := &Screen{}
+	s.clear()
+
+	return s
+}
+
+func (s *Screen) clear() {
+	for row := range s.cells {
+		for col := range s.cells[row] {
+			s.cells[row][col] = ' '
+		}
+	}
+	s.row = 0
+	s.col = 0
+}
+
+func (s *Screen) move(row int, col int) {
+	if row >= ScreenRows {
+		row = ScreenRows - 1
+	}
+	if col >= ScreenCols {
+		col = ScreenCols - 1
+	}
+
+	s.row = row
+	s.col = col
+}
+
+// wraps at the end of a line, but never past the last row
+func (s *Screen) addch(c byte) {
+	s.cells[s.row][s.col] = c
+
+	s.col++
+	if s.col >= ScreenCols {
+		s.col = 0
+		s.row++
+	}
+	if s.row >= ScreenRows {
+		s.row = ScreenRows - 1
+	}
+}
+
+//
+// Draws data, which must be whole opcodes.  Opcodes that don't change what's
+// on the screen are ignored.
+//
+func (s *Screen) Apply(data []byte) {
+	for i := 0; i < len(data); {
+		op := data[i]
+		next := i + 1 + gamerpc.DrawArgs(op)
+		if next > len(data) {
+			return
+		}
+		args := data[i+1 : next]
+		i = next
+
+		switch op {
+		case gamerpc.DRAW_ADDCH:
+			s.addch(args[0])
+		case gamerpc.DRAW_MOVE:
+			s.move(int(args[0]), int(args[1]))
+		case gamerpc.DRAW_CLRTOEOL:
+			for col := s.col; col < ScreenCols; col++ {
+				s.cells[s.row][col] = ' '
+			}
+		case gamerpc.DRAW_CLEAR:
+			s.clear()
+		case gamerpc.DRAW_REDRAW, gamerpc.DRAW_BELL, gamerpc.DRAW_ENDWIN, gamerpc.DRAW_READY, gamerpc.DRAW_REFRESH:
+		default:
+			s.addch(op)
+		}
+	}
+}
+
+//
+// Returns the game data which turns the screen from into s: each run of
+// changed cells, then the cursor and a refresh.
+//
+func (s *Screen) Diff(from *Screen) []byte {
+	var data []byte
+
+	for row := 0; row < ScreenRows; row++ {
+		col := 0
+		for col < ScreenCols {
+			if s.cells[row][col] == from.cells[row][col] {
+				col++
+				continue
+			}
+
+			data = append(data, gamerpc.DRAW_MOVE, byte(row), byte(col))
+			for col < ScreenCols && s.cells[row][col] != from.cells[row][col] {
+				c := s.cells[row][col]
+				if gamerpc.IsDrawOp(c) {
+					data = append(data, gamerpc.DRAW_ADDCH)
+				}
+				data = append(data, c)
+				col++
+			}
+		}
+	}
+
+	return append(data, gamerpc.DRAW_MOVE, byte(s.row), byte(s.col), gamerpc.DRAW_REFRESH)
+}
+
+type GameStream struct {
+	conn		*netutils.TimeoutTCPConn
+	stats		*StreamStats
+	playerID	string
+
+	lock		sync.Mutex
+	changed		chan struct{}	// closed when there's more to read, then replaced
+	partial		[]byte		// an opcode whose arguments haven't all been read
+	pending		[]byte		// whole opcodes not yet sent to the client
+	screen		*Screen		// with everything read from huntd drawn
+	client		*Screen		// with everything sent to the client drawn
+	behind		bool		// pending was thrown away, the client needs a screen diff
+	held		[]byte		// the last READY and ENDWIN while behind, in the order they came
+	coalesced	uint64		// how many times the client fell behind
+	err		error		// why reading from huntd stopped
+}
+
+func NewGameStream(playerID string, conn *netutils.TimeoutTCPConn, stats *StreamStats) *GameStream {
+	return &GameStream{
+		conn:		conn,
+		stats:		stats,
+		playerID:	playerID,
+		changed:	make(chan struct{}),
+		screen:		NewScreen(),
+		client:		NewScreen(),
+	}
+}
+
+// reads from huntd until the connection fails or is closed
+func (gs *GameStream) Run() {
+	buf := make([]byte, StreamReadSize)
+
+	for {
+		n, err := gs.conn.ReadTimeout(buf, StreamIdleRead)
+		if n > 0 {
+			gs.add(buf[:n])
+		}
+		if err != nil {
+			nerr, isNetErr := err.(net.Error)
+			if isNetErr && nerr.Timeout() {
+				continue
+			}
+
+			logger.Log(LOG_PLAYER_API, "Player %s: GameStream: %v", gs.playerID, err)
+
+			gs.lock.Lock()
+			gs.err = err
+			gs.notify()
+			gs.lock.Unlock()
+			return
+		}
+	}
+}
+
+// must hold lock
+func (gs *GameStream) notify() {
+	close(gs.changed)
+	gs.changed = make(chan struct{})
+}
+
+func (gs *GameStream) add(data []byte) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	gs.partial = append(gs.partial, data...)
+	complete := gamerpc.CompleteGameData(gs.partial)
+	ops := gs.partial[:complete]
+
+	gs.screen.Apply(ops)
+
+	if gs.behind {
+		gs.hold(ops)
+	} else {
+		gs.pending = append(gs.pending, ops...)
+		if len(gs.pending) > StreamBacklog {
+			logger.Log(LOG_PLAYER_API, "Player %s: GameStream: %d bytes behind, coalescing", gs.playerID, len(gs.pending))
+
+			gs.hold(gs.pending)
+			atomic.AddUint64(&gs.stats.Coalesced, 1)
+			atomic.AddUint64(&gs.stats.DroppedBytes, uint64(len(gs.pending)))
+			gs.coalesced++
+			gs.pending = nil
+			gs.behind = true
+		}
+	}
+
+	gs.partial = append([]byte(nil), gs.partial[complete:]...)
+
+	if complete > 0 {
+		gs.notify()
+	}
+}
+
+// keeps the opcodes a screen diff can't stand in for
+func (gs *GameStream) hold(ops []byte) {
+	for i := 0; i < len(ops); {
+		op := ops[i]
+		next := i + 1 + gamerpc.DrawArgs(op)
+		if next > len(ops) {
+			return
+		}
+
+		switch op {
+		case gamerpc.DRAW_READY, gamerpc.DRAW_ENDWIN:
+			gs.held = append(unhold(gs.held, op), ops[i:next]...)
+		}
+
+		i = next
+	}
+}
+
+// held without op, which holds at most one of each
+func unhold(held []byte, op byte) []byte {
+	for i := 0; i < len(held); i += 1 + gamerpc.DrawArgs(held[i]) {
+		if held[i] == op {
+			return append(held[:i], held[i + 1 + gamerpc.DrawArgs(op):]...)
+		}
+	}
+
+	return held
+}
+
+// must hold lock
+func (gs *GameStream) catchUp() {
+	gs.pending = gs.screen.Diff(gs.client)
+	gs.pending = append(gs.pending, gs.held...)
+	gs.held = nil
+	gs.behind = false
+}
+
+//
+// Waits until at least minBytes of game data are queued or wait has passed,
+// then returns up to MaxGameDataBytes of it.  Only returns a timeout error if
+// there was nothing at all.
+//
+func (gs *GameStream) Read(wait time.Duration, minBytes int) (error, []byte, error) {
+	if minBytes < 1 {
+		minBytes = 1
+	}
+
+	deadline := time.Now().Add(wait)
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	for {
+		if gs.behind {
+			gs.catchUp()
+		}
+
+		if len(gs.pending) >= minBytes {
+			break
+		}
+
+		if gs.err != nil {
+			if len(gs.pending) > 0 {
+				break
+			}
+			return nil, nil, gs.err
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			break
+		}
+
+		changed := gs.changed
+		gs.lock.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		gs.lock.Lock()
+	}
+
+	if len(gs.pending) == 0 {
+		return ErrGameDataTimeout, nil, nil
+	}
+
+	n := len(gs.pending)
+	if n > gamerpc.MaxGameDataBytes {
+		n = gamerpc.CompleteGameData(gs.pending[:gamerpc.MaxGameDataBytes])
+	}
+
+	data := append([]byte(nil), gs.pending[:n]...)
+	gs.pending = gs.pending[n:]
+	gs.client.Apply(data)
+
+	return nil, data, nil
+}
+
+// how many times the client fell behind and was sent a screen diff
+func (gs *GameStream) Coalesced() uint64 {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return gs.coalesced
+}
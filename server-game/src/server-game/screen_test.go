@@ -0,0 +1,250 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import(
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gamerpc"
+)
+
+func testStream() *GameStream {
+	return NewGameStream("a", nil, &StreamStats{})
+}
+
+// reads everything queued on gs, checking each read is whole opcodes
+func readAll(t *testing.T, gs *GameStream) []byte {
+	var all []byte
+
+	for {
+		timeoutErr, data, err := gs.Read(0, 1)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if timeoutErr != nil {
+			return all
+		}
+
+		if len(data) > gamerpc.MaxGameDataBytes {
+			t.Fatalf("read %d bytes, more than %d", len(data), gamerpc.MaxGameDataBytes)
+		}
+		if gamerpc.CompleteGameData(data) != len(data) {
+			t.Fatalf("read ends mid opcode: % x", data[len(data)-3:])
+		}
+		all = append(all, data...)
+	}
+}
+
+func TestReadTruncatesOnOpcodeBoundary(t *testing.T) {
+	ops := [][]byte{
+		{gamerpc.DRAW_MOVE, 3, 4},
+		{gamerpc.DRAW_ADDCH, gamerpc.DRAW_MOVE},
+		{gamerpc.DRAW_READY, 7},
+		{gamerpc.DRAW_CLEAR},
+		{200},
+	}
+
+	// put each opcode across the MaxGameDataBytes boundary at every offset
+	for _, op := range ops {
+		for before := gamerpc.MaxGameDataBytes - len(op); before <= gamerpc.MaxGameDataBytes; before++ {
+			data := bytes.Repeat([]byte{'a'}, before)
+			data = append(data, op...)
+			data = append(data, bytes.Repeat([]byte{'b'}, 100)...)
+
+			gs := testStream()
+			gs.add(data)
+
+			got := readAll(t, gs)
+			if !bytes.Equal(got, data) {
+				t.Errorf("op % x after %d bytes: read back %d bytes, want %d", op, before, len(got), len(data))
+			}
+		}
+	}
+}
+
+func TestReadHoldsPartialOpcodes(t *testing.T) {
+	gs := testStream()
+
+	gs.add([]byte{'a', gamerpc.DRAW_MOVE, 1})
+	timeoutErr, data, _ := gs.Read(10 * time.Millisecond, 1)
+	if timeoutErr != nil || string(data) != "a" {
+		t.Fatalf("first read = %q %v, want \"a\"", data, timeoutErr)
+	}
+
+	timeoutErr, data, _ = gs.Read(10 * time.Millisecond, 1)
+	if timeoutErr != ErrGameDataTimeout {
+		t.Fatalf("read of half a MOVE = % x %v, want a timeout", data, timeoutErr)
+	}
+
+	gs.add([]byte{2})
+	_, data, _ = gs.Read(10 * time.Millisecond, 1)
+	if !bytes.Equal(data, []byte{gamerpc.DRAW_MOVE, 1, 2}) {
+		t.Fatalf("read = % x, want the whole MOVE", data)
+	}
+}
+
+func screenText(s *Screen) string {
+	var b bytes.Buffer
+	for row := range s.cells {
+		b.Write(s.cells[row][:])
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func checkDiff(t *testing.T, name string, from *Screen, to *Screen) {
+	client := *from
+	client.Apply(to.Diff(from))
+
+	if client.cells != to.cells {
+		t.Errorf("%s: cells differ after applying the diff:\n%s\nwant\n%s", name, screenText(&client), screenText(to))
+	}
+	if client.row != to.row || client.col != to.col {
+		t.Errorf("%s: cursor at %d,%d after applying the diff, want %d,%d", name, client.row, client.col, to.row, to.col)
+	}
+}
+
+func drawn(data ...byte) *Screen {
+	s := NewScreen()
+	s.Apply(data)
+
+	return s
+}
+
+func TestScreenDiff(t *testing.T) {
+	const(
+		MOVE		= gamerpc.DRAW_MOVE
+		ADDCH		= gamerpc.DRAW_ADDCH
+		CLEAR		= gamerpc.DRAW_CLEAR
+		CLRTOEOL	= gamerpc.DRAW_CLRTOEOL
+	)
+
+	tests := []struct {
+		name	string
+		from	*Screen
+		to	*Screen
+	}{
+		{"same",		drawn('a'),						drawn('a')},
+		{"plain",		NewScreen(),						drawn(MOVE, 3, 10, 'h', 'i')},
+		{"opcode bytes",	NewScreen(),						drawn(ADDCH, MOVE, ADDCH, CLEAR, ADDCH, gamerpc.DRAW_READY, 200)},
+		{"clrtoeol",		drawn(MOVE, 5, 0, 'x', 'y', 'z'),			drawn(MOVE, 5, 0, 'x', 'y', 'z', MOVE, 5, 1, CLRTOEOL)},
+		{"clear",		drawn(MOVE, 10, 10, 'q', MOVE, 23, 79, 'r'),		drawn(MOVE, 10, 10, 'q', CLEAR, 'n')},
+		{"last column",		NewScreen(),						drawn(MOVE, 2, 200, 'e')},
+		{"last row",		NewScreen(),						drawn(MOVE, 200, 3, 'w')},
+		{"clamped cursor",	NewScreen(),						drawn(MOVE, 200, 200)},
+		{"bottom corner",	NewScreen(),						drawn(MOVE, 23, 78, 'a', 'b', 'c')},
+		{"into the last cell",	drawn(MOVE, 23, 79, 'z'),				drawn(MOVE, 23, 79, ADDCH, MOVE)},
+	}
+
+	for _, test := range tests {
+		checkDiff(t, test.name, test.from, test.to)
+	}
+}
+
+func randomOps(rnd *rand.Rand, n int) []byte {
+	var data []byte
+	for i := 0; i < n; i++ {
+		switch rnd.Intn(10) {
+		case 0:
+			data = append(data, gamerpc.DRAW_MOVE, byte(rnd.Intn(30)), byte(rnd.Intn(90)))
+		case 1:
+			data = append(data, gamerpc.DRAW_CLRTOEOL)
+		case 2:
+			data = append(data, gamerpc.DRAW_ADDCH, byte(rnd.Intn(256)))
+		case 3:
+			if rnd.Intn(20) == 0 {
+				data = append(data, gamerpc.DRAW_CLEAR)
+			}
+		default:
+			c := byte(rnd.Intn(256))
+			if gamerpc.IsDrawOp(c) {
+				c = 'x'
+			}
+			data = append(data, c)
+		}
+	}
+
+	return data
+}
+
+func TestScreenDiffRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		from := drawn(randomOps(rnd, 500)...)
+		to := *from
+		to.Apply(randomOps(rnd, rnd.Intn(500)))
+
+		checkDiff(t, "random", from, &to)
+		checkDiff(t, "random from blank", NewScreen(), &to)
+	}
+}
+
+// pushes a backlog through gs, so that it falls behind
+func fallBehind(gs *GameStream) {
+	gs.add(bytes.Repeat([]byte{'a'}, StreamBacklog + 1))
+}
+
+func TestBehindKeepsReadyAndEndwinInOrder(t *testing.T) {
+	tests := []struct {
+		name	string
+		data	[]byte
+		held	[]byte
+	}{
+		{"ready",		[]byte{gamerpc.DRAW_READY, 1, 'x', gamerpc.DRAW_READY, 2},		[]byte{gamerpc.DRAW_READY, 2}},
+		{"ready then endwin",	[]byte{gamerpc.DRAW_READY, 3, gamerpc.DRAW_ENDWIN, 'q'},		[]byte{gamerpc.DRAW_READY, 3, gamerpc.DRAW_ENDWIN, 'q'}},
+		{"endwin then ready",	[]byte{gamerpc.DRAW_ENDWIN, 'q', gamerpc.DRAW_READY, 4},		[]byte{gamerpc.DRAW_ENDWIN, 'q', gamerpc.DRAW_READY, 4}},
+		{"last of each",	[]byte{gamerpc.DRAW_ENDWIN, 'a', gamerpc.DRAW_READY, 5, gamerpc.DRAW_ENDWIN, 'b'},	[]byte{gamerpc.DRAW_READY, 5, gamerpc.DRAW_ENDWIN, 'b'}},
+	}
+
+	for _, test := range tests {
+		gs := testStream()
+		fallBehind(gs)
+		gs.add(test.data)
+
+		got := readAll(t, gs)
+
+		// the screen diff, then what a diff can't stand in for
+		screen := NewScreen()
+		screen.Apply(got)
+		if screen.cells != gs.screen.cells {
+			t.Errorf("%s: screen not caught up", test.name)
+		}
+		if !bytes.HasSuffix(got, test.held) {
+			t.Errorf("%s: read ends % x, want % x", test.name, got[len(got) - len(test.held):], test.held)
+		}
+		if gs.Coalesced() != 1 {
+			t.Errorf("%s: coalesced %d times, want 1", test.name, gs.Coalesced())
+		}
+	}
+}
+
+func TestBehindHoldsReadyFromTheBacklog(t *testing.T) {
+	gs := testStream()
+
+	gs.add([]byte{gamerpc.DRAW_READY, 9})
+	fallBehind(gs)
+
+	got := readAll(t, gs)
+	if !bytes.HasSuffix(got, []byte{gamerpc.DRAW_READY, 9}) {
+		t.Errorf("READY from the thrown away backlog lost: read ends % x", got[len(got) - 2:])
+	}
+}
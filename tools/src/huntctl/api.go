@@ -0,0 +1,190 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// Client for the frontend's public and admin APIs.  The frontend package
+// itself only builds for App Engine or the standalone server, so the replies
+// it defines are mirrored here.
+package main
+
+import(
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"apputils"
+	"gamerpc"
+)
+
+const(
+	RequestTimeout	= 60 * time.Second	// restarting huntd can take a while
+)
+
+type GameInstance struct {
+	InstanceID	string
+	URL		string
+	Hostname	string
+	Seq		uint64
+	FirstSeen	time.Time
+	LastSeen	time.Time
+	Status		*apputils.InstanceStatus
+	UnhealthyUntil	time.Time
+	Healthy		bool
+}
+
+type InstancesReply struct {
+	Instances	[]*GameInstance
+}
+
+type AnnounceRequest struct {
+	Name	string
+	Message	string
+}
+
+// What the commands need, from the frontend or straight from game servers
+type API interface {
+	Instances() ([]*GameInstance, error)
+	Info(instance string) (*gamerpc.ServerInfo, error)
+	Stats(instance string) (string, error)
+	Players(instance string) ([]*gamerpc.PlayerInfo, error)
+	Kick(instance string, playerID string) error
+	Announce(instance string, name string, message string) error
+	Drain(instance string, draining bool) error
+	Restart(instance string) error
+}
+
+type Client struct {
+	Frontend	string	// base URL, e.g. https://project.appspot.com
+	Token		string	// admin token
+	http		*http.Client
+}
+
+func NewClient(frontend string, token string) *Client {
+	return &Client{
+		Frontend:	strings.TrimRight(frontend, "/"),
+		Token:		token,
+		http:		&http.Client{Timeout: RequestTimeout},
+	}
+}
+
+//
+// Sends request (if non-nil) as JSON to the path under /api/v1, and decodes
+// the reply into reply.
+//
+func (c *Client) call(method string, path string, request interface{}, reply interface{}) error {
+	var body []byte
+	var err error
+
+	if request != nil {
+		body, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.Frontend + "/api/v1/" + path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json;charset=utf-8")
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer " + c.Token)
+	}
+
+	rsp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", method, path, rsp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return json.Unmarshal(data, reply)
+}
+
+func (c *Client) Instances() ([]*GameInstance, error) {
+	var reply InstancesReply
+	err := c.call("GET", "instances", nil, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Instances, nil
+}
+
+func (c *Client) Info(instance string) (*gamerpc.ServerInfo, error) {
+	var reply gamerpc.ServerInfo
+	err := c.call("GET", "info/" + instance, nil, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+func (c *Client) Stats(instance string) (string, error) {
+	var reply gamerpc.StatsReply
+	err := c.call("GET", "stats/" + instance, nil, &reply)
+	if err != nil {
+		return "", err
+	}
+
+	return reply.Stats, nil
+}
+
+func (c *Client) Players(instance string) ([]*gamerpc.PlayerInfo, error) {
+	var reply gamerpc.PlayersReply
+	err := c.call("GET", "admin/players/" + instance, nil, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Players, nil
+}
+
+func (c *Client) Kick(instance string, playerID string) error {
+	var reply gamerpc.KickReply
+	return c.call("POST", "admin/kick/" + instance, &gamerpc.KickRequest{PlayerID: playerID}, &reply)
+}
+
+func (c *Client) Announce(instance string, name string, message string) error {
+	var reply gamerpc.MessageReply
+	return c.call("POST", "admin/announce/" + instance, &AnnounceRequest{Name: name, Message: message}, &reply)
+}
+
+func (c *Client) Drain(instance string, draining bool) error {
+	var reply gamerpc.DrainReply
+	return c.call("POST", "admin/drain/" + instance, &gamerpc.DrainRequest{Draining: draining}, &reply)
+}
+
+func (c *Client) Restart(instance string) error {
+	var reply gamerpc.RestartReply
+	return c.call("POST", "admin/restart/" + instance, &gamerpc.RestartRequest{}, &reply)
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import(
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gamerpc"
+)
+
+type frontendCall struct {
+	method	string
+	path	string
+	auth	string
+	body	string
+}
+
+// a frontend that records each call and answers with reply
+func testFrontend(t *testing.T, reply interface{}) (*httptest.Server, *[]frontendCall) {
+	var calls []frontendCall
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		calls = append(calls, frontendCall{r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)})
+
+		if r.Header.Get("Authorization") != "Bearer adm" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+
+	return srv, &calls
+}
+
+func TestClientAdminCalls(t *testing.T) {
+	srv, calls := testFrontend(t, map[string]int{"Token": 0})
+	defer srv.Close()
+
+	c := NewClient(srv.URL + "/", "adm")
+
+	err := c.Kick("3", "p1")
+	if err != nil {
+		t.Fatalf("Kick: %v", err)
+	}
+	err = c.Drain("3", false)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	want := []frontendCall{
+		{"POST", "/api/v1/admin/kick/3", "Bearer adm", `{"Token":0,"PlayerID":"p1"}`},
+		{"POST", "/api/v1/admin/drain/3", "Bearer adm", `{"Token":0,"Draining":false}`},
+	}
+	if len(*calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", *calls, want)
+	}
+	for i, call := range *calls {
+		if call != want[i] {
+			t.Errorf("call %d = %+v, want %+v", i, call, want[i])
+		}
+	}
+}
+
+func TestClientReportsStatus(t *testing.T) {
+	srv, _ := testFrontend(t, nil)
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "wrong").Players("3")
+	if err == nil {
+		t.Fatalf("Players with a bad token succeeded")
+	}
+}
+
+func TestClientPlayers(t *testing.T) {
+	srv, calls := testFrontend(t, &gamerpc.PlayersReply{Players: []*gamerpc.PlayerInfo{{PlayerID: "p1", Name: "fred"}}})
+	defer srv.Close()
+
+	players, err := NewClient(srv.URL, "adm").Players("3")
+	if err != nil {
+		t.Fatalf("Players: %v", err)
+	}
+	if len(players) != 1 || players[0].Name != "fred" {
+		t.Errorf("players = %v", players)
+	}
+	if (*calls)[0].path != "/api/v1/admin/players/3" {
+		t.Errorf("path = %s", (*calls)[0].path)
+	}
+}
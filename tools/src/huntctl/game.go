@@ -0,0 +1,167 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////////////////////////////
+//
+// Client for game servers' JSON-RPC API, for when the frontend is down or an
+// instance isn't registered with it.  Requests are signed the way the
+// frontend signs them, so SERVER_GAME_RPC_KEYS must hold a key the game
+// servers accept; without one they refuse the admin RPCs.
+package main
+
+import(
+	"errors"
+	"net/http"
+	"strings"
+
+	"apputils"
+	"gamerpc"
+)
+
+const(
+	DefaultAnnounceName	= "operator"	// as the frontend's admin announce
+)
+
+var ErrNoRegistry = errors.New("game servers don't know about each other, list instances through the frontend")
+
+type GameServers struct {
+	URL	string	// JSON-RPC URL, "{{instance}}" is replaced by the instance
+	Signer	*apputils.RequestSigner
+}
+
+func NewGameServers(url string, keys string) (*GameServers, error) {
+	signer, err := apputils.NewRequestSigner(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GameServers{URL: url, Signer: signer}, nil
+}
+
+func (g *GameServers) client(instance string) (*gamerpc.GameClient, *http.Request, error) {
+	game, err := gamerpc.NewGameClient(strings.Replace(g.URL, "{{instance}}", instance, -1), "jsonrpc", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	game.Signer = g.Signer
+	game.Timeout = RequestTimeout
+
+	// the client expects the request it is serving, for platform services
+	r, err := http.NewRequest("GET", game.URL.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return game, r, nil
+}
+
+func (g *GameServers) Instances() ([]*GameInstance, error) {
+	return nil, ErrNoRegistry
+}
+
+func (g *GameServers) Info(instance string) (*gamerpc.ServerInfo, error) {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return game.Info(r)
+}
+
+func (g *GameServers) Stats(instance string) (string, error) {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := game.Stats(r, &gamerpc.StatsRequest{})
+	if err != nil {
+		return "", err
+	}
+
+	return reply.Stats, nil
+}
+
+func (g *GameServers) Players(instance string) ([]*gamerpc.PlayerInfo, error) {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := game.ListPlayers(r, &gamerpc.PlayersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Players, nil
+}
+
+func (g *GameServers) Kick(instance string, playerID string) error {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return err
+	}
+
+	_, err = game.Kick(r, &gamerpc.KickRequest{PlayerID: playerID})
+
+	return err
+}
+
+// sends the message the same way the frontend's admin announce does
+func (g *GameServers) Announce(instance string, name string, message string) error {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = DefaultAnnounceName
+	}
+
+	_, err = game.Message(r, &gamerpc.MessageRequest{
+		Message:	message,
+		Join:		gamerpc.JoinRequest{
+					Name:		name,
+					Team:		apputils.TEAM_NONE,
+					EnterStatus:	gamerpc.Q_FLY,
+					Ttyname:	"/dev/tty-admin",
+					ConnectMode:	gamerpc.C_MESSAGE,
+				},
+	})
+
+	return err
+}
+
+func (g *GameServers) Drain(instance string, draining bool) error {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return err
+	}
+
+	_, err = game.Drain(r, &gamerpc.DrainRequest{Draining: draining})
+
+	return err
+}
+
+func (g *GameServers) Restart(instance string) error {
+	game, r, err := g.client(instance)
+	if err != nil {
+		return err
+	}
+
+	_, err = game.Restart(r, &gamerpc.RestartRequest{})
+
+	return err
+}
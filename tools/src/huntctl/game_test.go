@@ -0,0 +1,131 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import(
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apputils"
+	"gamerpc"
+)
+
+type rpcCall struct {
+	path	string
+	method	string
+	params	json.RawMessage
+}
+
+//
+// A game server that checks requests are signed with keys, records each
+// JSON-RPC call and answers it with an empty result.
+//
+func testGameServer(t *testing.T, keys string) (*httptest.Server, *[]rpcCall) {
+	verifier, err := apputils.NewRequestVerifier(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []rpcCall
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method	string
+			Params	[]json.RawMessage
+			Id	uint64
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || len(req.Params) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		calls = append(calls, rpcCall{r.URL.Path, req.Method, req.Params[0]})
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{}, "error": nil, "id": req.Id})
+	})
+
+	return httptest.NewServer(verifier.Handler(handler)), &calls
+}
+
+func TestGameServersSignedCalls(t *testing.T) {
+	srv, calls := testGameServer(t, "k:secret")
+	defer srv.Close()
+
+	games, err := NewGameServers(srv.URL + "/{{instance}}/jsonrpc", "k:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = games.Drain("7", true)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	err = games.Announce("7", "", "hello")
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+
+	if len(*calls) != 2 {
+		t.Fatalf("calls = %v, want 2", *calls)
+	}
+
+	drain := (*calls)[0]
+	if drain.path != "/7/jsonrpc" || drain.method != "HuntDaemon.JDrain" || string(drain.params) != `{"Token":0,"Draining":true}` {
+		t.Errorf("drain call = %s %s %s", drain.path, drain.method, drain.params)
+	}
+
+	var message gamerpc.MessageRequest
+	json.Unmarshal((*calls)[1].params, &message)
+	if (*calls)[1].method != "HuntDaemon.JMessage" || message.Message != "hello" || message.Join.Name != DefaultAnnounceName || message.Join.ConnectMode != gamerpc.C_MESSAGE {
+		t.Errorf("announce call = %s %s", (*calls)[1].method, (*calls)[1].params)
+	}
+}
+
+func TestGameServersWrongKey(t *testing.T) {
+	srv, calls := testGameServer(t, "k:secret")
+	defer srv.Close()
+
+	for _, keys := range []string{"", "k:other", "j:secret"} {
+		games, err := NewGameServers(srv.URL + "/jsonrpc", keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = games.Kick("0", "p1")
+		if err == nil {
+			t.Errorf("keys '%s': Kick succeeded", keys)
+		}
+	}
+
+	if len(*calls) != 0 {
+		t.Errorf("unsigned calls reached the game server: %v", *calls)
+	}
+}
+
+func TestGameServersInstances(t *testing.T) {
+	games, err := NewGameServers("http://localhost:1/jsonrpc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = games.Instances()
+	if err != ErrNoRegistry {
+		t.Errorf("Instances: got %v, want %v", err, ErrNoRegistry)
+	}
+}
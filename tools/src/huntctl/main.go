@@ -0,0 +1,397 @@
+// Copyright 2016 The Web BSD Hunt Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+//
+// huntctl operates a running deployment through the frontend's public and
+// admin APIs.  Everything except listing instances, info and stats needs one
+// of the frontend's SERVER_FRONTEND_ADMIN_TOKENS.  With -game it talks to
+// game servers directly instead, signing requests with -rpc-keys.
+//
+//	huntctl [-frontend url] [-token token] [-game url] [-rpc-keys keys] [-json] command [args]
+//
+//	instances			instances and rooms with their load (frontend only)
+//	info <instance>			the game server's version, health and load
+//	players <instance>		players with join time and last activity
+//	stats [-follow d] <instance>	huntd's stats, optionally repeated every d
+//	kick <instance> <player-id>	disconnect a player
+//	announce [-name n] <instance> <message...>
+//	drain <instance>		stop accepting new players
+//	undrain <instance>		accept new players again
+//	restart <instance>		restart huntd, disconnecting everyone
+//
+// There is no command to fetch recordings: games aren't recorded, so there
+// would be nothing to fetch until the game server records them.
+package main
+
+import(
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const(
+	DefaultFrontend	= "http://localhost:8080"
+)
+
+type command struct {
+	name	string
+	usage	string
+	run	func(c API, args []string) error
+}
+
+var commands []*command
+var jsonOutput bool
+
+func init() {
+	commands = []*command{
+		{"instances",	"",				instancesCommand},
+		{"info",	"<instance>",			infoCommand},
+		{"players",	"<instance>",			playersCommand},
+		{"stats",	"[-follow d] <instance>",	statsCommand},
+		{"kick",	"<instance> <player-id>",	kickCommand},
+		{"announce",	"[-name n] <instance> <message...>",	announceCommand},
+		{"drain",	"<instance>",			drainCommand},
+		{"undrain",	"<instance>",			undrainCommand},
+		{"restart",	"<instance>",			restartCommand},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: huntctl [flags] command [args]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func getenv(name string, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+func main() {
+	var frontend string
+	var token string
+	var gameURL string
+	var rpcKeys string
+
+	flag.StringVar(&frontend, "frontend", getenv("HUNTCTL_FRONTEND", DefaultFrontend), "frontend base URL (env HUNTCTL_FRONTEND)")
+	flag.StringVar(&token, "token", os.Getenv("HUNTCTL_TOKEN"), "admin token (env HUNTCTL_TOKEN)")
+	flag.StringVar(&gameURL, "game", os.Getenv("HUNTCTL_GAME"), "talk to game servers at this JSON-RPC URL instead of the frontend, {{instance}} is replaced by the instance (env HUNTCTL_GAME)")
+	flag.StringVar(&rpcKeys, "rpc-keys", os.Getenv("SERVER_GAME_RPC_KEYS"), "id:secret keys to sign game server requests with, the first is used (env SERVER_GAME_RPC_KEYS)")
+	flag.BoolVar(&jsonOutput, "json", false, "print JSON instead of tables")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var api API = NewClient(frontend, token)
+	if gameURL != "" {
+		games, err := NewGameServers(gameURL, rpcKeys)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "huntctl: -rpc-keys: %v\n", err)
+			os.Exit(2)
+		}
+		api = games
+
+		// the game server client logs as if it were part of a server, errors are reported anyway
+		log.SetOutput(ioutil.Discard)
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(api, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "huntctl %s: %s\n", name, strings.TrimSpace(err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "huntctl: unknown command '%s'\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// parses a command's flags, requiring at least n positional args
+func parseArgs(fs *flag.FlagSet, args []string, n int, usage string) ([]string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() < n {
+		return nil, fmt.Errorf("usage: huntctl %s %s", fs.Name(), usage)
+	}
+
+	return fs.Args(), nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return time.Since(t).Truncate(time.Second).String()
+}
+
+// prints a message for commands that have nothing else to show
+func done(format string, args ...interface{}) error {
+	if jsonOutput {
+		return printJSON(map[string]string{"Result": fmt.Sprintf(format, args...)})
+	}
+
+	fmt.Printf(format + "\n", args...)
+
+	return nil
+}
+
+func instancesCommand(c API, args []string) error {
+	_, err := parseArgs(flag.NewFlagSet("instances", flag.ExitOnError), args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	instances, err := c.Instances()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+
+	if jsonOutput {
+		return printJSON(instances)
+	}
+
+	tw := newTable()
+	fmt.Fprintf(tw, "INSTANCE\tROOM\tPLAYERS\tMAX\tDRAINING\tHEALTHY\tVERSION\tHOST\tLAST SEEN\n")
+	for _, instance := range instances {
+		status := instance.Status
+		if status == nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t%v\t-\t%s\t%s\n", instance.InstanceID, instance.Healthy, instance.Hostname, age(instance.LastSeen))
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t*\t%d\t%d\t%v\t%v\t%s\t%s\t%s\n", instance.InstanceID, status.Players, status.MaxPlayers, status.Draining, instance.Healthy, status.Version, instance.Hostname, age(instance.LastSeen))
+		for _, room := range status.Rooms {
+			fmt.Fprintf(tw, "\t%s\t%d\t%d\t\t\t\t\t\n", room.RoomID, room.Players, room.MaxPlayers)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func infoCommand(c API, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("info", flag.ExitOnError), args, 1, "<instance>")
+	if err != nil {
+		return err
+	}
+
+	info, err := c.Info(args[0])
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(info)
+	}
+
+	health := "ok"
+	if !info.Healthy {
+		health = info.Health
+	}
+
+	tw := newTable()
+	fmt.Fprintf(tw, "VERSION\t%s\n", info.Version)
+	fmt.Fprintf(tw, "UPTIME\t%s\n", time.Duration(info.Uptime) * time.Second)
+	fmt.Fprintf(tw, "ADDRESS\t%s:%s (%s, tls %v)\n", info.Host, info.Port, info.RpcType, info.TLS)
+	fmt.Fprintf(tw, "HUNTD\t%s (game %s, stats %s)\n", info.Huntd.WellKnownAddr, info.Huntd.GameAddr, info.Huntd.StatsAddr)
+	fmt.Fprintf(tw, "HEALTH\t%s\n", health)
+	fmt.Fprintf(tw, "PLAYERS\t%d/%d in %d rooms\n", info.Players, info.MaxPlayers, info.Rooms)
+	fmt.Fprintf(tw, "DRAINING\t%v\n", info.Draining)
+	fmt.Fprintf(tw, "COALESCED\t%d (%d bytes)\n", info.Coalesced, info.CoalescedBytes)
+
+	return tw.Flush()
+}
+
+func playersCommand(c API, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("players", flag.ExitOnError), args, 1, "<instance>")
+	if err != nil {
+		return err
+	}
+
+	players, err := c.Players(args[0])
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(players, func(i, j int) bool { return players[i].Joined.Before(players[j].Joined) })
+
+	if jsonOutput {
+		return printJSON(players)
+	}
+
+	tw := newTable()
+	fmt.Fprintf(tw, "PLAYER\tNAME\tTEAM\tUID\tJOINED\tLAST INPUT\tLAST SEEN\tCOALESCED\n")
+	for _, p := range players {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%d\n", p.PlayerID, p.Name, p.Team, p.Uid, age(p.Joined), age(p.LastInput), age(p.LastSeen), p.Coalesced)
+	}
+
+	return tw.Flush()
+}
+
+//
+// With -follow the stats are fetched every interval and printed whenever
+// they change, until interrupted.
+//
+func statsCommand(c API, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	follow := fs.Duration("follow", 0, "fetch the stats again every interval")
+
+	args, err := parseArgs(fs, args, 1, "[-follow d] <instance>")
+	if err != nil {
+		return err
+	}
+
+	last := ""
+	for {
+		stats, err := c.Stats(args[0])
+		if err != nil {
+			return err
+		}
+
+		if stats != last {
+			if jsonOutput {
+				err = printJSON(map[string]interface{}{"Time": time.Now(), "Stats": stats})
+				if err != nil {
+					return err
+				}
+			} else {
+				if *follow > 0 {
+					fmt.Printf("--- %s\n", time.Now().Format(time.RFC3339))
+				}
+				fmt.Print(stats)
+				if !strings.HasSuffix(stats, "\n") {
+					fmt.Println()
+				}
+			}
+			last = stats
+		}
+
+		if *follow <= 0 {
+			return nil
+		}
+
+		time.Sleep(*follow)
+	}
+}
+
+func kickCommand(c API, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("kick", flag.ExitOnError), args, 2, "<instance> <player-id>")
+	if err != nil {
+		return err
+	}
+
+	err = c.Kick(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return done("kicked %s from %s", args[1], args[0])
+}
+
+func announceCommand(c API, args []string) error {
+	fs := flag.NewFlagSet("announce", flag.ExitOnError)
+	name := fs.String("name", "", "code name the message appears to be from")
+
+	args, err := parseArgs(fs, args, 2, "[-name n] <instance> <message...>")
+	if err != nil {
+		return err
+	}
+
+	err = c.Announce(args[0], *name, strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	return done("announced to %s", args[0])
+}
+
+func drain(c API, name string, args []string, draining bool) error {
+	args, err := parseArgs(flag.NewFlagSet(name, flag.ExitOnError), args, 1, "<instance>")
+	if err != nil {
+		return err
+	}
+
+	err = c.Drain(args[0], draining)
+	if err != nil {
+		return err
+	}
+
+	return done("%s draining: %v", args[0], draining)
+}
+
+func drainCommand(c API, args []string) error {
+	return drain(c, "drain", args, true)
+}
+
+func undrainCommand(c API, args []string) error {
+	return drain(c, "undrain", args, false)
+}
+
+func restartCommand(c API, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("restart", flag.ExitOnError), args, 1, "<instance>")
+	if err != nil {
+		return err
+	}
+
+	err = c.Restart(args[0])
+	if err != nil {
+		return err
+	}
+
+	return done("restarted huntd on %s", args[0])
+}